@@ -0,0 +1,43 @@
+package api
+
+type FuncReg map[string]GoFunction
+
+// auxiliary library, helper functions built on the basic API
+type AuxLib interface {
+	/* error-report functions */
+	Errorf(format string, a ...interface{}) int
+	ArgError(arg int, extraMsg string) int
+	Where(level int)
+	Traceback(l1 LuaState, msg string, level int)
+	/* argument check functions */
+	ArgCheck(cond bool, arg int, extraMsg string)
+	CheckAny(arg int)
+	CheckType(arg int, t LuaType)
+	CheckInteger(arg int) int64
+	CheckNumber(arg int) float64
+	CheckString(arg int) string
+	OptInteger(arg int, d int64) int64
+	OptNumber(arg int, d float64) float64
+	OptString(arg int, d string) string
+	/* load functions */
+	LoadFile(filename string) int
+	LoadFileX(filename, mode string) int
+	LoadString(s string) int
+	/* other functions */
+	TypeNameOf(idx int) string
+	ToStringMeta(idx int) string
+	LenInt(idx int) int64
+	GetSubTable(idx int, fname string) bool
+	GetMetafield(obj int, e string) LuaType
+	CallMeta(obj int, e string) bool
+	RequireF(modname string, openf GoFunction, glb bool)
+	NewLib(l FuncReg)
+	NewLibTable(l FuncReg)
+	SetFuncs(l FuncReg, nup int)
+	/* userdata functions */
+	NewMetatable(tname string) bool
+	GetNamedMetatable(tname string) LuaType
+	SetNamedMetatable(tname string)
+	TestUdata(ud int, tname string) interface{}
+	CheckUdata(ud int, tname string) interface{}
+}
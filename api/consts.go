@@ -1,10 +1,14 @@
 package api
 
 const (
-	LUA_MINSTACK            = 20
-	LUAI_MAXSTACK           = 1000000
-	LUA_REGISTRYINDEX       = -LUAI_MAXSTACK - 1000 // fake index or virtual index
-	LUA_RIDX_GLOBALS  int64 = 2
+	LUA_MINSTACK              = 20
+	LUAI_MAXSTACK             = 1000000
+	LUAI_MAXCCALLS            = 200                   // limit of nested calls from go functions and metamethods
+	LUA_REGISTRYINDEX         = -LUAI_MAXSTACK - 1000 // fake index or virtual index
+	LUA_RIDX_MAINTHREAD int64 = 1
+	LUA_RIDX_GLOBALS    int64 = 2
+	LUA_MULTRET               = -1
+	LUA_LOADED_TABLE          = "_LOADED" // key of the table of loaded modules in the registry
 )
 
 const (
@@ -0,0 +1,13 @@
+package api
+
+// Limits for running untrusted scripts, set by LuaState.SetLimits(),
+// a zero field means no limit. A script exceeding any limit gets an error
+// that is raised without calling the message handler.
+type Limits struct {
+	Instructions int64 // number of VM instructions executed
+	CallDepth    int   // number of levels in the call stack of a thread
+	Memory       int64 // approximate bytes allocated for tables and strings, plus stacks in use
+	// Uncatchable makes the error pass through PCall()s made inside lua code,
+	// including pcall() and coroutines, so that only the host catches it.
+	Uncatchable bool
+}
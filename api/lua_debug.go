@@ -0,0 +1,41 @@
+package api
+
+/* events of debug hooks */
+const (
+	LUA_HOOKCALL = iota
+	LUA_HOOKRET
+	LUA_HOOKLINE
+	LUA_HOOKCOUNT
+	LUA_HOOKTAILCALL
+)
+
+/* masks of the events to hook */
+const (
+	LUA_MASKCALL  = 1 << LUA_HOOKCALL
+	LUA_MASKRET   = 1 << LUA_HOOKRET
+	LUA_MASKLINE  = 1 << LUA_HOOKLINE
+	LUA_MASKCOUNT = 1 << LUA_HOOKCOUNT
+)
+
+// Hook is called on the events given to SetHook(), ar tells the event and the
+// line for line events; the hooked function is at level 0 then. No other
+// hook is called while it's running.
+type Hook func(ls LuaState, ar *Debug)
+
+// Debug carries information about a function, LuaState.GetInfo() fills the
+// fields selected by its what argument, others are left untouched.
+type Debug struct {
+	Event           int
+	Name            string // 'n': name of the function, "" if unknown
+	NameWhat        string // 'n': "global", "local", "method", "field", "upvalue" or ""
+	What            string // 'S': "Lua", "C" or "main"
+	Source          string // 'S': chunk name where the function is defined
+	ShortSrc        string // 'S': printable version of Source
+	LineDefined     int    // 'S': line where the definition starts
+	LastLineDefined int    // 'S': line where the definition ends
+	CurrentLine     int    // 'l': line being executed, -1 if unknown
+	NUps            int    // 'u': number of upvalues
+	NParams         int    // 'u': number of fixed parameters
+	IsVararg        bool   // 'u'
+	IsTailCall      bool   // 't': whether it's called by a tail call
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gonearewe/lua-compiler/number"
+)
+
+// LuaError is what errors in lua code or raised through the API panic with,
+// PCall() catches it and pushes the error object, otherwise it reaches the host
+// who can recover it. Go panics of other kinds are bugs and never caught by PCall().
+type LuaError struct {
+	Value     interface{} // error object, which can be any lua value
+	Status    int         // LUA_ERRRUN, LUA_ERRMEM, LUA_ERRERR...
+	Traceback string      // stack traceback where the error is raised
+	// whether PCall()s made inside lua code let it pass, see Limits.Uncatchable
+	Uncatchable bool
+	Err         error // Go error that causes it, if any
+}
+
+// ErrCancelled is matched by the error raised when the context of
+// the running script is done, which also wraps the error of the context.
+var ErrCancelled = errors.New("script cancelled")
+
+// ExitError is what the uncatchable error raised by os.exit wraps, the host
+// decides what exiting means for it, like ending the process with the code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *LuaError) Error() string {
+	switch v := e.Value.(type) {
+	case string:
+		return v
+	case int64:
+		return fmt.Sprint(v)
+	case float64:
+		return number.FloatToString(v)
+	case nil:
+		return "nil"
+	default:
+		return "(error object is not a string)"
+	}
+}
+
+func (e *LuaError) Unwrap() error {
+	return e.Err
+}
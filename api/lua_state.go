@@ -1,11 +1,14 @@
 package api
 
+import "context"
+
 type LuaType = int
 type ArithOp = int
 type CompareOp = int
 type GoFunction func(LuaState) int
 
 type LuaState interface {
+	AuxLib
 	/* basic stack manipulation */
 	GetTop() int
 	AbsIndex(idx int) int
@@ -28,9 +31,11 @@ type LuaState interface {
 	IsInteger(idx int) bool
 	IsNumber(idx int) bool
 	IsString(idx int) bool
-	// IsTable(idx int) bool
-	// IsThread(idx int) bool
-	// IsFunction(idx int) bool
+	IsTable(idx int) bool
+	IsThread(idx int) bool
+	IsFunction(idx int) bool
+	IsUserdata(idx int) bool
+	IsLightUserdata(idx int) bool
 	ToBoolean(idx int) bool
 	ToInteger(idx int) int64
 	ToIntegerX(idx int) (int64, bool)
@@ -38,12 +43,19 @@ type LuaState interface {
 	ToNumberX(idx int) (float64, bool)
 	ToString(idx int) string
 	ToStringX(idx int) (string, bool)
+	ToPointer(idx int) interface{}
+	ToThread(idx int) LuaState
+	ToUserdata(idx int) interface{}
 	/* push functions (Go -> stack) */
 	PushNil()
 	PushBoolean(b bool)
 	PushInteger(n int64)
 	PushNumber(n float64)
 	PushString(s string)
+	PushFString(fmt string, a ...interface{})
+	PushThread() bool
+	PushUserdata(v interface{})
+	PushLightUserdata(p interface{})
 	/* Comparison and arithmetic functions */
 	Arith(op ArithOp)
 	Compare(idx1, idx2 int, op CompareOp) bool
@@ -70,6 +82,8 @@ type LuaState interface {
 	Register(name string, f GoFunction)
 	GetMetatable(idx int) bool
 	SetMetatable(idx int)
+	GetUserValue(idx int) LuaType
+	SetUserValue(idx int)
 	RawEqual(idx1, idx2 int) bool
 	RawLen(idx int) uint
 	RawGet(idx int) LuaType
@@ -79,6 +93,29 @@ type LuaState interface {
 	Next(idx int) bool
 	Error() int
 	PCall(nArgs, nResults, msgh int) int
+	StringToNumber(s string) bool
+	SetUpvalue(funcIdx, n int) (string, bool)
+	/* coroutine functions */
+	XMove(to LuaState, n int)
+	NewThread() LuaState
+	Resume(from LuaState, nArgs int) int
+	Yield(nResults int) int
+	Status() int
+	IsYieldable() bool
+	GetStack() bool // debug
+	/* debug API */
+	GetInfo(level int, what string, ar *Debug) bool
+	GetLocal(level, n int) string
+	SetLocal(level, n int) string
+	GetUpvalue(funcIdx, n int) (string, bool)
+	SetHook(f Hook, mask, count int)
+	GetHook() (f Hook, mask, count int)
+	/* sandbox */
+	SetLimits(limits Limits)
+	SetContext(ctx context.Context)
+	Context() context.Context
+	CallContext(ctx context.Context, nArgs, nResults int) error
+	Close()
 }
 
 type BasicAPI interface {
@@ -11,4 +11,7 @@ type LuaVM interface {
 	LoadVararg(n int)
 	LoadProto(idx int)
 	CloseUpvalues(a int)
+	PreCall(nArgs, nResults int) bool         // enter a lua function or call a go function
+	TailCall(nArgs int) bool                  // replace the running function with a lua function or call a go function
+	RunError(format string, a ...interface{}) // raise an error at the running instruction
 }
@@ -83,3 +83,14 @@ func Undump(data []byte) *Prototype {
 	reader.readByte() // size_upvalues
 	return reader.readProto("")
 }
+
+// Dump the prototype(usually the main function) into a binary chunk that
+// can be loaded by Undump() or the reference implementation of Lua 5.3,
+// debug information is dropped if strip is true.
+func Dump(proto *Prototype, strip bool) []byte {
+	writer := &writer{strip: strip}
+	writer.writeHeader()
+	writer.writeByte(byte(len(proto.Upvalues))) // size_upvalues
+	writer.writeProto(proto, "")
+	return writer.data
+}
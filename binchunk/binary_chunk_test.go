@@ -0,0 +1,79 @@
+package binchunk_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/gonearewe/lua-compiler/binchunk"
+	"github.com/gonearewe/lua-compiler/compiler/codegen"
+	"github.com/gonearewe/lua-compiler/compiler/parser"
+)
+
+// a chunk with every kind of constant, upvalues, nested functions and locals
+const roundTripChunk = `
+local t = {1, 2.5, "short", nil, true, false, -0x7fffffffffffffff - 1}
+t.long = "a string longer than the 40 bytes of a short string in the reference"
+local n = 0
+local function counter(step, ...)
+	local extra = select('#', ...)
+	local function inc()
+		n = n + step
+		return n, extra
+	end
+	return inc
+end
+for i = 1, 3 do
+	if i == 2 then goto continue end
+	counter(i)()
+	::continue::
+end
+return t, n
+`
+
+func genProto(t *testing.T, chunk, chunkName string) *Prototype {
+	t.Helper()
+	return codegen.GenProto(parser.Parse(chunk, chunkName), chunkName)
+}
+
+func TestDumpUndump(t *testing.T) {
+	proto := genProto(t, roundTripChunk, "@roundtrip.lua")
+	data := Dump(proto, false)
+	if !IsBinaryChunk(data) {
+		t.Fatalf("Dump() = %q..., want the signature %q", data[:4], LUA_SIGNATURE)
+	}
+
+	got := Undump(data)
+	if !reflect.DeepEqual(got, proto) {
+		t.Errorf("Undump(Dump(proto)) = %+v, want %+v", got, proto)
+	}
+	if again := Dump(got, false); string(again) != string(data) {
+		t.Errorf("Dump(Undump(data)) differs from data")
+	}
+}
+
+func TestDumpStrip(t *testing.T) {
+	proto := genProto(t, roundTripChunk, "@roundtrip.lua")
+	got := Undump(Dump(proto, true))
+
+	var check func(got, want *Prototype)
+	check = func(got, want *Prototype) {
+		if len(got.LineInfo) != 0 || len(got.LocVars) != 0 || len(got.UpvalueNames) != 0 {
+			t.Errorf("function at line %d keeps debug information after stripping", want.LineDefined)
+		}
+		if !reflect.DeepEqual(got.Code, want.Code) || !reflect.DeepEqual(got.Constants, want.Constants) ||
+			!reflect.DeepEqual(got.Upvalues, want.Upvalues) {
+			t.Errorf("function at line %d changed by stripping", want.LineDefined)
+		}
+		if len(got.Protos) != len(want.Protos) {
+			t.Fatalf("function at line %d has %d sub functions, want %d",
+				want.LineDefined, len(got.Protos), len(want.Protos))
+		}
+		for i := range got.Protos {
+			check(got.Protos[i], want.Protos[i])
+		}
+	}
+	check(got, proto)
+	if got.Source != "" {
+		t.Errorf("stripped source = %q, want \"\"", got.Source)
+	}
+}
@@ -0,0 +1,205 @@
+package binchunk
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// the longest string that is stored as a short string by the reference implementation
+const LUAI_MAXSHORTLEN = 40
+
+type writer struct {
+	data  []byte
+	strip bool // whether debug information is dropped
+}
+
+/********************************
+following methods write raw data to the data stream wrapped in writer
+they are the exact reverse of the ones in reader
+********************************/
+
+func (w *writer) writeByte(b byte) {
+	w.data = append(w.data, b)
+}
+
+func (w *writer) writeBytes(bytes []byte) {
+	w.data = append(w.data, bytes...)
+}
+
+func (w *writer) writeUint32(i uint32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], i)
+	w.data = append(w.data, buf[:]...)
+}
+
+func (w *writer) writeUint64(i uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], i)
+	w.data = append(w.data, buf[:]...)
+}
+
+func (w *writer) writeLuaInteger(i int64) {
+	w.writeUint64(uint64(i))
+}
+
+func (w *writer) writeLuaNumber(f float64) {
+	w.writeUint64(math.Float64bits(f))
+}
+
+// write a string whose size(including the trailing '\0' of C) is stored
+// in one byte, or in 0xFF followed by a size_t if the string is long
+func (w *writer) writeString(s string) {
+	size := uint64(len(s)) + 1
+	if size < 0xFF {
+		w.writeByte(byte(size))
+	} else {
+		w.writeByte(0xFF)
+		w.writeUint64(size)
+	}
+	w.writeBytes([]byte(s))
+}
+
+// write a NULL string, which is different from the empty string ""
+func (w *writer) writeNilString() {
+	w.writeByte(0)
+}
+
+/********************************
+following methods serve for writeProto(), dumping every part of a prototype
+********************************/
+
+func (w *writer) writeCode(code []uint32) {
+	w.writeUint32(uint32(len(code)))
+	for _, inst := range code {
+		w.writeUint32(inst)
+	}
+}
+
+func (w *writer) writeConstant(k interface{}) {
+	switch x := k.(type) {
+	case nil:
+		w.writeByte(TAG_NIL)
+	case bool:
+		w.writeByte(TAG_BOOLEAN)
+		if x {
+			w.writeByte(1)
+		} else {
+			w.writeByte(0)
+		}
+	case int64:
+		w.writeByte(TAG_INTEGER)
+		w.writeLuaInteger(x)
+	case float64:
+		w.writeByte(TAG_NUMBER)
+		w.writeLuaNumber(x)
+	case string:
+		if len(x) <= LUAI_MAXSHORTLEN {
+			w.writeByte(TAG_SHORT_STR)
+		} else {
+			w.writeByte(TAG_LONG_STR)
+		}
+		w.writeString(x)
+	default:
+		panic("unknown constant type !")
+	}
+}
+
+func (w *writer) writeConstants(constants []interface{}) {
+	w.writeUint32(uint32(len(constants)))
+	for _, k := range constants {
+		w.writeConstant(k)
+	}
+}
+
+func (w *writer) writeUpvalues(upvalues []Upvalue) {
+	w.writeUint32(uint32(len(upvalues)))
+	for _, upval := range upvalues {
+		w.writeByte(upval.Instack)
+		w.writeByte(upval.Idx)
+	}
+}
+
+// param parentSource is the source of the enclosing function,
+// sub-functions sharing it with their parent don't save it again
+func (w *writer) writeProtos(protos []*Prototype, parentSource string) {
+	w.writeUint32(uint32(len(protos)))
+	for _, proto := range protos {
+		w.writeProto(proto, parentSource)
+	}
+}
+
+// debug information is written as empty lists when stripped
+func (w *writer) writeLineInfo(lineInfo []uint32) {
+	if w.strip {
+		lineInfo = nil
+	}
+
+	w.writeUint32(uint32(len(lineInfo)))
+	for _, line := range lineInfo {
+		w.writeUint32(line)
+	}
+}
+
+func (w *writer) writeLocVars(locVars []LocVar) {
+	if w.strip {
+		locVars = nil
+	}
+
+	w.writeUint32(uint32(len(locVars)))
+	for _, locVar := range locVars {
+		w.writeString(locVar.VarName)
+		w.writeUint32(locVar.StartPC)
+		w.writeUint32(locVar.EndPC)
+	}
+}
+
+func (w *writer) writeUpvalueNames(names []string) {
+	if w.strip {
+		names = nil
+	}
+
+	w.writeUint32(uint32(len(names)))
+	for _, name := range names {
+		w.writeString(name)
+	}
+}
+
+/********************************
+following methods are ready for use
+they offer api for writer
+********************************/
+
+// write the header that checkHeader() of reader expects
+func (w *writer) writeHeader() {
+	w.writeBytes([]byte(LUA_SIGNATURE))
+	w.writeByte(LUAC_VERSION)
+	w.writeByte(LUAC_FORMAT)
+	w.writeBytes([]byte(LUAC_DATA))
+	w.writeByte(CINT_SIZE)
+	w.writeByte(CSIZET_SIZE)
+	w.writeByte(INSTRUCTION_SIZE)
+	w.writeByte(LUA_INTEGER_SIZE)
+	w.writeByte(LUA_NUMBER_SIZE)
+	w.writeLuaInteger(LUAC_INT)
+	w.writeLuaNumber(LUAC_NUM)
+}
+
+func (w *writer) writeProto(proto *Prototype, parentSource string) {
+	if w.strip || proto.Source == parentSource {
+		w.writeNilString()
+	} else {
+		w.writeString(proto.Source)
+	}
+	w.writeUint32(proto.LineDefined)
+	w.writeUint32(proto.LastLineDefined)
+	w.writeByte(proto.NumParams)
+	w.writeByte(proto.IsVararg)
+	w.writeByte(proto.MaxStackSize)
+	w.writeCode(proto.Code)
+	w.writeConstants(proto.Constants)
+	w.writeUpvalues(proto.Upvalues)
+	w.writeProtos(proto.Protos, proto.Source)
+	w.writeLineInfo(proto.LineInfo)
+	w.writeLocVars(proto.LocVars)
+	w.writeUpvalueNames(proto.UpvalueNames)
+}
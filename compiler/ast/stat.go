@@ -5,10 +5,20 @@ type Stat interface{}
 
 type EmptyStat /**/ struct{}               // ;
 type BreakStat /**/ struct{ Line int }     // break
-type LabelStat /**/ struct{ Name string }  // `::`Name`::`
-type GotoStat /* */ struct{ Name string }  // goto Name
 type DoStat /*   */ struct{ Block *Block } // do block end
 
+// `::`Name`::`
+type LabelStat struct {
+	Line int
+	Name string
+}
+
+// goto Name
+type GotoStat struct {
+	Line int
+	Name string
+}
+
 type FuncCallStat = FuncCallExp // function call, both statement and expression
 
 // EBNF: while exp do block end
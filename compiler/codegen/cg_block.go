@@ -2,31 +2,51 @@ package codegen
 
 import (
 	. "github.com/gonearewe/lua-compiler/compiler/ast"
-	. "github.com/gonearewe/lua-compiler/compiler/lexer"
 )
 
 // Code generating from block.
-func cgBlock(fi *funcInfo,node *Block){
-	for _,stat:=range node.Stats{
-		cgStat(fi,stat)
+func cgBlock(fi *funcInfo, node *Block) {
+	for i, stat := range node.Stats {
+		if label, ok := stat.(*LabelStat); ok {
+			fi.addLabel(label.Name, label.Line, isBlockEnd(node, i+1))
+		} else {
+			cgStat(fi, stat)
+		}
 	}
 
-	if node.RetExps!=nil{ // has return statement
-		cgRetStat(fi,node.RetExps)
+	if node.RetExps != nil { // has return statement
+		cgRetStat(fi, node.RetExps, node.LastLine)
 	}
 }
 
-func cgRetStat(fi *funcInfo,exps []Exp){
-	nExps:=len(exps)
-	if nExps==0{
-		fi.emitReturn(0, 0)
+// Whether only void statements are left from the ith statement to the end of block.
+func isBlockEnd(node *Block, i int) bool {
+	if node.RetExps != nil {
+		return false
+	}
+
+	for _, stat := range node.Stats[i:] {
+		switch stat.(type) {
+		case *EmptyStat, *LabelStat:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func cgRetStat(fi *funcInfo, exps []Exp, lastLine int) {
+	nExps := len(exps)
+	if nExps == 0 {
+		fi.emitReturn(lastLine, 0, 0)
 		return
 	}
 
 	if nExps == 1 {
 		if nameExp, ok := exps[0].(*NameExp); ok {
 			if r := fi.slotOfLocVar(nameExp.Name); r >= 0 {
-				fi.emitReturn(r, 1)
+				fi.emitReturn(lastLine, r, 1)
 				return
 			}
 		}
@@ -34,35 +54,35 @@ func cgRetStat(fi *funcInfo,exps []Exp){
 			r := fi.allocReg()
 			cgTailCallExp(fi, fcExp, r)
 			fi.freeReg()
-			fi.emitReturn(r, -1)
+			fi.emitReturn(lastLine, r, -1)
 			return
 		}
 	}
 
-	multRet:=isVarargOrFuncCall(exps[nExps-1])
-	for i,exp:=range exps{
-		r:=fi.allocRegs()
-		if i==nExps-1&&multRet{
-			cgExp(fi,exp,r,-1)
-		}else{
-			cgExp(fi,exp,r,1)
+	multRet := isVarargOrFuncCall(exps[nExps-1])
+	for i, exp := range exps {
+		r := fi.allocReg()
+		if i == nExps-1 && multRet {
+			cgExp(fi, exp, r, -1)
+		} else {
+			cgExp(fi, exp, r, 1)
 		}
 	}
 	fi.freeRegs(nExps)
 
-	a:=fi.usedRegs
-	if multRet{
-		fi.emitReturn(a, -1)
-	}else{
-		fi.emitReturn(a, nExps)
+	a := fi.usedRegs
+	if multRet {
+		fi.emitReturn(lastLine, a, -1)
+	} else {
+		fi.emitReturn(lastLine, a, nExps)
 	}
 }
 
-func isVarargOrFuncCall(exp Exp)bool{
-	switch exp.(type){
-	case *VarargExp,*FuncCallExp:
+func isVarargOrFuncCall(exp Exp) bool {
+	switch exp.(type) {
+	case *VarargExp, *FuncCallExp:
 		return true
 	}
 
 	return false
-}
\ No newline at end of file
+}
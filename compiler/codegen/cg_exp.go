@@ -54,27 +54,27 @@ func cgExp(fi *funcInfo, node Exp, a, n int) {
 	}
 }
 
-func cgVaragExp(fi *funcInfo, node *VarargExp, a, n int) {
+func cgVarargExp(fi *funcInfo, node *VarargExp, a, n int) {
 	if !fi.isVararg {
-		panic("concat uses '...' outside a vararg function")
+		fi.error(node.Line, "cannot use '...' outside a vararg function")
 	}
 
-	fi.emitVararg(a, n)
+	fi.emitVararg(node.Line, a, n)
 }
 
 func cgFuncDefExp(fi *funcInfo, node *FuncDefExp, a int) {
-	subFl := newFuncInfo(fi, node)
+	subFi := newFuncInfo(fi, node)
 	fi.subFuncs = append(fi.subFuncs, subFi)
 
 	for _, param := range node.ParList {
-		subFl.addLocVar(param)
+		subFi.addLocVar(param, 0)
 	}
-	cgBlock(subFl, node.Block)
-	subFl.exitScope()
-	subFl.emitReturn(0, 0)
+	cgBlock(subFi, node.Block)
+	subFi.exitScope(subFi.pc() + 2) // parameters are active till the final return
+	subFi.emitReturn(node.LastLine, 0, 0)
 
 	bx := len(fi.subFuncs) - 1
-	fi.emitClosure(a, bx)
+	fi.emitClosure(node.LastLine, a, bx)
 }
 
 func cgTableConstructorExp(fi *funcInfo, node *TableConstructorExp, a int) {
@@ -87,11 +87,12 @@ func cgTableConstructorExp(fi *funcInfo, node *TableConstructorExp, a int) {
 
 	nExps := len(node.KeyExps)
 	multRet := nExps > 0 && isVarargOrFuncCall(node.ValExps[nExps-1])
-	fi.emitNewTable(a, nArr, nExps-nArr)
+	fi.emitNewTable(node.Line, a, nArr, nExps-nArr)
 
 	arrIdx := 0
 	for i, keyExp := range node.KeyExps {
 		valExp := node.ValExps[i]
+		line := lastLineOf(valExp)
 		if keyExp == nil {
 			arrIdx++
 			tmp := fi.allocReg()
@@ -111,9 +112,9 @@ func cgTableConstructorExp(fi *funcInfo, node *TableConstructorExp, a int) {
 				fi.freeRegs(n)
 
 				if i == nExps-1 && multRet {
-					fi.emitSetList(a, 0, c)
+					fi.emitSetList(line, a, 0, c)
 				} else {
-					fi.emitSetList(a, n, c)
+					fi.emitSetList(line, a, n, c)
 				}
 			}
 
@@ -123,16 +124,16 @@ func cgTableConstructorExp(fi *funcInfo, node *TableConstructorExp, a int) {
 		b := fi.allocReg()
 		cgExp(fi, keyExp, b, 1)
 		c := fi.allocReg()
-		cgExp(fi, keyExp, c, 1)
+		cgExp(fi, valExp, c, 1)
 		fi.freeRegs(2)
-		fi.emitSetTable(a, b, c)
+		fi.emitSetTable(line, a, b, c)
 	}
 }
 
 func cgUnopExp(fi *funcInfo, node *UnopExp, a int) {
 	b := fi.allocReg()
 	cgExp(fi, node.Exp, b, 1)
-	fi.emitUnaryOp(node.Op, a, b)
+	fi.emitUnaryOp(node.Line, node.Op, a, b)
 	fi.freeReg()
 }
 
@@ -145,7 +146,7 @@ func cgConcatExp(fi *funcInfo, node *ConcatExp, a int) {
 	c := fi.usedRegs - 1
 	b := c - len(node.Exps) + 1
 	fi.freeRegs(c - b + 1)
-	fi.emitABC(OP_CONCAT, a, b, c)
+	fi.emitABC(node.Line, OP_CONCAT, a, b, c)
 }
 
 func cgBinopExp(fi *funcInfo, node *BinopExp, a int) {
@@ -155,16 +156,16 @@ func cgBinopExp(fi *funcInfo, node *BinopExp, a int) {
 		cgExp(fi, node.Exp1, b, 1)
 		fi.freeReg()
 		if node.Op == TOKEN_OP_AND {
-			fi.emitTestSet(a, b, 0)
+			fi.emitTestSet(node.Line, a, b, 0)
 		} else {
-			fi.emitTestSet(a, b, 1)
+			fi.emitTestSet(node.Line, a, b, 1)
 		}
 
-		pcOfJmp := fi.emitJmp(0, 0)
+		pcOfJmp := fi.emitJmp(node.Line, 0, 0)
 		b = fi.allocReg()
 		cgExp(fi, node.Exp2, b, 1)
 		fi.freeReg()
-		fi.emitMove(a, b)
+		fi.emitMove(node.Line, a, b)
 		fi.fixSbx(pcOfJmp, fi.pc()-pcOfJmp)
 
 	default:
@@ -172,67 +173,145 @@ func cgBinopExp(fi *funcInfo, node *BinopExp, a int) {
 		cgExp(fi, node.Exp1, b, 1)
 		c := fi.allocReg()
 		cgExp(fi, node.Exp2, c, 1)
-		fi.emitBinaryOp(node.Op, a, b, c)
+		fi.emitBinaryOp(node.Line, node.Op, a, b, c)
 		fi.freeRegs(2)
 	}
 }
 
-func cgNameExp(fi *funcInfo,node *NameExp,a int){
-	if r:=fi.slotOfLocVar(node.Name);r>=0{
-		fi.emitMove(a, r)
-	}else if idx:=fi.indexOfUpval(node.Name);idx>=0{
-		fi.emitGetUpval(a, idx)
-	}else{
-		taExp:=&TableAccessExp{
-			PrefixExp:&NameExp{0,"_ENV"},
-			KeyExp:&StringExp{0,node.Name},
+func cgNameExp(fi *funcInfo, node *NameExp, a int) {
+	if r := fi.slotOfLocVar(node.Name); r >= 0 {
+		fi.emitMove(node.Line, a, r)
+	} else if idx := fi.indexOfUpval(node.Name); idx >= 0 {
+		fi.emitGetUpval(node.Line, a, idx)
+	} else {
+		taExp := &TableAccessExp{
+			LastLine:  node.Line,
+			PrefixExp: &NameExp{Line: node.Line, Name: "_ENV"},
+			KeyExp:    &StringExp{Line: node.Line, Str: node.Name},
 		}
 		cgTableAccessExp(fi, taExp, a)
 	}
 }
 
-func cgTableAccessExp(fi *funcInfo,node *TableAccessExp,a int){
-	b:=fi.allocReg()
+func cgTableAccessExp(fi *funcInfo, node *TableAccessExp, a int) {
+	b := fi.allocReg()
 	cgExp(fi, node.PrefixExp, b, 1)
-	c:=fi.allocReg()
+	c := fi.allocReg()
 	cgExp(fi, node.KeyExp, c, 1)
-	fi.emitGetTable(a, b, c)
+	fi.emitGetTable(node.LastLine, a, b, c)
 	fi.freeRegs(2)
 }
 
-func cgFuncCallExp(fi *funcInfo,node *FuncCallExp,a,n int){
-	nArgs:=prepFuncCall(fi,node,a)
-	fi.emitCall(a, nArgs, n)
+func cgFuncCallExp(fi *funcInfo, node *FuncCallExp, a, n int) {
+	nArgs := prepFuncCall(fi, node, a)
+	fi.emitCall(node.Line, a, nArgs, n)
+}
+
+func cgTailCallExp(fi *funcInfo, node *FuncCallExp, a int) {
+	nArgs := prepFuncCall(fi, node, a)
+	fi.emitTailCall(node.Line, a, nArgs)
 }
 
-func prepFuncCall(fi *funcInfo,node *FuncCallExp,a int)int{
-	nArgs:=len(node.Args)
-	lastArgIsVarargOrFuncCall:=false
+func prepFuncCall(fi *funcInfo, node *FuncCallExp, a int) int {
+	nArgs := len(node.Args)
+	lastArgIsVarargOrFuncCall := false
 	cgExp(fi, node.PrefixExp, a, 1)
-	if node.NameExp!=nil{
-		c:=0x100+fi.indexOfConstant(node.NameExp.Str)
-		fi.emitSelf(a, a, c)
+	if node.NameExp != nil {
+		fi.allocReg() // reserved for `self`
+		if idx := fi.indexOfConstant(node.NameExp.Str); idx <= 0xff {
+			fi.emitSelf(node.Line, a, a, 0x100+idx)
+		} else { // too many constants to be encoded in RK(C)
+			c := fi.allocReg()
+			fi.emitLoadK(node.Line, c, node.NameExp.Str)
+			fi.emitSelf(node.Line, a, a, c)
+			fi.freeReg()
+		}
 	}
 
-	for i,arg:=range node.Args{
-		tmp:=fi.allocReg()
-		if i==nArgs-1&&isVarargOrFuncCall(arg){
-			lastArgIsVarargOrFuncCall=true
+	for i, arg := range node.Args {
+		tmp := fi.allocReg()
+		if i == nArgs-1 && isVarargOrFuncCall(arg) {
+			lastArgIsVarargOrFuncCall = true
 			cgExp(fi, arg, tmp, -1)
-		}else{
+		} else {
 			cgExp(fi, arg, tmp, 1)
 		}
 	}
 
 	fi.freeRegs(nArgs)
 
-	if node.NameExp!=nil{
+	if node.NameExp != nil {
+		fi.freeReg()
 		nArgs++
 	}
 
-	if lastArgIsVarargOrFuncCall{
-		nArgs=-1
+	if lastArgIsVarargOrFuncCall {
+		nArgs = -1
 	}
 
 	return nArgs
-}
\ No newline at end of file
+}
+
+// Return the line where the expression starts.
+func lineOf(exp Exp) int {
+	switch x := exp.(type) {
+	case *NilExp:
+		return x.Line
+	case *TrueExp:
+		return x.Line
+	case *FalseExp:
+		return x.Line
+	case *IntegerExp:
+		return x.Line
+	case *FloatExp:
+		return x.Line
+	case *StringExp:
+		return x.Line
+	case *VarargExp:
+		return x.Line
+	case *NameExp:
+		return x.Line
+	case *FuncDefExp:
+		return x.Line
+	case *TableConstructorExp:
+		return x.Line
+	case *UnopExp:
+		return x.Line
+	case *ParensExp:
+		return lineOf(x.Exp)
+	case *BinopExp:
+		return lineOf(x.Exp1)
+	case *ConcatExp:
+		return lineOf(x.Exps[0])
+	case *TableAccessExp:
+		return lineOf(x.PrefixExp)
+	case *FuncCallExp:
+		return lineOf(x.PrefixExp)
+	}
+
+	panic("unreachable !")
+}
+
+// Return the line where the expression ends.
+func lastLineOf(exp Exp) int {
+	switch x := exp.(type) {
+	case *FuncDefExp:
+		return x.LastLine
+	case *TableConstructorExp:
+		return x.LastLine
+	case *TableAccessExp:
+		return x.LastLine
+	case *FuncCallExp:
+		return x.LastLine
+	case *ParensExp:
+		return lastLineOf(x.Exp)
+	case *UnopExp:
+		return lastLineOf(x.Exp)
+	case *BinopExp:
+		return lastLineOf(x.Exp2)
+	case *ConcatExp:
+		return lastLineOf(x.Exps[len(x.Exps)-1])
+	}
+
+	return lineOf(exp)
+}
@@ -0,0 +1,37 @@
+package codegen_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTableConstructor(t *testing.T) {
+	// a list of n integers from 1 to n, followed by the rest of the constructor
+	list := func(n int, rest string) string {
+		items := make([]string, n)
+		for i := range items {
+			items[i] = fmt.Sprint(i + 1)
+		}
+		return "{" + strings.Join(items, ",") + rest + "}"
+	}
+
+	tests := []struct {
+		chunk string
+		want  int64
+	}{
+		{"return #" + list(50, ""), 50},
+		{"return #" + list(51, ""), 51},
+		// more than 511 flushes of 50 items need an EXTRAARG for SETLIST
+		{"return #" + list(70000, ""), 70000},
+		{"local t = " + list(70000, "") + " return t[25551] + t[70000]", 95551},
+		{"return #" + list(30000, ", (function() return 1, 2, 3 end)()"), 30003},
+		{"local t = " + list(30000, ", x = 1, [-1] = 2") + " return #t + t.x + t[-1]", 30003},
+	}
+
+	for _, test := range tests {
+		if got := runInteger(t, test.chunk); got != test.want {
+			t.Errorf("%.60s... = %d, want %d", test.chunk, got, test.want)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package codegen
 
 import (
 	. "github.com/gonearewe/lua-compiler/compiler/ast"
-	. "github.com/gonearewe/lua-compiler/compiler/lexer"
 )
 
 func cgStat(fi *funcInfo, node Stat) {
@@ -29,38 +28,43 @@ func cgStat(fi *funcInfo, node Stat) {
 		cgLocalVarDeclStat(fi, stat)
 	case *LocalFuncDefStat:
 		cgLocalFuncDefStat(fi, stat)
-	case *LabelStat, *GotoStat:
-		panic("label and goto statements are not supported!")
+	case *GotoStat:
+		cgGotoStat(fi, stat)
 	}
 }
 
+func cgGotoStat(fi *funcInfo, node *GotoStat) {
+	pc := fi.emitJmp(node.Line, 0, 0)
+	fi.addGoto(node.Name, node.Line, pc)
+}
+
 func cgLocalFuncDefStat(fi *funcInfo, node *LocalFuncDefStat) {
-	r := fi.addLocVar(node.Name)
+	r := fi.addLocVar(node.Name, fi.pc()+2) // active after the closure is created
 	cgFuncDefExp(fi, node.Exp, r)
 }
 
 func cgFuncCallStat(fi *funcInfo, node *FuncCallStat) {
-	r := fi.allocRegs()
+	r := fi.allocReg()
 	cgFuncCallExp(fi, node, r, 0)
 	fi.freeReg()
 }
 
 func cgBreakStat(fi *funcInfo, node *BreakStat) {
-	pc := fi.emitJmp(0, 0)
-	fi.addBreakJmp(pc)
+	pc := fi.emitJmp(node.Line, 0, 0)
+	fi.addBreakJmp(pc, node.Line)
 }
 
 func cgDoStat(fi *funcInfo, node *DoStat) {
 	fi.enterScope(false)
 	cgBlock(fi, node.Block)
-	fi.closeOpenUpvals()
-	fi.exitScope()
+	fi.closeOpenUpvals(node.Block.LastLine)
+	fi.exitScope(fi.pc() + 1)
 }
 
-func (f *funcInfo) closeOpenUpvals() {
+func (f *funcInfo) closeOpenUpvals(line int) {
 	a := f.getJmpArgA()
 	if a > 0 {
-		f.emitJmp(a, 0)
+		f.emitJmp(line, a, 0)
 	}
 }
 
@@ -90,21 +94,23 @@ func (f *funcInfo) getJmpArgA() int {
 
 func cgWhileStat(fi *funcInfo, node *WhileStat) {
 	pcBeforeExp := fi.pc()
-	r := fi.allocRegs()
+	r := fi.allocReg()
 	cgExp(fi, node.Exp, r, 1)
 	fi.freeReg()
-	fi.emitTest(r, 0)
-	pcJmpToEnd := fi.emitJmp(0, 0)
+	line := lastLineOf(node.Exp)
+	fi.emitTest(line, r, 0)
+	pcJmpToEnd := fi.emitJmp(line, 0, 0)
 	fi.enterScope(true)
 	cgBlock(fi, node.Block)
-	fi.closeOpenUpvals()
-	fi.emitJmp(0, pcBeforeExp-fi.pc()-1)
-	fi.exitScope()
+	fi.closeOpenUpvals(node.Block.LastLine)
+	fi.emitJmp(node.Block.LastLine, 0, pcBeforeExp-fi.pc()-1)
+	fi.exitScope(fi.pc())
 	fi.fixSbx(pcJmpToEnd, fi.pc()-pcJmpToEnd)
 }
 
 func cgRepeatStat(fi *funcInfo, node *RepeatStat) {
 	fi.enterScope(true)
+	fi.blocks[len(fi.blocks)-1].isRepeat = true
 
 	pcBeforeBlock := fi.pc()
 	cgBlock(fi, node.Block)
@@ -112,11 +118,12 @@ func cgRepeatStat(fi *funcInfo, node *RepeatStat) {
 	cgExp(fi, node.Exp, r, 1) // it's also included in the scope, thus can be access from the block
 	fi.freeReg()
 
-	fi.emitTest(r, 0)
-	fi.emitJmp(fi.getJmpArgA(), pcBeforeBlock-fi.pc()-1)
-	fi.closeOpenUpvals()
+	line := lastLineOf(node.Exp)
+	fi.emitTest(line, r, 0)
+	fi.emitJmp(line, fi.getJmpArgA(), pcBeforeBlock-fi.pc()-1)
+	fi.closeOpenUpvals(line)
 
-	fi.exitScope()
+	fi.exitScope(fi.pc() + 1)
 
 }
 
@@ -125,73 +132,78 @@ func cgIfStat(fi *funcInfo, node *IfStat) {
 	pcJmpToNextExp := -1
 
 	for i, exp := range node.Exps {
-		if pcJmpToEnds >= 0 {
+		if pcJmpToNextExp >= 0 {
 			fi.fixSbx(pcJmpToNextExp, fi.pc()-pcJmpToNextExp)
-			r := fi.allocReg()
-			cgExp(fi, exp, r, 1)
-			fi.freeReg()
-			fi.emitTest(r, 0)
-			pcJmpToNextExp = fi.emitJmp(0, 0)
-
-			fi.enterScope(false)
-			cgBlock(fi, node.Blocks[i])
-			fi.closeOpenUpvals()
-			fi.exitScope()
-
-			if i < len(node.Exps)-1 {
-				pcJmpToEnds[i] = fi.emitJmp(0, 0)
-			} else {
-				pcJmpToEnds[i] = pcJmpToNextExp
-			}
 		}
 
-		for _, pc := range pcJmpToEnds {
-			fi.fixSbx(pc, fi.pc()-pc)
+		r := fi.allocReg()
+		cgExp(fi, exp, r, 1)
+		fi.freeReg()
+		line := lastLineOf(exp)
+		fi.emitTest(line, r, 0)
+		pcJmpToNextExp = fi.emitJmp(line, 0, 0)
+
+		block := node.Blocks[i]
+		fi.enterScope(false)
+		cgBlock(fi, block)
+		fi.closeOpenUpvals(block.LastLine)
+		fi.exitScope(fi.pc() + 1)
+
+		if i < len(node.Exps)-1 {
+			pcJmpToEnds[i] = fi.emitJmp(block.LastLine, 0, 0)
+		} else {
+			pcJmpToEnds[i] = pcJmpToNextExp
 		}
 	}
+
+	for _, pc := range pcJmpToEnds {
+		fi.fixSbx(pc, fi.pc()-pc)
+	}
 }
 
 func cgForNumStat(fi *funcInfo, node *ForNumStat) {
 	fi.enterScope(true)
 	cgLocalVarDeclStat(fi, &LocalVarDeclStat{
+		LastLine: node.LineOfFor,
 		NameList: []string{"(for index)", "(for limit)", "(for step)"},
 		ExpList:  []Exp{node.InitExp, node.LimitExp, node.StepExp},
 	})
-	fi.addLocVar(node.VarName)
+	fi.addLocVar(node.VarName, fi.pc()+2) // active after forprep
 
 	a := fi.usedRegs - 4
-	pcForPre := fi.emitForPrep(a, 0)
+	pcForPre := fi.emitForPrep(node.LineOfDo, a, 0)
 	cgBlock(fi, node.Block)
-	fi.closeOpenUpvals()
-	pcForLoop := fi.emitForLoop(a, 0)
+	fi.closeOpenUpvals(node.Block.LastLine)
+	pcForLoop := fi.emitForLoop(node.LineOfFor, a, 0)
 
-	fi.fixSbx(pcForLoop, pcForLoop-pcForPre-1)
+	fi.fixSbx(pcForPre, pcForLoop-pcForPre-1)
 	fi.fixSbx(pcForLoop, pcForPre-pcForLoop)
 
-	fi.exitScope()
+	fi.exitScope(fi.pc() + 1)
 }
 
 func cgForInStat(fi *funcInfo, node *ForInStat) {
 	fi.enterScope(true)
 	cgLocalVarDeclStat(fi, &LocalVarDeclStat{
+		LastLine: node.LineOfDo,
 		NameList: []string{"(for generator)", "(for state)", "(for control)"},
 		ExpList:  node.ExpList,
 	})
 
 	for _, name := range node.NameList {
-		fi.addLocVar(name)
+		fi.addLocVar(name, fi.pc()+2) // active after the jmp to tforcall
 	}
 
-	pcJmpToTFC := fi.emitJmp(0, 0)
+	pcJmpToTFC := fi.emitJmp(node.LineOfDo, 0, 0)
 	cgBlock(fi, node.Block)
-	fi.closeOpenUpvals()
+	fi.closeOpenUpvals(node.Block.LastLine)
 	fi.fixSbx(pcJmpToTFC, fi.pc()-pcJmpToTFC)
 
 	rGenerator := fi.slotOfLocVar("(for generator)")
-	fi.emitTForCall(rGenerator, len(node.NameList))
-	fi.emitTForLoop(rGenerator+2, pcJmpToTFC-fi.pc()-1)
+	fi.emitTForCall(node.LineOfDo, rGenerator, len(node.NameList))
+	fi.emitTForLoop(node.LineOfDo, rGenerator+2, pcJmpToTFC-fi.pc()-1)
 
-	fi.exitScope()
+	fi.exitScope(fi.pc() + 1)
 
 }
 
@@ -232,13 +244,14 @@ func cgLocalVarDeclStat(fi *funcInfo, node *LocalVarDeclStat) {
 		if !multRet {
 			n := nNames - nExps
 			a := fi.allocRegs(n)
-			fi.emitLoadNil(a, n)
+			fi.emitLoadNil(node.LastLine, a, n)
 		}
 	}
 
 	fi.usedRegs = oldRegs
+	startPC := fi.pc() + 1
 	for _, name := range node.NameList {
-		fi.addLocVar(name)
+		fi.addLocVar(name, startPC)
 	}
 }
 
@@ -257,6 +270,13 @@ func cgAssignStat(fi *funcInfo, node *AssignStat) {
 			cgExp(fi, taExp.PrefixExp, tRegs[i], 1)
 			kRegs[i] = fi.allocReg()
 			cgExp(fi, taExp.KeyExp, kRegs[i], 1)
+		} else if name := exp.(*NameExp).Name; fi.slotOfLocVar(name) < 0 && fi.indexOfUpval(name) < 0 {
+			// global variable, whose name can't be encoded in RK(B) if there are too many constants
+			kRegs[i] = -1
+			if fi.indexOfConstant(name) > 0xff {
+				kRegs[i] = fi.allocReg()
+				fi.emitLoadK(node.LastLine, kRegs[i], name)
+			}
 		}
 	}
 
@@ -276,12 +296,12 @@ func cgAssignStat(fi *funcInfo, node *AssignStat) {
 	} else {
 		multReg := false
 		for i, exp := range exps {
-			a := allocReg()
+			a := fi.allocReg()
 			if i == nExps-1 && isVarargOrFuncCall(exp) {
 				multReg = true
 				n := nVars - nExps + 1
 				cgExp(fi, exp, a, n)
-				fi.allocReg(n - 1)
+				fi.allocRegs(n - 1)
 			} else {
 				cgExp(fi, exp, a, 1)
 			}
@@ -290,7 +310,7 @@ func cgAssignStat(fi *funcInfo, node *AssignStat) {
 		if !multReg {
 			n := nVars - nExps
 			a := fi.allocRegs(n)
-			fi.emitLoadNil(a, n)
+			fi.emitLoadNil(node.LastLine, a, n)
 		}
 	}
 
@@ -298,18 +318,32 @@ func cgAssignStat(fi *funcInfo, node *AssignStat) {
 		if nameExp, ok := exp.(*NameExp); ok {
 			varName := nameExp.Name
 			if a := fi.slotOfLocVar(varName); a >= 0 {
-				fi.emitMove(a, vRegs[i])
+				fi.emitMove(node.LastLine, a, vRegs[i])
 			} else if b := fi.indexOfUpval(varName); b >= 0 {
-				fi.emitSetUpval(vRegs[i], b)
+				fi.emitSetUpval(node.LastLine, vRegs[i], b)
 			} else { // global variable
 				a := fi.indexOfUpval("_ENV")
-				b := 0x100 + fi.indexOfConstant(varName)
-				fi.emitSetTabUp(a, b, vRegs[i])
+				if kRegs[i] < 0 {
+					fi.emitSetTabUp(node.LastLine, a, 0x100+fi.indexOfConstant(varName), vRegs[i])
+				} else {
+					fi.emitSetTabUp(node.LastLine, a, kRegs[i], vRegs[i])
+				}
 			}
 		} else {
-			fi.emitSetTable(tRegs[i], kRegs[i], vRegs[i])
+			fi.emitSetTable(node.LastLine, tRegs[i], kRegs[i], vRegs[i])
 		}
 	}
 
 	fi.usedRegs = oldRegs
 }
+
+// Remove trailing nil expressions, which are equivalent to absent ones.
+func removeTailNils(exps []Exp) []Exp {
+	for n := len(exps) - 1; n >= 0; n-- {
+		if _, ok := exps[n].(*NilExp); !ok {
+			return exps[0 : n+1]
+		}
+	}
+
+	return nil
+}
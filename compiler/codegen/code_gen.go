@@ -5,14 +5,21 @@ import (
 	. "github.com/gonearewe/lua-compiler/compiler/ast"
 )
 
-func GenProto(chunk *Block) *Prototype {
+// Generate the prototype of the main function of the chunk,
+// chunkName is the source of it and all its nested functions.
+func GenProto(chunk *Block, chunkName string) *Prototype {
 	fd := &FuncDefExp{
+		LastLine: chunk.LastLine,
 		IsVararg: true,
 		Block:    chunk,
 	}
 
 	fi := newFuncInfo(nil, fd)
-	fi.addLocVar("_ENV")
+	fi.chunkName = chunkName
+	fi.addLocVar("_ENV", 0)
 	cgFuncDefExp(fi, fd, 0)
-	return toProto(fi.subFuncs[0])
+
+	proto := toProto(fi.subFuncs[0], chunkName)
+	proto.LastLineDefined = 0 // main function is defined from line 0 to 0, like the reference
+	return proto
 }
@@ -4,17 +4,20 @@ import (
 	. "github.com/gonearewe/lua-compiler/binchunk"
 )
 
-func toProto(fi *funcInfo) *Prototype {
+func toProto(fi *funcInfo, source string) *Prototype {
 	proto := &Prototype{
-		NumParams:    byte(fi.numParams),
-		MaxStackSize: byte(fi.maxRegs),
-		Code:         fi.insts,
-		Constants:    getConstants(fi),
-		Upvalues:     getUpvalues(fi),
-		Protos:       toProtos(fi.subFuncs),
-		LineInfo:     []uint32{},
-		LocVars:      []LocVar{},
-		UpvalueNames: []string{},
+		Source:          source,
+		LineDefined:     uint32(fi.line),
+		LastLineDefined: uint32(fi.lastLine),
+		NumParams:       byte(fi.numParams),
+		MaxStackSize:    byte(fi.maxRegs),
+		Code:            fi.insts,
+		Constants:       getConstants(fi),
+		Upvalues:        getUpvalues(fi),
+		Protos:          toProtos(fi.subFuncs, source),
+		LineInfo:        fi.lineNums,
+		LocVars:         getLocVars(fi),
+		UpvalueNames:    getUpvalueNames(fi),
 	}
 
 	if fi.isVararg {
@@ -24,10 +27,10 @@ func toProto(fi *funcInfo) *Prototype {
 	return proto
 }
 
-func toProtos(fis []*funcInfo) []*Prototype {
+func toProtos(fis []*funcInfo, source string) []*Prototype {
 	protos := make([]*Prototype, len(fis))
 	for i, fi := range fis {
-		protos[i] = toProto(fi)
+		protos[i] = toProto(fi, source)
 	}
 
 	return protos
@@ -46,11 +49,34 @@ func getUpvalues(fi *funcInfo) []Upvalue {
 	upvals := make([]Upvalue, len(fi.upvalues))
 	for _, uv := range fi.upvalues {
 		if uv.locVarSlot >= 0 { // in stack
-			upvals[uv.index] = Upvalue{1, byte(uv.locVarSlot)}
+			upvals[uv.index] = Upvalue{Instack: 1, Idx: byte(uv.locVarSlot)}
 		} else {
-			upvals[uv.index] = Upvalue{0, byte(uv.upvalIndex)}
+			upvals[uv.index] = Upvalue{Instack: 0, Idx: byte(uv.upvalIndex)}
 		}
 	}
 
 	return upvals
 }
+
+// Local variables are listed in the order of declaration.
+func getLocVars(fi *funcInfo) []LocVar {
+	locVars := make([]LocVar, len(fi.locVars))
+	for i, locVar := range fi.locVars {
+		locVars[i] = LocVar{
+			VarName: locVar.name,
+			StartPC: uint32(locVar.startPC),
+			EndPC:   uint32(locVar.endPC),
+		}
+	}
+
+	return locVars
+}
+
+func getUpvalueNames(fi *funcInfo) []string {
+	names := make([]string, len(fi.upvalues))
+	for name, uv := range fi.upvalues {
+		names[uv.index] = name
+	}
+
+	return names
+}
@@ -1,6 +1,8 @@
 package codegen
 
 import (
+	"fmt"
+
 	. "github.com/gonearewe/lua-compiler/compiler/ast"
 	. "github.com/gonearewe/lua-compiler/compiler/lexer"
 	. "github.com/gonearewe/lua-compiler/vm"
@@ -22,7 +24,8 @@ var arithAndBitwiseBinops = map[int]int{
 }
 
 type funcInfo struct {
-	insts []uint32 // corresponded instructions in binary chunk
+	insts    []uint32 // corresponded instructions in binary chunk
+	lineNums []uint32 // source line of every instruction
 
 	constants map[interface{}]int // key is the constant's value and val is it's index in the constant list
 	usedRegs  int
@@ -32,7 +35,10 @@ type funcInfo struct {
 	locVars  []*locVarInfo          // all declared local variables in order
 	locNames map[string]*locVarInfo // current valid relationship between variable's name and the actual variable
 
-	breaks [][]int // maintain addresses of `break` jmp
+	breaks [][]int     // maintain addresses of `break` jmp
+	blocks []blockInfo // labels and gotos of every scope
+	labels []labelInfo // visible labels, the ones of outer blocks come first
+	gotos  []*gotoInfo // pending gotos, waiting for their labels
 
 	parent   *funcInfo
 	upvalues map[string]upvalInfo
@@ -40,6 +46,9 @@ type funcInfo struct {
 	subFuncs  []*funcInfo
 	numParams int
 	isVararg  bool
+	line      int    // where the function is defined
+	lastLine  int    // where the function ends
+	chunkName string // source of the chunk, for error messages
 }
 
 // In lua, variable's name is just a label, a rather different thing from variable itself.
@@ -50,6 +59,31 @@ type locVarInfo struct {
 	scopeLv  int         // level of scope
 	slot     int         // correspond index in the registers
 	captured bool        // whether it's captured by a closure
+	startPC  int         // address of the first instruction where it's active
+	endPC    int         // address of the first instruction where it's dead
+}
+
+// Labels and pending gotos in a scope begin at given indices,
+// a scope is left together with the labels and gotos in it.
+type blockInfo struct {
+	firstLabel int  // index of the first label of the scope
+	firstGoto  int  // index of the first pending goto of the scope
+	nActVars   int  // number of active local variables outside the scope
+	isRepeat   bool // whether it's the body of repeat-until, whose end is before `until`
+}
+
+type labelInfo struct {
+	name     string
+	line     int
+	pc       int // address of the instruction it marks
+	nActVars int // number of active local variables at the label
+}
+
+type gotoInfo struct {
+	name     string
+	line     int
+	pc       int // address of the jmp
+	nActVars int // number of active local variables at the goto
 }
 
 type upvalInfo struct {
@@ -58,7 +92,14 @@ type upvalInfo struct {
 	index      int
 }
 
+// The chunk name of a sub function is the one of its parent,
+// the main function has it set by GenProto().
 func newFuncInfo(parent *funcInfo, fd *FuncDefExp) *funcInfo {
+	chunkName := ""
+	if parent != nil {
+		chunkName = parent.chunkName
+	}
+
 	return &funcInfo{
 		parent:    parent,
 		subFuncs:  []*funcInfo{},
@@ -67,9 +108,14 @@ func newFuncInfo(parent *funcInfo, fd *FuncDefExp) *funcInfo {
 		upvalues:  map[string]upvalInfo{},
 		constants: map[interface{}]int{},
 		breaks:    make([][]int, 1),
+		blocks:    make([]blockInfo, 1),
 		insts:     make([]uint32, 0, 8),
+		lineNums:  make([]uint32, 0, 8),
 		numParams: len(fd.ParList),
 		isVararg:  fd.IsVararg,
+		line:      fd.Line,
+		lastLine:  fd.LastLine,
+		chunkName: chunkName,
 	}
 }
 
@@ -82,9 +128,13 @@ func (f *funcInfo) newFuncInfo(parent *funcInfo, fd *FuncDefExp) *funcInfo {
 		upvalues:  map[string]upvalInfo{},
 		constants: map[interface{}]int{},
 		breaks:    make([][]int, 1),
+		blocks:    make([]blockInfo, 1),
 		insts:     make([]uint32, 0, 8),
+		lineNums:  make([]uint32, 0, 8),
 		numParams: len(fd.ParList),
 		isVararg:  fd.IsVararg,
+		line:      fd.Line,
+		lastLine:  fd.LastLine,
 	}
 }
 
@@ -119,18 +169,17 @@ func (self *funcInfo) freeReg() {
 	self.usedRegs--
 }
 
-func (f *funcInfo) allocRegs() int {
-	f.usedRegs++
-
-	if f.usedRegs >= 255 {
-		panic("function or expression needs too many registers")
+// Allocate n continuous registers and return index of the first one.
+func (f *funcInfo) allocRegs(n int) int {
+	if n <= 0 {
+		panic("n <= 0 !")
 	}
 
-	if f.usedRegs > f.maxRegs {
-		f.maxRegs = f.usedRegs
+	for i := 0; i < n; i++ {
+		f.allocReg()
 	}
 
-	return f.usedRegs - 1
+	return f.usedRegs - n
 }
 
 func (f *funcInfo) freeRegs(n int) {
@@ -141,6 +190,11 @@ func (f *funcInfo) freeRegs(n int) {
 
 func (f *funcInfo) enterScope(breakable bool) {
 	f.scopeLv++
+	f.blocks = append(f.blocks, blockInfo{
+		firstLabel: len(f.labels),
+		firstGoto:  len(f.gotos),
+		nActVars:   f.usedRegs,
+	})
 
 	if breakable { // a loop scope
 		f.breaks = append(f.breaks, []int{})
@@ -149,9 +203,10 @@ func (f *funcInfo) enterScope(breakable bool) {
 	}
 }
 
-func (f *funcInfo) exitScope() {
+// Leave current scope, its local variables are dead from endPC.
+func (f *funcInfo) exitScope(endPC int) {
 	pendingBreakJmps := f.breaks[len(f.breaks)-1]
-	f.breaks = f.breaks[:len(f.breaks-1)]
+	f.breaks = f.breaks[:len(f.breaks)-1]
 	a := f.getJmpArgA()
 	for _, pc := range pendingBreakJmps {
 		sBx := f.pc() - pc
@@ -159,20 +214,32 @@ func (f *funcInfo) exitScope() {
 		f.insts[pc] = uint32(i)
 	}
 
+	block := f.blocks[len(f.blocks)-1]
+	f.blocks = f.blocks[:len(f.blocks)-1]
+	f.labels = f.labels[:block.firstLabel] // remove local labels
+	if len(f.blocks) > 0 {
+		f.moveGotosOut(block, a)
+	} else if block.firstGoto < len(f.gotos) { // pending gotos at the end of function
+		gt := f.gotos[block.firstGoto]
+		f.error(gt.line, "no visible label '%s' for <goto> at line %d", gt.name, gt.line)
+	}
+
 	f.scopeLv--
 	for _, locVar := range f.locNames {
 		if locVar.scopeLv > f.scopeLv {
-			f.removeLocVar(locVar)
+			f.removeLocVar(locVar, endPC)
 		}
 	}
 }
 
-func (f *funcInfo) addLocVar(name string) int {
+// Declare a local variable which is active from startPC, return its slot.
+func (f *funcInfo) addLocVar(name string, startPC int) int {
 	newVar := &locVarInfo{
 		name:    name,
 		prev:    f.locNames[name],
 		scopeLv: f.scopeLv,
 		slot:    f.allocReg(),
+		startPC: startPC,
 	}
 
 	f.locVars = append(f.locVars, newVar)
@@ -182,13 +249,14 @@ func (f *funcInfo) addLocVar(name string) int {
 }
 
 // Remove a local variable by freeing variable's register and collecting the name if it's used outside this scope.
-func (f *funcInfo) removeLocVar(locVar *locVarInfo) {
+func (f *funcInfo) removeLocVar(locVar *locVarInfo, endPC int) {
 	f.freeReg()
+	locVar.endPC = endPC
 
 	if locVar.prev == nil {
 		delete(f.locNames, locVar.name)
 	} else if locVar.prev.scopeLv == locVar.scopeLv {
-		f.removeLocVar(locVar.prev)
+		f.removeLocVar(locVar.prev, endPC)
 	} else {
 		f.locNames[locVar.name] = locVar.prev
 	}
@@ -203,7 +271,7 @@ func (f *funcInfo) slotOfLocVar(name string) int {
 	return -1
 }
 
-func (f *funcInfo) addBreakJmp(pc int) {
+func (f *funcInfo) addBreakJmp(pc, line int) {
 	for i := f.scopeLv; i >= 0; i-- {
 		if f.breaks[i] != nil {
 			// add jmp address for `break`
@@ -212,7 +280,104 @@ func (f *funcInfo) addBreakJmp(pc int) {
 		}
 	}
 
-	panic("<break> at line ? not inside a loop !")
+	f.error(line, "<break> at line %d not inside a loop", line)
+}
+
+// Panic with the message of a compile error at the line, like the lexer does.
+func (f *funcInfo) error(line int, format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	panic(fmt.Sprintf("%s:%d:    %s", ChunkID(f.chunkName), line, msg))
+}
+
+/* goto and label */
+
+// Add a goto whose jmp is at pc and try to close it with the labels of current scope.
+func (f *funcInfo) addGoto(name string, line, pc int) {
+	f.gotos = append(f.gotos, &gotoInfo{name, line, pc, f.usedRegs})
+	f.findLabel(len(f.gotos) - 1)
+}
+
+// Add a label at the next instruction and close pending gotos of current scope
+// to it; a label at the end of its scope is out of the scope of its local variables.
+func (f *funcInfo) addLabel(name string, line int, atEnd bool) {
+	block := &f.blocks[len(f.blocks)-1]
+	for _, lb := range f.labels[block.firstLabel:] {
+		if lb.name == name {
+			f.error(line, "label '%s' already defined on line %d", name, lb.line)
+		}
+	}
+
+	lb := labelInfo{name, line, len(f.insts), f.usedRegs}
+	if atEnd && !block.isRepeat {
+		lb.nActVars = block.nActVars
+	}
+	f.labels = append(f.labels, lb)
+
+	for i := block.firstGoto; i < len(f.gotos); {
+		if f.gotos[i].name == name {
+			f.closeGoto(i, lb)
+		} else {
+			i++
+		}
+	}
+}
+
+// Try to close the ith pending goto with the labels of current scope, return false if not found.
+func (f *funcInfo) findLabel(i int) bool {
+	gt := f.gotos[i]
+	for _, lb := range f.labels[f.blocks[len(f.blocks)-1].firstLabel:] {
+		if lb.name == gt.name {
+			if gt.nActVars > lb.nActVars { // jumps backward out of the scope of some locals
+				f.patchJmpArgA(gt.pc, lb.nActVars+1)
+			}
+			f.closeGoto(i, lb)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Let the ith pending goto jump to the label and remove it from the pending list.
+func (f *funcInfo) closeGoto(i int, lb labelInfo) {
+	gt := f.gotos[i]
+	if gt.nActVars < lb.nActVars {
+		f.error(gt.line, "<goto %s> at line %d jumps into the scope of local '%s'",
+			gt.name, gt.line, f.nameOfLocVarAt(gt.nActVars))
+	}
+
+	f.fixSbx(gt.pc, lb.pc-gt.pc-1)
+	f.gotos = append(f.gotos[:i], f.gotos[i+1:]...)
+}
+
+// Let pending gotos of the scope just left be pending in the enclosing scope,
+// upvalues from a-1 have to be closed on the way out if a > 0.
+func (f *funcInfo) moveGotosOut(block blockInfo, a int) {
+	for i := block.firstGoto; i < len(f.gotos); {
+		gt := f.gotos[i]
+		if gt.nActVars > block.nActVars {
+			if a > 0 {
+				f.patchJmpArgA(gt.pc, a)
+			}
+			gt.nActVars = block.nActVars
+		}
+		if !f.findLabel(i) {
+			i++ // move to next one
+		}
+	}
+}
+
+// Return the name of the active local variable at given slot.
+func (f *funcInfo) nameOfLocVarAt(slot int) string {
+	for _, locVar := range f.locNames {
+		for v := locVar; v != nil; v = v.prev {
+			if v.slot == slot {
+				return v.name
+			}
+		}
+	}
+
+	return "?"
 }
 
 // Return the index of upval bound with given name,
@@ -225,7 +390,7 @@ func (f *funcInfo) indexOfUpval(name string) int {
 	if f.parent != nil {
 		if locVar, found := f.parent.locNames[name]; found {
 			idx := len(f.upvalues)
-			f.upvalues[name] = upvalInfo{locVar.slot}
+			f.upvalues[name] = upvalInfo{locVar.slot, -1, idx}
 			locVar.captured = true
 
 			return idx
@@ -255,193 +420,211 @@ func (self *funcInfo) fixSbx(pc, sBx int) {
 	self.insts[pc] = i
 }
 
-func (self *funcInfo) emitABC(opcode, a, b, c int) {
+// Set argument A of the jmp at pc to close upvalues from r[a-1],
+// keep the current one if it closes more.
+func (f *funcInfo) patchJmpArgA(pc, a int) {
+	i := f.insts[pc]
+	if oldA := int(i >> 6 & 0xff); oldA == 0 || a < oldA {
+		f.insts[pc] = i&^(0xff<<6) | uint32(a)<<6
+	}
+}
+
+func (self *funcInfo) emitABC(line, opcode, a, b, c int) {
 	i := b<<23 | c<<14 | a<<6 | opcode
 	self.insts = append(self.insts, uint32(i))
+	self.lineNums = append(self.lineNums, uint32(line))
 }
 
-func (self *funcInfo) emitABx(opcode, a, bx int) {
+func (self *funcInfo) emitABx(line, opcode, a, bx int) {
 	i := bx<<14 | a<<6 | opcode
 	self.insts = append(self.insts, uint32(i))
+	self.lineNums = append(self.lineNums, uint32(line))
 }
 
-func (self *funcInfo) emitAsBx(opcode, a, b int) {
+func (self *funcInfo) emitAsBx(line, opcode, a, b int) {
 	i := (b+MAXARG_sBx)<<14 | a<<6 | opcode
 	self.insts = append(self.insts, uint32(i))
+	self.lineNums = append(self.lineNums, uint32(line))
 }
 
-func (self *funcInfo) emitAx(opcode, ax int) {
+func (self *funcInfo) emitAx(line, opcode, ax int) {
 	i := ax<<6 | opcode
 	self.insts = append(self.insts, uint32(i))
+	self.lineNums = append(self.lineNums, uint32(line))
 }
 
 // r[a] = r[b]
-func (self *funcInfo) emitMove(a, b int) {
-	self.emitABC(OP_MOVE, a, b, 0)
+func (self *funcInfo) emitMove(line, a, b int) {
+	self.emitABC(line, OP_MOVE, a, b, 0)
 }
 
 // r[a], r[a+1], ..., r[a+b] = nil
-func (self *funcInfo) emitLoadNil(a, n int) {
-	self.emitABC(OP_LOADNIL, a, n-1, 0)
+func (self *funcInfo) emitLoadNil(line, a, n int) {
+	self.emitABC(line, OP_LOADNIL, a, n-1, 0)
 }
 
 // r[a] = (bool)b; if (c) pc++
-func (self *funcInfo) emitLoadBool(a, b, c int) {
-	self.emitABC(OP_LOADBOOL, a, b, c)
+func (self *funcInfo) emitLoadBool(line, a, b, c int) {
+	self.emitABC(line, OP_LOADBOOL, a, b, c)
 }
 
 // r[a] = kst[bx]
-func (self *funcInfo) emitLoadK(a int, k interface{}) {
+func (self *funcInfo) emitLoadK(line, a int, k interface{}) {
 	idx := self.indexOfConstant(k)
 	if idx < (1 << 18) {
-		self.emitABx(OP_LOADK, a, idx)
+		self.emitABx(line, OP_LOADK, a, idx)
 	} else {
-		self.emitABx(OP_LOADKX, a, 0)
-		self.emitAx(OP_EXTRAARG, idx)
+		self.emitABx(line, OP_LOADKX, a, 0)
+		self.emitAx(line, OP_EXTRAARG, idx)
 	}
 }
 
 // r[a], r[a+1], ..., r[a+b-2] = vararg
-func (self *funcInfo) emitVararg(a, n int) {
-	self.emitABC(OP_VARARG, a, n+1, 0)
+func (self *funcInfo) emitVararg(line, a, n int) {
+	self.emitABC(line, OP_VARARG, a, n+1, 0)
 }
 
 // r[a] = emitClosure(proto[bx])
-func (self *funcInfo) emitClosure(a, bx int) {
-	self.emitABx(OP_CLOSURE, a, bx)
+func (self *funcInfo) emitClosure(line, a, bx int) {
+	self.emitABx(line, OP_CLOSURE, a, bx)
 }
 
 // r[a] = {}
-func (self *funcInfo) emitNewTable(a, nArr, nRec int) {
-	self.emitABC(OP_NEWTABLE,
+func (self *funcInfo) emitNewTable(line, a, nArr, nRec int) {
+	self.emitABC(line, OP_NEWTABLE,
 		a, Int2fb(nArr), Int2fb(nRec))
 }
 
 // r[a][(c-1)*FPF+i] := r[a+i], 1 <= i <= b
-func (self *funcInfo) emitSetList(a, b, c int) {
-	self.emitABC(OP_SETLIST, a, b, c)
+func (self *funcInfo) emitSetList(line, a, b, c int) {
+	if c < (1 << 9) {
+		self.emitABC(line, OP_SETLIST, a, b, c)
+	} else {
+		self.emitABC(line, OP_SETLIST, a, b, 0)
+		self.emitAx(line, OP_EXTRAARG, c)
+	}
 }
 
 // r[a] := r[b][rk(c)]
-func (self *funcInfo) emitGetTable(a, b, c int) {
-	self.emitABC(OP_GETTABLE, a, b, c)
+func (self *funcInfo) emitGetTable(line, a, b, c int) {
+	self.emitABC(line, OP_GETTABLE, a, b, c)
 }
 
 // r[a][rk(b)] = rk(c)
-func (self *funcInfo) emitSetTable(a, b, c int) {
-	self.emitABC(OP_SETTABLE, a, b, c)
+func (self *funcInfo) emitSetTable(line, a, b, c int) {
+	self.emitABC(line, OP_SETTABLE, a, b, c)
 }
 
 // r[a] = upval[b]
-func (self *funcInfo) emitGetUpval(a, b int) {
-	self.emitABC(OP_GETUPVAL, a, b, 0)
+func (self *funcInfo) emitGetUpval(line, a, b int) {
+	self.emitABC(line, OP_GETUPVAL, a, b, 0)
 }
 
 // upval[b] = r[a]
-func (self *funcInfo) emitSetUpval(a, b int) {
-	self.emitABC(OP_SETUPVAL, a, b, 0)
+func (self *funcInfo) emitSetUpval(line, a, b int) {
+	self.emitABC(line, OP_SETUPVAL, a, b, 0)
 }
 
 // r[a] = upval[b][rk(c)]
-func (self *funcInfo) emitGetTabUp(a, b, c int) {
-	self.emitABC(OP_GETTABUP, a, b, c)
+func (self *funcInfo) emitGetTabUp(line, a, b, c int) {
+	self.emitABC(line, OP_GETTABUP, a, b, c)
 }
 
 // upval[a][rk(b)] = rk(c)
-func (self *funcInfo) emitSetTabUp(a, b, c int) {
-	self.emitABC(OP_SETTABUP, a, b, c)
+func (self *funcInfo) emitSetTabUp(line, a, b, c int) {
+	self.emitABC(line, OP_SETTABUP, a, b, c)
 }
 
 // r[a], ..., r[a+c-2] = r[a](r[a+1], ..., r[a+b-1])
-func (self *funcInfo) emitCall(a, nArgs, nRet int) {
-	self.emitABC(OP_CALL, a, nArgs+1, nRet+1)
+func (self *funcInfo) emitCall(line, a, nArgs, nRet int) {
+	self.emitABC(line, OP_CALL, a, nArgs+1, nRet+1)
 }
 
 // return r[a](r[a+1], ... ,r[a+b-1])
-func (self *funcInfo) emitTailCall(a, nArgs int) {
-	self.emitABC(OP_TAILCALL, a, nArgs+1, 0)
+func (self *funcInfo) emitTailCall(line, a, nArgs int) {
+	self.emitABC(line, OP_TAILCALL, a, nArgs+1, 0)
 }
 
 // return r[a], ... ,r[a+b-2]
-func (self *funcInfo) emitReturn(a, n int) {
-	self.emitABC(OP_RETURN, a, n+1, 0)
+func (self *funcInfo) emitReturn(line, a, n int) {
+	self.emitABC(line, OP_RETURN, a, n+1, 0)
 }
 
 // r[a+1] := r[b]; r[a] := r[b][rk(c)]
-func (self *funcInfo) emitSelf(a, b, c int) {
-	self.emitABC(OP_SELF, a, b, c)
+func (self *funcInfo) emitSelf(line, a, b, c int) {
+	self.emitABC(line, OP_SELF, a, b, c)
 }
 
 // pc+=sBx; if (a) close all upvalues >= r[a - 1]
-func (self *funcInfo) emitJmp(a, sBx int) int {
-	self.emitAsBx(OP_JMP, a, sBx)
+func (self *funcInfo) emitJmp(line, a, sBx int) int {
+	self.emitAsBx(line, OP_JMP, a, sBx)
 	return len(self.insts) - 1
 }
 
 // if not (r[a] <=> c) then pc++
-func (self *funcInfo) emitTest(a, c int) {
-	self.emitABC(OP_TEST, a, 0, c)
+func (self *funcInfo) emitTest(line, a, c int) {
+	self.emitABC(line, OP_TEST, a, 0, c)
 }
 
 // if (r[b] <=> c) then r[a] := r[b] else pc++
-func (self *funcInfo) emitTestSet(a, b, c int) {
-	self.emitABC(OP_TESTSET, a, b, c)
+func (self *funcInfo) emitTestSet(line, a, b, c int) {
+	self.emitABC(line, OP_TESTSET, a, b, c)
 }
 
-func (self *funcInfo) emitForPrep(a, sBx int) int {
-	self.emitAsBx(OP_FORPREP, a, sBx)
+func (self *funcInfo) emitForPrep(line, a, sBx int) int {
+	self.emitAsBx(line, OP_FORPREP, a, sBx)
 	return len(self.insts) - 1
 }
 
-func (self *funcInfo) emitForLoop(a, sBx int) int {
-	self.emitAsBx(OP_FORLOOP, a, sBx)
+func (self *funcInfo) emitForLoop(line, a, sBx int) int {
+	self.emitAsBx(line, OP_FORLOOP, a, sBx)
 	return len(self.insts) - 1
 }
 
-func (self *funcInfo) emitTForCall(a, c int) {
-	self.emitABC(OP_TFORCALL, a, 0, c)
+func (self *funcInfo) emitTForCall(line, a, c int) {
+	self.emitABC(line, OP_TFORCALL, a, 0, c)
 }
 
-func (self *funcInfo) emitTForLoop(a, sBx int) {
-	self.emitAsBx(OP_TFORLOOP, a, sBx)
+func (self *funcInfo) emitTForLoop(line, a, sBx int) {
+	self.emitAsBx(line, OP_TFORLOOP, a, sBx)
 }
 
 // r[a] = op r[b]
-func (self *funcInfo) emitUnaryOp(op, a, b int) {
+func (self *funcInfo) emitUnaryOp(line, op, a, b int) {
 	switch op {
 	case TOKEN_OP_NOT:
-		self.emitABC(OP_NOT, a, b, 0)
+		self.emitABC(line, OP_NOT, a, b, 0)
 	case TOKEN_OP_BNOT:
-		self.emitABC(OP_BNOT, a, b, 0)
+		self.emitABC(line, OP_BNOT, a, b, 0)
 	case TOKEN_OP_LEN:
-		self.emitABC(OP_LEN, a, b, 0)
+		self.emitABC(line, OP_LEN, a, b, 0)
 	case TOKEN_OP_UNM:
-		self.emitABC(OP_UNM, a, b, 0)
+		self.emitABC(line, OP_UNM, a, b, 0)
 	}
 }
 
 // r[a] = rk[b] op rk[c]
 // arith & bitwise & relational
-func (self *funcInfo) emitBinaryOp(op, a, b, c int) {
+func (self *funcInfo) emitBinaryOp(line, op, a, b, c int) {
 	if opcode, found := arithAndBitwiseBinops[op]; found {
-		self.emitABC(opcode, a, b, c)
+		self.emitABC(line, opcode, a, b, c)
 	} else {
 		switch op {
 		case TOKEN_OP_EQ:
-			self.emitABC(OP_EQ, 1, b, c)
+			self.emitABC(line, OP_EQ, 1, b, c)
 		case TOKEN_OP_NE:
-			self.emitABC(OP_EQ, 0, b, c)
+			self.emitABC(line, OP_EQ, 0, b, c)
 		case TOKEN_OP_LT:
-			self.emitABC(OP_LT, 1, b, c)
+			self.emitABC(line, OP_LT, 1, b, c)
 		case TOKEN_OP_GT:
-			self.emitABC(OP_LT, 1, c, b)
+			self.emitABC(line, OP_LT, 1, c, b)
 		case TOKEN_OP_LE:
-			self.emitABC(OP_LE, 1, b, c)
+			self.emitABC(line, OP_LE, 1, b, c)
 		case TOKEN_OP_GE:
-			self.emitABC(OP_LE, 1, c, b)
+			self.emitABC(line, OP_LE, 1, c, b)
 		}
-		self.emitJmp(0, 1)
-		self.emitLoadBool(a, 0, 1)
-		self.emitLoadBool(a, 1, 0)
+		self.emitJmp(line, 0, 1)
+		self.emitLoadBool(line, a, 0, 1)
+		self.emitLoadBool(line, a, 1, 0)
 	}
 }
@@ -0,0 +1,135 @@
+package codegen_test
+
+import (
+	"fmt"
+	"testing"
+
+	. "github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/compiler/codegen"
+	"github.com/gonearewe/lua-compiler/compiler/parser"
+	"github.com/gonearewe/lua-compiler/state"
+)
+
+// Compile the chunk, return the message of the compile error, "" if none.
+func compileError(chunk string) (msg string) {
+	defer func() {
+		if err := recover(); err != nil {
+			msg = fmt.Sprint(err)
+		}
+	}()
+	codegen.GenProto(parser.Parse(chunk, "=test"), "=test")
+	return ""
+}
+
+// Run the chunk and return its result, which must be an integer.
+func runInteger(t *testing.T, chunk string) int64 {
+	t.Helper()
+	ls := state.New()
+	if ls.Load([]byte(chunk), "=test", "t") != LUA_OK || ls.PCall(0, 1, 0) != LUA_OK {
+		t.Fatalf("%s: %s", chunk, ls.ToString(-1))
+	}
+	n, ok := ls.ToIntegerX(-1)
+	if !ok {
+		t.Fatalf("%s: result is %s, want an integer", chunk, ls.TypeNameOf(-1))
+	}
+	return n
+}
+
+func TestGoto(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  int64
+	}{
+		{ // continue
+			`local s = 0
+			for i = 1, 10 do
+				if i % 2 == 0 then goto continue end
+				s = s + i
+				::continue::
+			end
+			return s`, 25,
+		},
+		{ // backward jump as a loop
+			`local i = 0
+			::top::
+			i = i + 1
+			if i < 5 then goto top end
+			return i`, 5,
+		},
+		{ // out of nested loops
+			`local n = 0
+			for i = 1, 3 do
+				for j = 1, 3 do
+					n = n + 1
+					if i * j == 4 then goto done end
+				end
+			end
+			::done::
+			return n`, 5,
+		},
+		{ // forward to a label at the end of a block, past a local
+			`local x = 1
+			do
+				goto skip
+				local y = 2
+				x = y
+				::skip::
+			end
+			return x`, 1,
+		},
+		{ // locals captured by closures are fresh after a backward goto
+			`local fs = {}
+			local i = 1
+			::again::
+			local v = i
+			fs[i] = function() return v end
+			i = i + 1
+			if i <= 3 then goto again end
+			return fs[1]() * 100 + fs[2]() * 10 + fs[3]()`, 123,
+		},
+		{ // a label at the end of repeat is still in the scope of its locals
+			`local n = 0
+			repeat
+				local done = n >= 2
+				n = n + 1
+				goto next
+				::next::
+			until done
+			return n`, 3,
+		},
+		{ // labels with the same name in nested functions
+			`local function f() goto l; do return 1 end ::l:: return 2 end
+			goto l
+			do return 3 end
+			::l::
+			return f()`, 2,
+		},
+	}
+
+	for _, test := range tests {
+		if got := runInteger(t, test.chunk); got != test.want {
+			t.Errorf("%s = %d, want %d", test.chunk, got, test.want)
+		}
+	}
+}
+
+func TestGotoErrors(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		{"goto nowhere", "test:1:    no visible label 'nowhere' for <goto> at line 1"},
+		{"do ::l:: end\ngoto l", "test:2:    no visible label 'l' for <goto> at line 2"},
+		{"local function f()\ngoto outer\nend\n::outer::", "test:2:    no visible label 'outer' for <goto> at line 2"},
+		{"::a::\n::a::", "test:2:    label 'a' already defined on line 1"},
+		{"goto l\nlocal a\n::l::\nprint(a)", "test:1:    <goto l> at line 1 jumps into the scope of local 'a'"},
+		{"repeat goto c; local z ::c:: until z", "test:1:    <goto c> at line 1 jumps into the scope of local 'z'"},
+		{"for i = 1, 2 do end\nbreak", "test:2:    <break> at line 2 not inside a loop"},
+	}
+
+	for _, test := range tests {
+		if got := compileError(test.chunk); got != test.want {
+			t.Errorf("compiling %q: error %q, want %q", test.chunk, got, test.want)
+		}
+	}
+}
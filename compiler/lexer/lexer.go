@@ -441,6 +441,44 @@ func (l *Lexer) escape(str string) string {
 // Panic with given params and chunk file name, line index.
 func (l *Lexer) error(f string, a ...interface{}) {
 	err := fmt.Sprintf(f, a...)
-	err = fmt.Sprintf("%s:%d:    %s", l.chunkName, l.line, err)
+	err = fmt.Sprintf("%s:%d:    %s", ChunkID(l.chunkName), l.line, err)
 	panic(err)
 }
+
+// the longest printable form of a chunk name, including the '\0' of C
+const LUA_IDSIZE = 60
+
+// Return the printable form of a chunk name for messages: names starting
+// with '=' are used as is, names starting with '@' are file names while
+// others are the source code itself, like the reference implementation does.
+func ChunkID(source string) string {
+	const maxLen = LUA_IDSIZE - 1
+	switch {
+	case strings.HasPrefix(source, "="):
+		source = source[1:]
+		if len(source) > maxLen {
+			source = source[:maxLen]
+		}
+		return source
+	case strings.HasPrefix(source, "@"):
+		source = source[1:]
+		if len(source) > maxLen {
+			source = "..." + source[len(source)-maxLen+3:] // keep the end of the path
+		}
+		return source
+	default:
+		const pre, dots, pos = `[string "`, "...", `"]`
+		maxLine := maxLen - len(pre) - len(dots) - len(pos)
+		line := source
+		if i := strings.IndexAny(line, "\n\r"); i >= 0 {
+			line = line[:i]
+		}
+		if len(line) > maxLine {
+			line = line[:maxLine]
+		}
+		if len(line) < len(source) {
+			line += dots
+		}
+		return pre + line + pos
+	}
+}
@@ -48,7 +48,7 @@ func parseExp12(lexer *Lexer) Exp {
 	exp := parseExp11(lexer)
 	for lexer.LookAhead() == TOKEN_OP_OR {
 		line, op, _ := lexer.NextToken()
-		lor := &BinopExp{line, op, exp, parseExp11(lexer)}
+		lor := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp11(lexer)}
 		exp = optimizeLogicalOr(lor)
 	}
 	return exp
@@ -59,7 +59,7 @@ func parseExp11(lexer *Lexer) Exp {
 	exp := parseExp10(lexer)
 	for lexer.LookAhead() == TOKEN_OP_AND {
 		line, op, _ := lexer.NextToken()
-		land := &BinopExp{line, op, exp, parseExp10(lexer)}
+		land := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp10(lexer)}
 		exp = optimizeLogicalAnd(land)
 	}
 	return exp
@@ -73,12 +73,11 @@ func parseExp10(lexer *Lexer) Exp {
 		case TOKEN_OP_LT, TOKEN_OP_GT, TOKEN_OP_NE,
 			TOKEN_OP_LE, TOKEN_OP_GE, TOKEN_OP_EQ:
 			line, op, _ := lexer.NextToken()
-			exp = &BinopExp{line, op, exp, parseExp9(lexer)}
+			exp = &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp9(lexer)}
 		default:
 			return exp
 		}
 	}
-	return exp
 }
 
 // x | y
@@ -86,7 +85,7 @@ func parseExp9(lexer *Lexer) Exp {
 	exp := parseExp8(lexer)
 	for lexer.LookAhead() == TOKEN_OP_BOR {
 		line, op, _ := lexer.NextToken()
-		bor := &BinopExp{line, op, exp, parseExp8(lexer)}
+		bor := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp8(lexer)}
 		exp = optimizeBitwiseBinaryOp(bor)
 	}
 	return exp
@@ -97,7 +96,7 @@ func parseExp8(lexer *Lexer) Exp {
 	exp := parseExp7(lexer)
 	for lexer.LookAhead() == TOKEN_OP_BXOR {
 		line, op, _ := lexer.NextToken()
-		bxor := &BinopExp{line, op, exp, parseExp7(lexer)}
+		bxor := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp7(lexer)}
 		exp = optimizeBitwiseBinaryOp(bxor)
 	}
 	return exp
@@ -108,7 +107,7 @@ func parseExp7(lexer *Lexer) Exp {
 	exp := parseExp6(lexer)
 	for lexer.LookAhead() == TOKEN_OP_BAND {
 		line, op, _ := lexer.NextToken()
-		band := &BinopExp{line, op, exp, parseExp6(lexer)}
+		band := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp6(lexer)}
 		exp = optimizeBitwiseBinaryOp(band)
 	}
 	return exp
@@ -121,13 +120,12 @@ func parseExp6(lexer *Lexer) Exp {
 		switch lexer.LookAhead() {
 		case TOKEN_OP_SHL, TOKEN_OP_SHR:
 			line, op, _ := lexer.NextToken()
-			shx := &BinopExp{line, op, exp, parseExp5(lexer)}
+			shx := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp5(lexer)}
 			exp = optimizeBitwiseBinaryOp(shx)
 		default:
 			return exp
 		}
 	}
-	return exp
 }
 
 // a .. b
@@ -143,7 +141,7 @@ func parseExp5(lexer *Lexer) Exp {
 		line, _, _ = lexer.NextToken()
 		exps = append(exps, parseExp4(lexer))
 	}
-	return &ConcatExp{line, exps}
+	return &ConcatExp{Line: line, Exps: exps}
 }
 
 // x +/- y
@@ -153,13 +151,12 @@ func parseExp4(lexer *Lexer) Exp {
 		switch lexer.LookAhead() {
 		case TOKEN_OP_ADD, TOKEN_OP_SUB:
 			line, op, _ := lexer.NextToken()
-			arith := &BinopExp{line, op, exp, parseExp3(lexer)}
+			arith := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp3(lexer)}
 			exp = optimizeArithBinaryOp(arith)
 		default:
 			return exp
 		}
 	}
-	return exp
 }
 
 // *, %, /, //
@@ -169,13 +166,12 @@ func parseExp3(lexer *Lexer) Exp {
 		switch lexer.LookAhead() {
 		case TOKEN_OP_MUL, TOKEN_OP_MOD, TOKEN_OP_DIV, TOKEN_OP_IDIV:
 			line, op, _ := lexer.NextToken()
-			arith := &BinopExp{line, op, exp, parseExp2(lexer)}
+			arith := &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp2(lexer)}
 			exp = optimizeArithBinaryOp(arith)
 		default:
 			return exp
 		}
 	}
-	return exp
 }
 
 // unary
@@ -183,7 +179,7 @@ func parseExp2(lexer *Lexer) Exp {
 	switch lexer.LookAhead() {
 	case TOKEN_OP_UNM, TOKEN_OP_BNOT, TOKEN_OP_LEN, TOKEN_OP_NOT:
 		line, op, _ := lexer.NextToken()
-		exp := &UnopExp{line, op, parseExp2(lexer)}
+		exp := &UnopExp{Line: line, Op: op, Exp: parseExp2(lexer)}
 		return optimizeUnaryOp(exp)
 	}
 	return parseExp1(lexer)
@@ -194,7 +190,7 @@ func parseExp1(lexer *Lexer) Exp { // pow is right associative
 	exp := parseExp0(lexer)
 	if lexer.LookAhead() == TOKEN_OP_POW {
 		line, op, _ := lexer.NextToken()
-		exp = &BinopExp{line, op, exp, parseExp2(lexer)}
+		exp = &BinopExp{Line: line, Op: op, Exp1: exp, Exp2: parseExp2(lexer)}
 	}
 	return optimizePow(exp)
 }
@@ -203,19 +199,19 @@ func parseExp0(lexer *Lexer) Exp {
 	switch lexer.LookAhead() {
 	case TOKEN_VARARG: // ...
 		line, _, _ := lexer.NextToken()
-		return &VarargExp{line}
+		return &VarargExp{Line: line}
 	case TOKEN_KW_NIL: // nil
 		line, _, _ := lexer.NextToken()
-		return &NilExp{line}
+		return &NilExp{Line: line}
 	case TOKEN_KW_TRUE: // true
 		line, _, _ := lexer.NextToken()
-		return &TrueExp{line}
+		return &TrueExp{Line: line}
 	case TOKEN_KW_FALSE: // false
 		line, _, _ := lexer.NextToken()
-		return &FalseExp{line}
+		return &FalseExp{Line: line}
 	case TOKEN_STRING: // LiteralString
 		line, _, token := lexer.NextToken()
-		return &StringExp{line, token}
+		return &StringExp{Line: line, Str: token}
 	case TOKEN_NUMBER: // Numeral
 		return parseNumberExp(lexer)
 	case TOKEN_SEP_LCURLY: // tableconstructor
@@ -231,9 +227,9 @@ func parseExp0(lexer *Lexer) Exp {
 func parseNumberExp(lexer *Lexer) Exp {
 	line, _, token := lexer.NextToken()
 	if i, ok := number.ParseInteger(token); ok {
-		return &IntegerExp{line, i}
+		return &IntegerExp{Line: line, Val: i}
 	} else if f, ok := number.ParseFloat(token); ok {
-		return &FloatExp{line, f}
+		return &FloatExp{Line: line, Val: f}
 	} else { // todo
 		panic("not a number: " + token)
 	}
@@ -247,7 +243,7 @@ func parseFuncDefExp(lexer *Lexer) *FuncDefExp {
 	block := parseBlock(lexer)
 	lastLine, _ := lexer.NextTokenOfKind(TOKEN_KW_END) // `end`
 
-	return &FuncDefExp{line, lastLine, parList, isVararg, block}
+	return &FuncDefExp{Line: line, LastLine: lastLine, ParList: parList, IsVararg: isVararg, Block: block}
 }
 
 func _parseParList(lexer *Lexer) (names []string, isVararg bool) {
@@ -284,7 +280,7 @@ func parseTableConstructorExp(lexer *Lexer) *TableConstructorExp {
 	keyExps, valExps := _parseFieldList(lexer) // [fieldlist]
 	lexer.NextTokenOfKind(TOKEN_SEP_RCURLY)    // `}`
 	lastLine := lexer.Line()
-	return &TableConstructorExp{line, lastLine, keyExps, valExps}
+	return &TableConstructorExp{Line: line, LastLine: lastLine, KeyExps: keyExps, ValExps: valExps}
 }
 
 func _parseFieldList(lexer *Lexer) (ks, vs []Exp) {
@@ -326,7 +322,7 @@ func _parseField(lexer *Lexer) (k, v Exp) {
 		if lexer.LookAhead() == TOKEN_OP_ASSIGN {
 			// Name `=` exp => `[` LiteralString `]` `=` exp
 			lexer.NextToken()
-			k = &StringExp{nameExp.Line, nameExp.Name}
+			k = &StringExp{Line: nameExp.Line, Str: nameExp.Name}
 			v = parseExp(lexer)
 
 			return
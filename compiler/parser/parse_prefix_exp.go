@@ -9,7 +9,7 @@ func parsePrefixExp(lexer *Lexer) Exp {
 	var exp Exp
 	if lexer.LookAhead() == TOKEN_IDENTIFIER {
 		line, name := lexer.NextIdentifier() // Name
-		exp = &NameExp{line, name}
+		exp = &NameExp{Line: line, Name: name}
 	} else {
 		exp = parseParensExp(lexer) // `(` exp `)`
 	}
@@ -24,20 +24,18 @@ func _finishPrefixExp(lexer *Lexer, exp Exp) Exp {
 			lexer.NextToken()                       // `[`
 			keyExp := parseExp(lexer)               // exp
 			lexer.NextTokenOfKind(TOKEN_SEP_RBRACK) // `]`
-			exp = &TableAccessExp{lexer.Line(), exp, keyExp}
+			exp = &TableAccessExp{LastLine: lexer.Line(), PrefixExp: exp, KeyExp: keyExp}
 		case TOKEN_SEP_DOT:
 			lexer.NextToken()                    // `.`
 			line, name := lexer.NextIdentifier() // Name
-			keyExp := &StringExp{line, name}
-			exp = &TableAccessExp{line, exp, keyExp}
+			keyExp := &StringExp{Line: line, Str: name}
+			exp = &TableAccessExp{LastLine: line, PrefixExp: exp, KeyExp: keyExp}
 		case TOKEN_SEP_COLON, TOKEN_SEP_LPAREN, TOKEN_SEP_LCURLY, TOKEN_STRING:
 			exp = _finishFuncCallExp(lexer, exp) // [`:` Name] args
 		default:
 			return exp
 		}
 	}
-
-	return exp
 }
 
 func parseParensExp(lexer *Lexer) Exp {
@@ -47,7 +45,7 @@ func parseParensExp(lexer *Lexer) Exp {
 
 	switch exp.(type) {
 	case *VarargExp, *FuncCallStat, *NameExp, *TableAccessExp:
-		return &ParensExp{exp}
+		return &ParensExp{Exp: exp}
 	}
 
 	return exp
@@ -59,7 +57,7 @@ func _finishFuncCallExp(lexer *Lexer, prefixExp Exp) *FuncCallExp {
 	args := _parseArgs(lexer) // args
 	lastLine := lexer.Line()
 
-	return &FuncCallExp{line, lastLine, prefixExp, nameExp, args}
+	return &FuncCallExp{Line: line, LastLine: lastLine, PrefixExp: prefixExp, NameExp: nameExp, Args: args}
 }
 
 func _parseNameExp(lexer *Lexer) *StringExp {
@@ -67,7 +65,7 @@ func _parseNameExp(lexer *Lexer) *StringExp {
 		lexer.NextToken()
 		line, name := lexer.NextIdentifier()
 
-		return &StringExp{line, name}
+		return &StringExp{Line: line, Str: name}
 	}
 
 	return nil
@@ -85,7 +83,7 @@ func _parseArgs(lexer *Lexer) (args []Exp) {
 		args = []Exp{parseTableConstructorExp(lexer)}
 	default: // Literal String
 		line, str := lexer.NextTokenOfKind(TOKEN_STRING)
-		args = []Exp{&StringExp{line, str}}
+		args = []Exp{&StringExp{Line: line, Str: str}}
 	}
 
 	return
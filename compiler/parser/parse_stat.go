@@ -7,6 +7,7 @@ import (
 
 /*
 stat ::=  ‘;’
+
 	| break
 	| ‘::’ Name ‘::’
 	| goto Name
@@ -60,24 +61,24 @@ func parseEmptyStat(lexer *Lexer) *EmptyStat {
 // `break`
 func parseBreakStat(lexer *Lexer) *BreakStat {
 	lexer.NextTokenOfKind(TOKEN_KW_BREAK)
-	return &BreakStat{lexer.Line()}
+	return &BreakStat{Line: lexer.Line()}
 }
 
 // `::label_name::`
 func parseLabelStat(lexer *Lexer) *LabelStat {
 	lexer.NextTokenOfKind(TOKEN_SEP_LABEL) // `::`
-	_, name := lexer.NextIdentifier()      // Name
+	line, name := lexer.NextIdentifier()   // Name
 	lexer.NextTokenOfKind(TOKEN_SEP_LABEL) // `::`
 
-	return &LabelStat{name}
+	return &LabelStat{Line: line, Name: name}
 }
 
 // `goto label_name`
 func parseGotoStat(lexer *Lexer) *GotoStat {
-	lexer.NextTokenOfKind(TOKEN_KW_GOTO) // `goto`
-	_, name := lexer.NextIdentifier()    // Name
+	line, _ := lexer.NextTokenOfKind(TOKEN_KW_GOTO) // `goto`
+	_, name := lexer.NextIdentifier()               // Name
 
-	return &GotoStat{name}
+	return &GotoStat{Line: line, Name: name}
 }
 
 // `do block end`
@@ -86,7 +87,7 @@ func parseDoStat(lexer *Lexer) *DoStat {
 	block := parseBlock(lexer)          // block
 	lexer.NextTokenOfKind(TOKEN_KW_END) // `end`
 
-	return &DoStat{block}
+	return &DoStat{Block: block}
 }
 
 // `while exp do block end`
@@ -97,7 +98,7 @@ func parseWhileStat(lexer *Lexer) *WhileStat {
 	block := parseBlock(lexer)            // block
 	lexer.NextTokenOfKind(TOKEN_KW_END)   // `end`
 
-	return &WhileStat{exp, block}
+	return &WhileStat{Exp: exp, Block: block}
 
 }
 
@@ -107,7 +108,7 @@ func parseRepeatStat(lexer *Lexer) *RepeatStat {
 	block := parseBlock(lexer)             // block
 	lexer.NextTokenOfKind(TOKEN_KW_UNTIL)  // `until`
 	exp := parseExp(lexer)                 // exp
-	return &RepeatStat{block, exp}
+	return &RepeatStat{Block: block, Exp: exp}
 }
 
 // `if exp then block {elseif exp then block} [else block] end`
@@ -130,13 +131,13 @@ func parseIfStat(lexer *Lexer) *IfStat {
 	// else block => elseif true then block
 	if lexer.LookAhead() == TOKEN_KW_ELSE {
 		lexer.NextToken() // else
-		exps = append(exps, &TrueExp{lexer.Line()})
+		exps = append(exps, &TrueExp{Line: lexer.Line()})
 		blocks = append(blocks, parseBlock(lexer)) // block
 	}
 
 	lexer.NextTokenOfKind(TOKEN_KW_END) // end
 
-	return &IfStat{exps, blocks}
+	return &IfStat{Exps: exps, Blocks: blocks}
 }
 
 /* for loop statement */
@@ -162,14 +163,14 @@ func _finishForNumStat(lexer *Lexer, lineOfFor int, varName string) *ForNumStat
 		lexer.NextToken() // `,`
 		stepExp = parseExp(lexer)
 	} else {
-		stepExp = &IntegerExp{lexer.Line(), 1} // default step is 1
+		stepExp = &IntegerExp{Line: lexer.Line(), Val: 1} // default step is 1
 	}
 
 	lineOfDo, _ := lexer.NextTokenOfKind(TOKEN_KW_DO) // `do`
 	block := parseBlock(lexer)                        // block
 	lexer.NextTokenOfKind(TOKEN_KW_END)               // `end`
 
-	return &ForNumStat{lineOfFor, lineOfDo, varName, initExp, limitExp, stepExp, block}
+	return &ForNumStat{LineOfFor: lineOfFor, LineOfDo: lineOfDo, VarName: varName, InitExp: initExp, LimitExp: limitExp, StepExp: stepExp, Block: block}
 }
 
 func _finishForInStat(lexer *Lexer, name0 string) *ForInStat {
@@ -180,7 +181,7 @@ func _finishForInStat(lexer *Lexer, name0 string) *ForInStat {
 	block := parseBlock(lexer)                        // block
 	lexer.NextTokenOfKind(TOKEN_KW_END)               // `end`
 
-	return &ForInStat{lineOfDo, nameList, explist, block}
+	return &ForInStat{LineOfDo: lineOfDo, NameList: nameList, ExpList: explist, Block: block}
 }
 
 func _finishNameList(lexer *Lexer, name0 string) []string {
@@ -210,7 +211,7 @@ func _finishLocalFuncDefStat(lexer *Lexer) *LocalFuncDefStat {
 	_, name := lexer.NextIdentifier()        // Name
 	fdExp := parseFuncDefExp(lexer)          // funcbody
 
-	return &LocalFuncDefStat{name, fdExp}
+	return &LocalFuncDefStat{Name: name, Exp: fdExp}
 }
 
 func _finishLocalVarDeclStat(lexer *Lexer) *LocalVarDeclStat {
@@ -224,7 +225,7 @@ func _finishLocalVarDeclStat(lexer *Lexer) *LocalVarDeclStat {
 	}
 
 	lastLine := lexer.Line()
-	return &LocalVarDeclStat{lastLine, nameList, expList}
+	return &LocalVarDeclStat{LastLine: lastLine, NameList: nameList, ExpList: expList}
 }
 
 /* function call and variable assignment */
@@ -244,7 +245,7 @@ func parseAssignStat(lexer *Lexer, var0 Exp) *AssignStat {
 	expList := parseExpList(lexer)         // explist
 	lastLine := lexer.Line()
 
-	return &AssignStat{lastLine, varList, expList}
+	return &AssignStat{LastLine: lastLine, VarList: varList, ExpList: expList}
 }
 
 // Parse a varlist(slice) starting with given var0.
@@ -293,20 +294,20 @@ func parseFuncDefStat(lexer *Lexer) *AssignStat {
 
 func _parseFuncName(lexer *Lexer) (exp Exp, hasColon bool) {
 	line, name := lexer.NextIdentifier()
-	exp = &NameExp{line, name}
+	exp = &NameExp{Line: line, Name: name}
 
 	for lexer.LookAhead() == TOKEN_SEP_DOT {
 		lexer.NextToken() // `.`
 		line, name := lexer.NextIdentifier()
-		idx := &StringExp{line, name}
-		exp = &TableAccessExp{line, exp, idx}
+		idx := &StringExp{Line: line, Str: name}
+		exp = &TableAccessExp{LastLine: line, PrefixExp: exp, KeyExp: idx}
 	}
 
 	if lexer.LookAhead() == TOKEN_SEP_COLON {
 		lexer.NextToken() // `:`
 		line, name := lexer.NextIdentifier()
-		idx := &StringExp{line, name}
-		exp = &TableAccessExp{line, exp, idx}
+		idx := &StringExp{Line: line, Str: name}
+		exp = &TableAccessExp{LastLine: line, PrefixExp: exp, KeyExp: idx}
 		hasColon = true
 	}
 
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gonearewe/lua-compiler/coverage"
+)
+
+// Merge the lcov reports of several runs into one report.
+func coverCmd(args []string) {
+	fs := flag.NewFlagSet("cover", flag.ExitOnError)
+	output := fs.String("o", "", "output to `file` instead of stdout, as Cobertura XML if it ends with .xml")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s cover [options] report...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	c := coverage.New()
+	for _, name := range fs.Args() {
+		f, err := os.Open(name)
+		if err != nil {
+			fatal("%v", err)
+		}
+		report, err := coverage.ReadLcov(f)
+		f.Close()
+		if err != nil {
+			fatal("%s: %v", name, err)
+		}
+		c.Merge(report)
+	}
+
+	if *output == "" {
+		if err := c.WriteLcov(os.Stdout); err != nil {
+			fatal("%v", err)
+		}
+	} else {
+		writeCoverage(c, *output)
+	}
+}
+
+// Write the coverage into the named file in the format told by its extension.
+func writeCoverage(c *coverage.Coverage, name string) {
+	if strings.HasSuffix(name, ".xml") {
+		writeReport(name, c.WriteCobertura)
+	} else {
+		writeReport(name, c.WriteLcov)
+	}
+}
@@ -0,0 +1,106 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+/*
+Cobertura XML reports, see coverage-04.dtd of Cobertura. Files are classes
+grouped into packages by their directories, there are no methods nor branches.
+*/
+
+const coberturaDoctype = `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`
+
+type coberturaReport struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        float64            `xml:"line-rate,attr"`
+	BranchRate      float64            `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      float64            `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   float64          `xml:"line-rate,attr"`
+	BranchRate float64          `xml:"branch-rate,attr"`
+	Complexity float64          `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+	covered    int
+	valid      int
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   float64         `xml:"line-rate,attr"`
+	BranchRate float64         `xml:"branch-rate,attr"`
+	Complexity float64         `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int   `xml:"number,attr"`
+	Hits   int64 `xml:"hits,attr"`
+}
+
+func rate(covered, valid int) float64 {
+	if valid == 0 {
+		return 1
+	}
+	return float64(covered) / float64(valid)
+}
+
+// WriteCobertura writes the coverage as a Cobertura XML report.
+func (c *Coverage) WriteCobertura(w io.Writer) error {
+	report := coberturaReport{Timestamp: time.Now().UnixNano() / int64(time.Millisecond), Sources: []string{"."}}
+	packages := map[string]*coberturaPackage{}
+	for _, f := range c.Files() {
+		dir := filepath.Dir(f.Name)
+		pkg, found := packages[dir]
+		if !found {
+			pkg = &coberturaPackage{Name: dir}
+			packages[dir] = pkg
+		}
+
+		lines, hit := f.sortedLines()
+		class := coberturaClass{Name: f.Name, Filename: f.Name, LineRate: rate(hit, len(lines))}
+		for _, line := range lines {
+			class.Lines = append(class.Lines, coberturaLine{line, f.Lines[line]})
+		}
+		pkg.Classes = append(pkg.Classes, class)
+		pkg.covered += hit
+		pkg.valid += len(lines)
+	}
+
+	for _, pkg := range packages {
+		pkg.LineRate = rate(pkg.covered, pkg.valid)
+		report.Packages = append(report.Packages, *pkg)
+		report.LinesCovered += pkg.covered
+		report.LinesValid += pkg.valid
+	}
+	sort.Slice(report.Packages, func(i, j int) bool { return report.Packages[i].Name < report.Packages[j].Name })
+	report.LineRate = rate(report.LinesCovered, report.LinesValid)
+
+	if _, err := io.WriteString(w, xml.Header+coberturaDoctype+"\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,154 @@
+/*
+Package coverage counts how many times the lines of lua scripts run, for
+finding out which code the tests never reach. A lua state records into a
+Coverage set by SetCoverage(), every chunk loaded from a file afterwards is
+covered: its executable lines are known statically from the line info of its
+prototypes, and the VM counts the instructions executed by them.
+
+The counts are reported in the lcov tracefile format or as Cobertura XML,
+which CI services understand. Coverages of several lua states, or lcov
+reports of several runs, can be merged into one report.
+*/
+package coverage
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gonearewe/lua-compiler/binchunk"
+)
+
+// Coverage records the counts of lines by source file. It's not safe for
+// lua states running concurrently, give each its own Coverage and Merge()
+// them afterwards instead. The coroutines of a lua state may share one.
+type Coverage struct {
+	files    map[string]map[int]int64 // counts of the executable lines, besides the counters
+	counters map[*binchunk.Prototype][]int64
+	names    map[*binchunk.Prototype]string // file of each prototype with counters
+}
+
+// File is the coverage of a source file.
+type File struct {
+	Name  string
+	Lines map[int]int64 // how many times each executable line runs
+}
+
+func New() *Coverage {
+	return &Coverage{
+		files:    map[string]map[int]int64{},
+		counters: map[*binchunk.Prototype][]int64{},
+		names:    map[*binchunk.Prototype]string{},
+	}
+}
+
+// AddChunk starts covering the main function of a chunk that's just loaded.
+// Only chunks named after their files like "@test.lua" are covered, so are
+// the functions defined in them.
+func (c *Coverage) AddChunk(proto *binchunk.Prototype) {
+	if !strings.HasPrefix(proto.Source, "@") {
+		return
+	}
+
+	name := proto.Source[1:]
+	lines := c.file(name)
+	var add func(p *binchunk.Prototype)
+	add = func(p *binchunk.Prototype) {
+		if _, found := c.counters[p]; found { // the same chunk is loaded again
+			return
+		}
+		for _, line := range p.LineInfo {
+			if _, found := lines[int(line)]; !found {
+				lines[int(line)] = 0
+			}
+		}
+		c.counters[p] = make([]int64, len(p.Code))
+		c.names[p] = name
+		for _, sub := range p.Protos {
+			add(sub)
+		}
+	}
+	add(proto)
+}
+
+// Counters returns how many times each instruction of the function runs,
+// which the VM increases. It's nil if the function isn't covered.
+func (c *Coverage) Counters(proto *binchunk.Prototype) []int64 {
+	return c.counters[proto]
+}
+
+func (c *Coverage) file(name string) map[int]int64 {
+	lines, found := c.files[name]
+	if !found {
+		lines = map[int]int64{}
+		c.files[name] = lines
+	}
+	return lines
+}
+
+// Files returns the coverage of every file sorted by name. A line runs as
+// many times as the most executed instruction of it in each function.
+func (c *Coverage) Files() []*File {
+	files := map[string]*File{}
+	get := func(name string) *File {
+		f, found := files[name]
+		if !found {
+			f = &File{Name: name, Lines: map[int]int64{}}
+			files[name] = f
+		}
+		return f
+	}
+
+	for name, lines := range c.files {
+		f := get(name)
+		for line, n := range lines {
+			f.Lines[line] += n
+		}
+	}
+	for proto, counters := range c.counters {
+		f := get(c.names[proto])
+		maxes := map[int]int64{}
+		for pc, n := range counters {
+			if pc < len(proto.LineInfo) {
+				if line := int(proto.LineInfo[pc]); n > maxes[line] {
+					maxes[line] = n
+				}
+			}
+		}
+		for line, n := range maxes {
+			f.Lines[line] += n
+		}
+	}
+
+	list := make([]*File, 0, len(files))
+	for _, f := range files {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Merge adds the counts of other into c, other is left unchanged.
+func (c *Coverage) Merge(other *Coverage) {
+	for _, f := range other.Files() {
+		c.add(f)
+	}
+}
+
+func (c *Coverage) add(f *File) {
+	lines := c.file(f.Name)
+	for line, n := range f.Lines {
+		lines[line] += n
+	}
+}
+
+// Return the executable lines of the file sorted, and how many of them run.
+func (f *File) sortedLines() (lines []int, hit int) {
+	for line, n := range f.Lines {
+		lines = append(lines, line)
+		if n > 0 {
+			hit++
+		}
+	}
+	sort.Ints(lines)
+	return lines, hit
+}
@@ -0,0 +1,73 @@
+package coverage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+The lcov tracefile format, as written by geninfo. Each file is a record:
+
+	SF:<file name>
+	DA:<line>,<count>
+	LF:<number of executable lines>
+	LH:<number of lines that run>
+	end_of_record
+*/
+
+// WriteLcov writes the coverage in the lcov tracefile format.
+func (c *Coverage) WriteLcov(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range c.Files() {
+		lines, hit := f.sortedLines()
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", f.Name)
+		for _, line := range lines {
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, f.Lines[line])
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit)
+	}
+	return bw.Flush()
+}
+
+// ReadLcov reads the line counts of an lcov tracefile, what else it records
+// like functions and branches is ignored.
+func ReadLcov(r io.Reader) (*Coverage, error) {
+	c := New()
+	var f *File
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			f = &File{Name: line[3:], Lines: map[int]int64{}}
+		case line == "end_of_record":
+			if f != nil {
+				c.add(f)
+				f = nil
+			}
+		case strings.HasPrefix(line, "DA:"):
+			// the count may be followed by a checksum
+			fields := strings.Split(line[3:], ",")
+			if f == nil || len(fields) < 2 {
+				return nil, fmt.Errorf("lcov:%d: bad line data %q", n, line)
+			}
+			lineNo, err1 := strconv.Atoi(fields[0])
+			count, err2 := strconv.ParseInt(fields[1], 10, 64)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("lcov:%d: bad line data %q", n, line)
+			}
+			f.Lines[lineNo] += count
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if f != nil {
+		return nil, fmt.Errorf("lcov: missing end_of_record for %s", f.Name)
+	}
+
+	return c, nil
+}
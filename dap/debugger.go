@@ -0,0 +1,553 @@
+package dap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gonearewe/lua-compiler/api"
+)
+
+// how the script runs until it stops again
+const (
+	running  = iota
+	stepIn   // stop at the next line
+	stepOver // stop at the next line of the function or of its callers
+	stepOut  // stop at the next line of its callers
+)
+
+// kinds of variable references
+const (
+	localsRef = iota
+	upvaluesRef
+	tableRef
+)
+
+var (
+	errNotPaused = errors.New("the script is not stopped")
+	errBadFrame  = errors.New("invalid frame id")
+)
+
+// key in the registry of the table keeping the tables referenced
+// by the client while the script is stopped
+const refsKey = "dap.refs"
+
+// what the client can expand, level is the level of the function for locals
+// and upvalues, or the index in the refs table of the registry for tables
+type varRef struct {
+	kind  int
+	level int
+}
+
+// work to do on the goroutine of the stopped script
+type job struct {
+	f      func(ls api.LuaState)
+	resume bool // whether the script goes on after it
+	done   chan struct{}
+}
+
+// The debugger drives the script through the line hook, which blocks the
+// goroutine of the script while it's stopped and runs the jobs sent by the
+// session then, since lua states must only be touched by their goroutine.
+type debugger struct {
+	c    *conn
+	jobs chan job
+
+	mu          sync.Mutex // guards the fields below
+	breakpoints map[string]map[int]bool
+	pause       bool // stop at the next line
+	paused      bool
+	terminated  bool
+	mode        int
+	reason      string       // reason of stopping when stepping
+	stepThread  api.LuaState // thread where stepping starts
+	stepDepth   int
+
+	// used by the goroutine of the script only
+	paths map[string]string // absolute paths of chunk names
+	refs  []varRef          // variable references handed out while stopped, numbered from 1
+}
+
+func newDebugger(c *conn) *debugger {
+	return &debugger{
+		c:           c,
+		jobs:        make(chan job),
+		breakpoints: map[string]map[int]bool{},
+		paths:       map[string]string{},
+	}
+}
+
+// The line hook of the script.
+func (d *debugger) hook(ls api.LuaState, ar *api.Debug) {
+	if reason := d.stopReason(ls, ar.CurrentLine); reason != "" {
+		d.stop(ls, reason)
+	}
+}
+
+// Return why the script should stop at the line, "" if it shouldn't.
+func (d *debugger) stopReason(ls api.LuaState, line int) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case d.terminated:
+		return ""
+	case d.pause:
+		d.pause = false
+		return "pause"
+	case d.mode == stepIn:
+		return d.reason
+	case d.mode == stepOver && ls == d.stepThread && depth(ls) <= d.stepDepth:
+		return d.reason
+	case d.mode == stepOut && ls == d.stepThread && depth(ls) < d.stepDepth:
+		return d.reason
+	}
+
+	if len(d.breakpoints) > 0 {
+		var ar api.Debug
+		ls.GetInfo(0, "S", &ar)
+		if d.breakpoints[d.path(ar.Source)][line] {
+			return "breakpoint"
+		}
+	}
+
+	return ""
+}
+
+// Return the number of levels in the call stack.
+func depth(ls api.LuaState) int {
+	var ar api.Debug
+	n := 0
+	for ls.GetInfo(n, "", &ar) {
+		n++
+	}
+
+	return n
+}
+
+// Return the absolute path of the chunk, "" if it isn't loaded from a file.
+func (d *debugger) path(chunkName string) string {
+	if !strings.HasPrefix(chunkName, "@") {
+		return ""
+	}
+
+	path, ok := d.paths[chunkName]
+	if !ok {
+		path = absPath(chunkName[1:])
+		d.paths[chunkName] = path
+	}
+	return path
+}
+
+func absPath(name string) string {
+	if path, err := filepath.Abs(name); err == nil {
+		return path
+	}
+	return filepath.Clean(name)
+}
+
+// Tell the client that the script stops and run its jobs until it goes on.
+func (d *debugger) stop(ls api.LuaState, reason string) {
+	d.mu.Lock()
+	if d.terminated { // terminate() doesn't wait for it then
+		d.mu.Unlock()
+		return
+	}
+	d.paused, d.mode = true, running
+	d.mu.Unlock()
+
+	d.c.event("stopped", map[string]interface{}{
+		"reason":            reason,
+		"threadId":          1,
+		"allThreadsStopped": true,
+	})
+	for j := range d.jobs {
+		if j.f != nil {
+			j.f(ls)
+		}
+		if j.resume {
+			d.refs = nil
+			ls.PushNil()
+			ls.SetField(api.LUA_REGISTRYINDEX, refsKey)
+		}
+		close(j.done)
+		if j.resume {
+			return
+		}
+	}
+}
+
+// Run f on the goroutine of the stopped script and wait for it,
+// then the script goes on if resume is set.
+func (d *debugger) do(f func(ls api.LuaState), resume bool) error {
+	d.mu.Lock()
+	paused := d.paused
+	if resume {
+		d.paused = false
+	}
+	d.mu.Unlock()
+
+	if !paused {
+		return errNotPaused
+	}
+	done := make(chan struct{})
+	d.jobs <- job{f, resume, done}
+	<-done
+	return nil
+}
+
+func (d *debugger) isPaused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// Let the stopped script go on in given mode.
+func (d *debugger) resume(mode int) error {
+	return d.do(func(ls api.LuaState) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.mode, d.reason = mode, "step"
+		d.stepThread, d.stepDepth = ls, depth(ls)
+	}, true)
+}
+
+// Stop the script at its first line.
+func (d *debugger) stopOnEntry() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mode, d.reason = stepIn, "entry"
+}
+
+func (d *debugger) requestPause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pause = true
+}
+
+// Let the script run to its end without stopping, and abort it through cancel.
+func (d *debugger) terminate(cancel context.CancelFunc) {
+	d.mu.Lock()
+	d.terminated = true
+	d.mu.Unlock()
+
+	cancel()
+	d.do(nil, true) // wake it up if it's stopped
+}
+
+// Replace the breakpoints of the file, return them with the lines moved to
+// where the code is.
+func (d *debugger) setBreakpoints(path string, lines []int) []breakpoint {
+	path = absPath(path)
+	code := codeLines(path)
+
+	bps := make([]breakpoint, len(lines))
+	set := map[int]bool{}
+	for i, line := range lines {
+		bps[i] = breakpoint{Verified: true, Line: line}
+		if code != nil {
+			if next := nextCodeLine(code, line); next > 0 {
+				bps[i].Line = next
+			} else {
+				bps[i] = breakpoint{Line: line, Message: "no code at or after this line"}
+				continue
+			}
+		}
+		set[bps[i].Line] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(set) == 0 {
+		delete(d.breakpoints, path)
+	} else {
+		d.breakpoints[path] = set
+	}
+	return bps
+}
+
+func nextCodeLine(code map[int]bool, line int) int {
+	next := 0
+	for l := range code {
+		if l >= line && (next == 0 || l < next) {
+			next = l
+		}
+	}
+
+	return next
+}
+
+/* inspecting the stopped script, called by jobs */
+
+func (d *debugger) stackTrace(ls api.LuaState, start, levels int) ([]stackFrame, int) {
+	total := depth(ls)
+	frames := []stackFrame{}
+	for level := start; level < total && (levels <= 0 || level < start+levels); level++ {
+		var ar api.Debug
+		ls.GetInfo(level, "nSl", &ar)
+		frame := stackFrame{ID: level + 1, Name: frameName(&ar), Column: 1}
+		if ar.CurrentLine > 0 {
+			frame.Line = ar.CurrentLine
+		}
+		if path := d.path(ar.Source); path != "" {
+			frame.Source = &source{Name: filepath.Base(path), Path: path}
+		} else if ar.What != "C" {
+			frame.Source = &source{Name: ar.ShortSrc}
+		}
+		frames = append(frames, frame)
+	}
+
+	return frames, total
+}
+
+func frameName(ar *api.Debug) string {
+	switch {
+	case ar.NameWhat != "":
+		return ar.Name
+	case ar.What == "main":
+		return "main chunk"
+	case ar.What == "C":
+		return "?"
+	default:
+		return fmt.Sprintf("function <%s:%d>", ar.ShortSrc, ar.LineDefined)
+	}
+}
+
+func (d *debugger) scopes(ls api.LuaState, level int) ([]scope, error) {
+	if !hasFrame(ls, level) {
+		return nil, errBadFrame
+	}
+
+	ls.PushGlobalTable()
+	return []scope{
+		{Name: "Locals", VariablesReference: d.newRef(varRef{localsRef, level})},
+		{Name: "Upvalues", VariablesReference: d.newRef(varRef{upvaluesRef, level})},
+		{Name: "Globals", VariablesReference: d.newTableRef(ls), Expensive: true},
+	}, nil
+}
+
+// Whether there's a function running at given level.
+func hasFrame(ls api.LuaState, level int) bool {
+	var ar api.Debug
+	return level >= 0 && ls.GetInfo(level, "", &ar)
+}
+
+func (d *debugger) newRef(ref varRef) int {
+	d.refs = append(d.refs, ref)
+	return len(d.refs)
+}
+
+// Pop the table and return a reference to it.
+func (d *debugger) newTableRef(ls api.LuaState) int {
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, refsKey)
+	n := len(d.refs) + 1
+	ls.Rotate(-2, 1) // table above refs table
+	ls.RawSetI(-2, int64(n))
+	ls.Pop(1)
+	return d.newRef(varRef{tableRef, n})
+}
+
+// Return the variables that the reference expands to.
+func (d *debugger) variables(ls api.LuaState, id int) []variable {
+	vars := []variable{}
+	if id < 1 || id > len(d.refs) {
+		return vars
+	}
+
+	switch ref := d.refs[id-1]; ref.kind {
+	case localsRef:
+		for n := 1; ; n++ {
+			name := ls.GetLocal(ref.level, n)
+			if name == "" {
+				break
+			}
+			if strings.HasPrefix(name, "(") { // temporaries
+				ls.Pop(1)
+				continue
+			}
+			vars = append(vars, d.newVariable(ls, name))
+		}
+	case upvaluesRef:
+		var ar api.Debug
+		if !ls.GetInfo(ref.level, "f", &ar) {
+			break
+		}
+		for n := 1; ; n++ {
+			name, ok := ls.GetUpvalue(-1, n)
+			if !ok {
+				break
+			}
+			if name == "" {
+				name = "?"
+			}
+			vars = append(vars, d.newVariable(ls, name))
+		}
+		ls.Pop(1)
+	case tableRef:
+		ls.GetSubTable(api.LUA_REGISTRYINDEX, refsKey)
+		ls.RawGetI(-1, int64(ref.level))
+		ls.PushNil()
+		for ls.Next(-2) {
+			name := valueString(ls, -2)
+			switch ls.Type(-2) {
+			case api.LUA_TSTRING:
+				name = ls.ToString(-2) // strings are never converted
+			case api.LUA_TNUMBER:
+				name = "[" + name + "]"
+			}
+			vars = append(vars, d.newVariable(ls, name))
+		}
+		ls.Pop(2)
+		sortVariables(vars)
+	}
+
+	return vars
+}
+
+// Pop the value and return it as the variable of given name.
+func (d *debugger) newVariable(ls api.LuaState, name string) variable {
+	v := variable{Name: name, Value: valueString(ls, -1), Type: ls.TypeName(ls.Type(-1))}
+	if ls.IsTable(-1) {
+		v.VariablesReference = d.newTableRef(ls)
+	} else {
+		ls.Pop(1)
+	}
+
+	return v
+}
+
+// Array items in order, then fields by name.
+func sortVariables(vars []variable) {
+	index := func(v variable) (int, bool) {
+		if strings.HasPrefix(v.Name, "[") {
+			i, err := strconv.Atoi(v.Name[1 : len(v.Name)-1])
+			return i, err == nil
+		}
+		return 0, false
+	}
+
+	sort.SliceStable(vars, func(i, j int) bool {
+		a, aok := index(vars[i])
+		b, bok := index(vars[j])
+		if aok != bok {
+			return aok
+		}
+		if aok {
+			return a < b
+		}
+		return vars[i].Name < vars[j].Name
+	})
+}
+
+// Return the value at idx as the client shows it,
+// metamethods are not called to keep the script untouched.
+func valueString(ls api.LuaState, idx int) string {
+	switch ls.Type(idx) {
+	case api.LUA_TNIL:
+		return "nil"
+	case api.LUA_TBOOLEAN:
+		return strconv.FormatBool(ls.ToBoolean(idx))
+	case api.LUA_TNUMBER:
+		ls.PushValue(idx) // ToString() converts the number in place
+		s := ls.ToString(-1)
+		ls.Pop(1)
+		return s
+	case api.LUA_TSTRING:
+		return strconv.Quote(ls.ToString(idx))
+	default:
+		return fmt.Sprintf("%s: %p", ls.TypeName(ls.Type(idx)), ls.ToPointer(idx))
+	}
+}
+
+// Evaluate the expression, or run the statement, with the variables visible to
+// the function at given level, return the results as a variable named result.
+func (d *debugger) evaluate(ls api.LuaState, expr string, level int) (variable, error) {
+	if !hasFrame(ls, level) {
+		return variable{}, errBadFrame
+	}
+	if ls.Load([]byte("return "+expr), "=(eval)", "t") != api.LUA_OK {
+		ls.Pop(1)
+		if ls.Load([]byte(expr), "=(eval)", "t") != api.LUA_OK {
+			msg := ls.ToString(-1)
+			ls.Pop(1)
+			return variable{}, errors.New(msg)
+		}
+	}
+
+	if !d.pushEnv(ls, level) {
+		ls.Pop(1)
+		return variable{}, errBadFrame
+	}
+	if _, ok := ls.SetUpvalue(-2, 1); !ok { // no _ENV
+		ls.Pop(1)
+	}
+	base := ls.GetTop() - 1
+	if ls.PCall(0, api.LUA_MULTRET, 0) != api.LUA_OK {
+		msg := ls.ToStringMeta(-1)
+		ls.Pop(2)
+		return variable{}, errors.New(msg)
+	}
+
+	n := ls.GetTop() - base
+	if n == 1 {
+		return d.newVariable(ls, "result"), nil
+	}
+	values := make([]string, n)
+	for i := range values {
+		values[i] = valueString(ls, base+1+i)
+	}
+	ls.SetTop(base)
+	return variable{Name: "result", Value: strings.Join(values, ", ")}, nil
+}
+
+// Push a table with the locals and upvalues of the function at given level,
+// whose other fields are looked up in the _ENV of the function, or the global
+// table if it has no _ENV. It's a copy, assignments don't change the variables.
+// Nothing is pushed and false is returned if there's no function at the level.
+func (d *debugger) pushEnv(ls api.LuaState, level int) bool {
+	var ar api.Debug
+	if !ls.GetInfo(level, "f", &ar) {
+		return false
+	}
+	ls.NewTable() // env
+	ls.NewTable() // metatable
+	ls.PushGlobalTable()
+	ls.SetField(-2, "__index")
+	ls.Rotate(-3, -1) // function above the tables
+
+	for n := 1; ; n++ {
+		name, ok := ls.GetUpvalue(-1, n)
+		if !ok {
+			break
+		}
+		switch name {
+		case "":
+			ls.Pop(1)
+		case "_ENV":
+			ls.SetField(-3, "__index")
+		default:
+			ls.SetField(-4, name)
+		}
+	}
+	ls.Pop(1) // function
+	ls.SetMetatable(-2)
+
+	// locals shadow upvalues and the ones declared earlier
+	for n := 1; ; n++ {
+		name := ls.GetLocal(level, n)
+		if name == "" {
+			break
+		}
+		if strings.HasPrefix(name, "(") { // temporaries
+			ls.Pop(1)
+			continue
+		}
+		ls.SetField(-2, name)
+	}
+	return true
+}
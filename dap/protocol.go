@@ -0,0 +1,193 @@
+/*
+Messages of the Debug Adapter Protocol and how they are framed: every message
+is a JSON object preceded by a "Content-Length" header and an empty line.
+Only the fields used by this package are declared.
+*/
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+/* arguments of requests */
+
+type launchArgs struct {
+	Program     string   `json:"program"`
+	Args        []string `json:"args"`
+	StopOnEntry bool     `json:"stopOnEntry"`
+	NoDebug     bool     `json:"noDebug"`
+}
+
+type setBreakpointsArgs struct {
+	Source      source `json:"source"`
+	Breakpoints []struct {
+		Line int `json:"line"`
+	} `json:"breakpoints"`
+}
+
+type stackTraceArgs struct {
+	StartFrame int `json:"startFrame"`
+	Levels     int `json:"levels"`
+}
+
+type scopesArgs struct {
+	FrameID int `json:"frameId"`
+}
+
+type variablesArgs struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+type evaluateArgs struct {
+	Expression string `json:"expression"`
+	FrameID    int    `json:"frameId"`
+}
+
+/* types in bodies */
+
+type capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+}
+
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type breakpoint struct {
+	Verified bool   `json:"verified"`
+	Line     int    `json:"line"`
+	Message  string `json:"message,omitempty"`
+}
+
+type thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type stackFrame struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Source *source `json:"source,omitempty"`
+	Line   int     `json:"line"`
+	Column int     `json:"column"`
+}
+
+type scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+type variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// connection to the client, messages can be sent from any goroutine
+type conn struct {
+	r   *bufio.Reader
+	w   io.Writer
+	mu  sync.Mutex // guards w and seq
+	seq int
+}
+
+func newConn(rw io.ReadWriter) *conn {
+	return &conn{r: bufio.NewReader(rw), w: rw}
+}
+
+// Read the next request from the client.
+func (c *conn) read() (*request, error) {
+	length := -1
+	for { // headers end with an empty line
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" && length >= 0 {
+			break
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+				return nil, fmt.Errorf("bad header %q", line)
+			}
+		}
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+	req := &request{}
+	if err := json.Unmarshal(data, req); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Send the response to the request, which fails if err isn't nil.
+func (c *conn) respond(req *request, body interface{}, err error) {
+	resp := &response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: err == nil}
+	if err != nil {
+		resp.Message = err.Error()
+	} else {
+		resp.Body = body
+	}
+
+	c.send(&resp.Seq, resp)
+}
+
+func (c *conn) event(name string, body interface{}) {
+	e := &event{Type: "event", Event: name, Body: body}
+	c.send(&e.Seq, e)
+}
+
+// Send the message after numbering it through seq. Errors are ignored,
+// reading from the client reports a broken connection anyway.
+func (c *conn) send(seq *int, msg interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	*seq = c.seq
+	data, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
@@ -0,0 +1,449 @@
+/*
+Package dap is a debugger of lua scripts speaking the Debug Adapter Protocol,
+so that editors can set breakpoints in scripts, step through them and inspect
+their variables. A session launches one script and stops when the client
+disconnects; all coroutines of the script are shown as one thread.
+*/
+package dap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/binchunk"
+	"github.com/gonearewe/lua-compiler/compiler/codegen"
+	"github.com/gonearewe/lua-compiler/compiler/parser"
+	"github.com/gonearewe/lua-compiler/state"
+	"github.com/gonearewe/lua-compiler/stdlib"
+)
+
+// Serve speaks DAP with the client over rw, like the stdin and stdout of the
+// process, until the client disconnects. The script is aborted then.
+func Serve(rw io.ReadWriter) error {
+	s := &session{c: newConn(rw)}
+	s.d = newDebugger(s.c)
+	return s.serve()
+}
+
+// ListenAndServe accepts clients on the TCP address and serves them one after
+// another. There is no authentication, so the host must be a loopback one like
+// localhost, and it is 127.0.0.1 if the address has none like ":4711".
+func ListenAndServe(addr string) error {
+	addr, err := loopbackAddr(addr)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		Serve(conn)
+		conn.Close()
+	}
+}
+
+// Resolve the host of the address, which must only have loopback IPs, and
+// return the address with the first one.
+func loopbackAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return "", fmt.Errorf("listen on %s: %s is not a loopback address", addr, ip)
+		}
+	}
+	return net.JoinHostPort(ips[0].String(), port), nil
+}
+
+type session struct {
+	c          *conn
+	d          *debugger
+	launch     *launchArgs // nil until the launch request
+	configured bool        // whether the configurationDone request is received
+	cancel     context.CancelFunc
+	done       chan struct{} // closed when the script ends, nil if not started
+	after      func()        // what to do after responding to the current request
+}
+
+var handlers = map[string]func(s *session, args json.RawMessage) (interface{}, error){
+	"initialize":        (*session).initialize,
+	"launch":            (*session).launchReq,
+	"setBreakpoints":    (*session).setBreakpoints,
+	"configurationDone": (*session).configurationDone,
+	"threads":           (*session).threads,
+	"stackTrace":        (*session).stackTrace,
+	"scopes":            (*session).scopes,
+	"variables":         (*session).variables,
+	"evaluate":          (*session).evaluate,
+	"continue":          stepper(running),
+	"next":              stepper(stepOver),
+	"stepIn":            stepper(stepIn),
+	"stepOut":           stepper(stepOut),
+	"pause":             (*session).pause,
+	"disconnect":        (*session).disconnect,
+}
+
+func (s *session) serve() error {
+	defer s.end()
+
+	for {
+		req, err := s.c.read()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		h, found := handlers[req.Command]
+		if !found {
+			s.c.respond(req, nil, fmt.Errorf("unsupported request '%s'", req.Command))
+			continue
+		}
+		body, err := h(s, req.Arguments)
+		s.c.respond(req, body, err)
+		if s.after != nil {
+			s.after()
+			s.after = nil
+		}
+
+		switch req.Command {
+		case "initialize":
+			s.c.event("initialized", nil)
+		case "launch", "configurationDone":
+			if s.launch != nil && s.configured && s.done == nil {
+				s.start()
+			}
+		case "disconnect":
+			return nil
+		}
+	}
+}
+
+// Abort the script if it's running and wait for it.
+func (s *session) end() {
+	if s.done != nil {
+		s.d.terminate(s.cancel)
+		<-s.done
+	}
+}
+
+// Decode the arguments of a request into v.
+func decode(args json.RawMessage, v interface{}) error {
+	if len(args) == 0 {
+		return nil
+	}
+	return json.Unmarshal(args, v)
+}
+
+func (s *session) initialize(args json.RawMessage) (interface{}, error) {
+	return capabilities{
+		SupportsConfigurationDoneRequest: true,
+		SupportsEvaluateForHovers:        true,
+	}, nil
+}
+
+func (s *session) launchReq(args json.RawMessage) (interface{}, error) {
+	launch := &launchArgs{}
+	if err := decode(args, launch); err != nil {
+		return nil, err
+	}
+	if launch.Program == "" {
+		return nil, fmt.Errorf("no program to launch")
+	}
+	if s.launch != nil {
+		return nil, fmt.Errorf("the program is already launched")
+	}
+
+	s.launch = launch
+	if launch.StopOnEntry {
+		s.d.stopOnEntry()
+	}
+	return nil, nil
+}
+
+func (s *session) setBreakpoints(args json.RawMessage) (interface{}, error) {
+	var a setBreakpointsArgs
+	if err := decode(args, &a); err != nil {
+		return nil, err
+	}
+
+	lines := make([]int, len(a.Breakpoints))
+	for i, bp := range a.Breakpoints {
+		lines[i] = bp.Line
+	}
+	return map[string]interface{}{
+		"breakpoints": s.d.setBreakpoints(a.Source.Path, lines),
+	}, nil
+}
+
+func (s *session) configurationDone(args json.RawMessage) (interface{}, error) {
+	s.configured = true
+	return nil, nil
+}
+
+func (s *session) threads(args json.RawMessage) (interface{}, error) {
+	return map[string]interface{}{
+		"threads": []thread{{ID: 1, Name: "main"}},
+	}, nil
+}
+
+func (s *session) stackTrace(args json.RawMessage) (interface{}, error) {
+	var a stackTraceArgs
+	if err := decode(args, &a); err != nil {
+		return nil, err
+	}
+
+	var frames []stackFrame
+	var total int
+	err := s.d.do(func(ls api.LuaState) {
+		frames, total = s.d.stackTrace(ls, a.StartFrame, a.Levels)
+	}, false)
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": total}, err
+}
+
+func (s *session) scopes(args json.RawMessage) (interface{}, error) {
+	var a scopesArgs
+	if err := decode(args, &a); err != nil {
+		return nil, err
+	}
+
+	var scopes []scope
+	var frameErr error
+	if err := s.d.do(func(ls api.LuaState) {
+		scopes, frameErr = s.d.scopes(ls, a.FrameID-1)
+	}, false); err != nil {
+		return nil, err
+	}
+	if frameErr != nil {
+		return nil, frameErr
+	}
+	return map[string]interface{}{"scopes": scopes}, nil
+}
+
+func (s *session) variables(args json.RawMessage) (interface{}, error) {
+	var a variablesArgs
+	if err := decode(args, &a); err != nil {
+		return nil, err
+	}
+
+	var vars []variable
+	err := s.d.do(func(ls api.LuaState) {
+		vars = s.d.variables(ls, a.VariablesReference)
+	}, false)
+	return map[string]interface{}{"variables": vars}, err
+}
+
+func (s *session) evaluate(args json.RawMessage) (interface{}, error) {
+	var a evaluateArgs
+	if err := decode(args, &a); err != nil {
+		return nil, err
+	}
+
+	var result variable
+	var evalErr error
+	level := 0 // the innermost function without a frame
+	if a.FrameID > 0 {
+		level = a.FrameID - 1
+	}
+	if err := s.d.do(func(ls api.LuaState) {
+		result, evalErr = s.d.evaluate(ls, a.Expression, level)
+	}, false); err != nil {
+		return nil, err
+	}
+	if evalErr != nil {
+		return nil, evalErr
+	}
+
+	return map[string]interface{}{
+		"result":             result.Value,
+		"type":               result.Type,
+		"variablesReference": result.VariablesReference,
+	}, nil
+}
+
+// Return the handler of continue and step requests. The script goes on after
+// the response, otherwise the client may get the stopped event before it.
+func stepper(mode int) func(s *session, args json.RawMessage) (interface{}, error) {
+	return func(s *session, args json.RawMessage) (interface{}, error) {
+		if !s.d.isPaused() {
+			return nil, errNotPaused
+		}
+
+		s.after = func() { s.d.resume(mode) }
+		if mode == running {
+			return map[string]interface{}{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	}
+}
+
+func (s *session) pause(args json.RawMessage) (interface{}, error) {
+	s.d.requestPause()
+	return nil, nil
+}
+
+func (s *session) disconnect(args json.RawMessage) (interface{}, error) {
+	return nil, nil // serve() ends the session
+}
+
+// Run the launched script in a new goroutine.
+func (s *session) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		exitCode := s.run(ctx, s.launch)
+		s.c.event("exited", map[string]interface{}{"exitCode": exitCode})
+		s.c.event("terminated", nil)
+	}()
+}
+
+// Run the script like the run command does, return the exit code.
+func (s *session) run(ctx context.Context, launch *launchArgs) int {
+	ls := state.New()
+	defer ls.Close()
+	// the output goes to the client, stdin and stdout may be the connection
+	ls.RequireF("io", stdlib.NewIOLib(stdlib.SystemFS(), strings.NewReader(""),
+		outputWriter{s, "stdout"}, outputWriter{s, "stderr"}), false)
+	// os.exit ends the script but not the server
+	exited, exitCode := false, 0
+	ls.RequireF("os", stdlib.NewOSLib(stdlib.SystemClock(), stdlib.SystemEnv(), stdlib.SystemFS(), func(code int) {
+		exited, exitCode = true, code
+	}), false)
+	ls.Pop(2)
+	stdlib.OpenLibs(ls)
+	ls.Register("print", s.print)
+	ls.SetContext(ctx)
+	if !launch.NoDebug {
+		ls.SetHook(s.d.hook, api.LUA_MASKLINE, 0)
+	}
+
+	ls.CreateTable(len(launch.Args), 1)
+	ls.PushString(launch.Program)
+	ls.SetI(-2, 0)
+	for i, a := range launch.Args {
+		ls.PushString(a)
+		ls.SetI(-2, int64(i+1))
+	}
+	ls.SetGlobal("arg")
+
+	ls.PushGoFunction(msgHandler)
+	if ls.LoadFile(launch.Program) != api.LUA_OK {
+		s.output("stderr", ls.ToString(-1)+"\n")
+		return 1
+	}
+	for _, a := range launch.Args {
+		ls.PushString(a)
+	}
+	if ls.PCall(len(launch.Args), 0, 1) != api.LUA_OK {
+		if exited {
+			return exitCode
+		}
+		s.output("stderr", ls.ToString(-1)+"\n")
+		return 1
+	}
+
+	return 0
+}
+
+// Message handler of the script, which adds the traceback to the error message.
+func msgHandler(ls api.LuaState) int {
+	msg, ok := ls.ToStringX(1)
+	if !ok {
+		msg = fmt.Sprintf("(error object is a %s value)", ls.TypeName(ls.Type(1)))
+	}
+
+	ls.Traceback(ls, msg, 1)
+	return 1
+}
+
+func (s *session) output(category, text string) {
+	s.c.event("output", map[string]interface{}{"category": category, "output": text})
+}
+
+// writer of the io library sending what's written to the client
+type outputWriter struct {
+	s        *session
+	category string
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	w.s.output(w.category, string(p))
+	return len(p), nil
+}
+
+// print of the script, which sends the output to the client.
+func (s *session) print(ls api.LuaState) int {
+	n := ls.GetTop()
+	strs := make([]string, n)
+	ls.GetGlobal("tostring")
+	for i := 1; i <= n; i++ {
+		ls.PushValue(-1) // function to be called
+		ls.PushValue(i)  // value to print
+		ls.Call(1, 1)
+		str, ok := ls.ToStringX(-1)
+		if !ok {
+			return ls.Errorf("'tostring' must return a string to 'print'")
+		}
+		strs[i-1] = str
+		ls.Pop(1) // pop result
+	}
+
+	s.output("stdout", strings.Join(strs, "\t")+"\n")
+	return 0
+}
+
+// Return the lines with code in the lua source file, nil if it can't be compiled.
+func codeLines(path string) (lines map[int]bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || binchunk.IsBinaryChunk(data) {
+		return nil
+	}
+
+	// the parser and the code generator report errors by panicking
+	defer func() {
+		if err := recover(); err != nil {
+			lines = nil
+		}
+	}()
+
+	lines = map[int]bool{}
+	var add func(p *binchunk.Prototype)
+	add = func(p *binchunk.Prototype) {
+		for _, line := range p.LineInfo {
+			lines[int(line)] = true
+		}
+		for _, sub := range p.Protos {
+			add(sub)
+		}
+	}
+	add(codegen.GenProto(parser.Parse(string(data), "@"+path), "@"+path))
+
+	return lines
+}
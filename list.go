@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gonearewe/lua-compiler/binchunk"
+	"github.com/gonearewe/lua-compiler/number"
+	"github.com/gonearewe/lua-compiler/vm"
+)
+
+/**
+Print the bytecode listing of a prototype and all of its
+sub-functions, in the format of `luac -l -l`.
+**/
+
+func list(f *binchunk.Prototype) {
+	printHeader(f)
+	printCode(f)
+	printDetail(f)
+	for _, p := range f.Protos {
+		list(p)
+	}
+}
+
+func printHeader(f *binchunk.Prototype) {
+	funcType := "main"
+	if f.LineDefined > 0 {
+		funcType = "function"
+	}
+
+	varargFlag := ""
+	if f.IsVararg > 0 {
+		varargFlag = "+"
+	}
+
+	fmt.Printf("\n%s <%s:%d,%d> (%d instruction%s)\n",
+		funcType, sourceName(f), f.LineDefined, f.LastLineDefined, len(f.Code), plural(len(f.Code)))
+
+	fmt.Printf("%d%s param%s, %d slot%s, %d upvalue%s, ",
+		f.NumParams, varargFlag, plural(int(f.NumParams)),
+		f.MaxStackSize, plural(int(f.MaxStackSize)),
+		len(f.Upvalues), plural(len(f.Upvalues)))
+
+	fmt.Printf("%d local%s, %d constant%s, %d function%s\n",
+		len(f.LocVars), plural(len(f.LocVars)),
+		len(f.Constants), plural(len(f.Constants)),
+		len(f.Protos), plural(len(f.Protos)))
+}
+
+func printCode(f *binchunk.Prototype) {
+	for pc, c := range f.Code {
+		line := "-"
+		if len(f.LineInfo) > 0 {
+			line = fmt.Sprintf("%d", f.LineInfo[pc])
+		}
+
+		inst := vm.Instruction(c)
+		fmt.Printf("\t%d\t[%s]\t%s \t", pc+1, line, inst.OpName())
+		printOperands(inst)
+		printComment(f, pc, inst)
+		fmt.Println()
+	}
+}
+
+// Print operands of the instruction, constants are shown as negative
+// numbers(-1 for the first one) while registers and upvalues stay unchanged.
+func printOperands(i vm.Instruction) {
+	switch i.OpMode() {
+	case vm.IABC:
+		a, b, c := i.ABC()
+		fmt.Printf("%d", a)
+		if i.BMode() != vm.OpArgN {
+			fmt.Printf(" %d", rk(b))
+		}
+		if i.CMode() != vm.OpArgN {
+			fmt.Printf(" %d", rk(c))
+		}
+	case vm.IABx:
+		a, bx := i.ABx()
+		fmt.Printf("%d", a)
+		if i.BMode() == vm.OpArgK {
+			fmt.Printf(" %d", -1-bx)
+		} else if i.BMode() == vm.OpArgU {
+			fmt.Printf(" %d", bx)
+		}
+	case vm.IAsBx:
+		a, sbx := i.AsBx()
+		fmt.Printf("%d %d", a, sbx)
+	case vm.IAx:
+		fmt.Printf("%d", -1-i.Ax())
+	}
+}
+
+// Print what the operands actually refer to.
+func printComment(f *binchunk.Prototype, pc int, i vm.Instruction) {
+	switch i.Opcode() {
+	case vm.OP_LOADK:
+		_, bx := i.ABx()
+		fmt.Printf("\t; %s", constantToString(f.Constants[bx]))
+	case vm.OP_GETUPVAL, vm.OP_SETUPVAL:
+		_, b, _ := i.ABC()
+		fmt.Printf("\t; %s", upvalName(f, b))
+	case vm.OP_GETTABUP:
+		_, b, c := i.ABC()
+		fmt.Printf("\t; %s", upvalName(f, b))
+		if isK(c) {
+			fmt.Printf(" %s", constantToString(f.Constants[c&0xff]))
+		}
+	case vm.OP_SETTABUP:
+		a, b, c := i.ABC()
+		fmt.Printf("\t; %s", upvalName(f, a))
+		if isK(b) {
+			fmt.Printf(" %s", constantToString(f.Constants[b&0xff]))
+		}
+		if isK(c) {
+			fmt.Printf(" %s", constantToString(f.Constants[c&0xff]))
+		}
+	case vm.OP_GETTABLE, vm.OP_SELF:
+		_, _, c := i.ABC()
+		if isK(c) {
+			fmt.Printf("\t; %s", constantToString(f.Constants[c&0xff]))
+		}
+	case vm.OP_SETTABLE, vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD,
+		vm.OP_POW, vm.OP_DIV, vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR,
+		vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR, vm.OP_EQ, vm.OP_LT, vm.OP_LE:
+		_, b, c := i.ABC()
+		if isK(b) || isK(c) {
+			fmt.Printf("\t; %s %s", rkToString(f, b), rkToString(f, c))
+		}
+	case vm.OP_JMP, vm.OP_FORLOOP, vm.OP_FORPREP, vm.OP_TFORLOOP:
+		_, sbx := i.AsBx()
+		fmt.Printf("\t; to %d", sbx+pc+2)
+	case vm.OP_CLOSURE:
+		_, bx := i.ABx()
+		p := f.Protos[bx]
+		fmt.Printf("\t; function <%s:%d,%d>", sourceName(p), p.LineDefined, p.LastLineDefined)
+	case vm.OP_SETLIST:
+		_, _, c := i.ABC()
+		if c == 0 {
+			c = vm.Instruction(f.Code[pc+1]).Ax()
+		}
+		fmt.Printf("\t; %d", c)
+	case vm.OP_EXTRAARG:
+		fmt.Printf("\t; %s", constantToString(f.Constants[i.Ax()]))
+	}
+}
+
+func printDetail(f *binchunk.Prototype) {
+	fmt.Printf("constants (%d):\n", len(f.Constants))
+	for i, k := range f.Constants {
+		fmt.Printf("\t%d\t%s\n", i+1, constantToString(k))
+	}
+
+	fmt.Printf("locals (%d):\n", len(f.LocVars))
+	for i, locVar := range f.LocVars {
+		fmt.Printf("\t%d\t%s\t%d\t%d\n",
+			i, locVar.VarName, locVar.StartPC+1, locVar.EndPC+1)
+	}
+
+	fmt.Printf("upvalues (%d):\n", len(f.Upvalues))
+	for i, upval := range f.Upvalues {
+		fmt.Printf("\t%d\t%s\t%d\t%d\n",
+			i, upvalName(f, i), upval.Instack, upval.Idx)
+	}
+}
+
+// whether the RK operand refers to a constant
+func isK(rk int) bool {
+	return rk > 0xff
+}
+
+// constants are shown as negative numbers in the listing
+func rk(x int) int {
+	if isK(x) {
+		return -1 - (x & 0xff)
+	}
+	return x
+}
+
+func rkToString(f *binchunk.Prototype, rk int) string {
+	if isK(rk) {
+		return constantToString(f.Constants[rk&0xff])
+	}
+	return "-"
+}
+
+func constantToString(k interface{}) string {
+	switch x := k.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return fmt.Sprintf("%t", x)
+	case float64:
+		return number.FloatToString(x)
+	case int64:
+		return fmt.Sprintf("%d", x)
+	case string:
+		return strconv.Quote(x)
+	default:
+		return "?"
+	}
+}
+
+func upvalName(f *binchunk.Prototype, idx int) string {
+	if len(f.UpvalueNames) > 0 {
+		return f.UpvalueNames[idx]
+	}
+	return "-"
+}
+
+// source names may begin with '@'(files) or '='(anything else)
+func sourceName(f *binchunk.Prototype) string {
+	if strings.HasPrefix(f.Source, "@") || strings.HasPrefix(f.Source, "=") {
+		return f.Source[1:]
+	}
+	if f.Source == "" {
+		return "?"
+	}
+	return f.Source
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
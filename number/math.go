@@ -1,6 +1,10 @@
 package number
 
-import "math"
+import (
+	"math"
+	"strconv"
+	"strings"
+)
 
 // 在lua里，除法都是是向下取整的，而在Go 和C 中，都是向0取整的
 func IFloorDiv(a, b int64) int64 {
@@ -47,6 +51,32 @@ following functions provide means for type conversion
 
 // bool in return value lists tell whether there is a precision loss
 func FloatToInteger(f float64) (int64, bool) {
-	i := int64(f)
-	return i, float64(i) == f
+	// out of range conversions are implementation-specific in Go
+	if f >= -(1<<63) && f < 1<<63 {
+		i := int64(f)
+		return i, float64(i) == f
+	}
+	return 0, false
+}
+
+// Format the float like lua does with "%.14g", floats that look like
+// integers get a ".0" suffix to tell them from integers.
+func FloatToString(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+
+	s := strconv.FormatFloat(f, 'g', 14, 64)
+	if strings.Trim(s, "-0123456789") == "" {
+		s += ".0"
+	}
+	return s
 }
@@ -1,13 +1,95 @@
 package number
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+)
 
+/*****************************
+following functions convert numerals written in lua syntax,
+leading and trailing spaces and a sign are allowed
+*****************************/
+
+// Hexadecimal integers wrap around on overflow while decimal ones fail,
+// so that they can be read as floats instead.
 func ParseInteger(str string) (int64, bool) {
-	i, err := strconv.ParseInt(str, 10, 64)
+	str = strings.TrimSpace(str)
+	neg, digits := _cutSign(str)
+	if _hasHexPrefix(digits) {
+		digits = digits[2:]
+		if digits == "" {
+			return 0, false
+		}
+
+		var i int64
+		for _, c := range digits {
+			d := _hexDigit(c)
+			if d < 0 {
+				return 0, false
+			}
+			i = i<<4 | d
+		}
+		if neg {
+			i = -i
+		}
+		return i, true
+	}
+
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return 0, false
+	}
+	i, err := strconv.ParseInt(str, 10, 64) // the sign decides the range
 	return i, err == nil
 }
 
 func ParseFloat(str string) (float64, bool) {
+	str = strings.TrimSpace(str)
+	// reject what Go accepts but lua doesn't: "inf", "nan" and digit separators
+	if strings.ContainsAny(str, "iInN_") {
+		return 0, false
+	}
+
+	neg, digits := _cutSign(str)
+	if _hasHexPrefix(digits) && !strings.ContainsAny(digits, "pP") {
+		str = digits + "p0" // exponent is optional in lua but not in Go
+		if neg {
+			str = "-" + str
+		}
+	}
+
 	f, err := strconv.ParseFloat(str, 64)
-	return f, err == nil
+	if err != nil {
+		// too large or small numbers become inf or 0 like strtod does
+		if e, ok := err.(*strconv.NumError); !ok || e.Err != strconv.ErrRange {
+			return 0, false
+		}
+	}
+	return f, true
+}
+
+func _cutSign(str string) (bool, string) {
+	if strings.HasPrefix(str, "-") {
+		return true, str[1:]
+	}
+	if strings.HasPrefix(str, "+") {
+		return false, str[1:]
+	}
+	return false, str
+}
+
+func _hasHexPrefix(str string) bool {
+	return strings.HasPrefix(str, "0x") || strings.HasPrefix(str, "0X")
+}
+
+func _hexDigit(c rune) int64 {
+	switch {
+	case c >= '0' && c <= '9':
+		return int64(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int64(c - 'a' + 10)
+	case c >= 'A' && c <= 'F':
+		return int64(c - 'A' + 10)
+	default:
+		return -1
+	}
 }
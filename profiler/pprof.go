@@ -0,0 +1,186 @@
+package profiler
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+/*
+Encoding of the profile in the protobuf format of pprof, see profile.proto
+in github.com/google/pprof. Only the messages and fields used here are written.
+*/
+
+// field numbers of the messages
+const (
+	// Profile
+	profSampleType        = 1
+	profSample            = 2
+	profLocation          = 4
+	profFunction          = 5
+	profStringTable       = 6
+	profTimeNanos         = 9
+	profDurationNanos     = 10
+	profPeriodType        = 11
+	profPeriod            = 12
+	profDefaultSampleType = 14
+	// ValueType
+	vtType = 1
+	vtUnit = 2
+	// Sample
+	sampleLocationID = 1
+	sampleValue      = 2
+	// Location
+	locID   = 1
+	locLine = 4
+	// Line
+	lineFunctionID = 1
+	lineLine       = 2
+	// Function
+	funcID         = 1
+	funcName       = 2
+	funcSystemName = 3
+	funcFilename   = 4
+	funcStartLine  = 5
+)
+
+// wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) tag(field, wire int) {
+	b.varint(uint64(field)<<3 | uint64(wire))
+}
+
+func (b *protoBuffer) uint64(field int, x uint64) {
+	b.tag(field, wireVarint)
+	b.varint(x)
+}
+
+// Zero values are omitted like protobuf encoders do.
+func (b *protoBuffer) int64Opt(field int, x int64) {
+	if x != 0 {
+		b.uint64(field, uint64(x))
+	}
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+// Write the message encoded by f as the field.
+func (b *protoBuffer) message(field int, f func(m *protoBuffer)) {
+	m := &protoBuffer{}
+	f(m)
+	b.bytes(field, m.data)
+}
+
+func (b *protoBuffer) packedUint64s(field int, xs []uint64) {
+	m := &protoBuffer{}
+	for _, x := range xs {
+		m.varint(x)
+	}
+	b.bytes(field, m.data)
+}
+
+func (b *protoBuffer) packedInt64s(field int, xs []int64) {
+	m := &protoBuffer{}
+	for _, x := range xs {
+		m.varint(uint64(x))
+	}
+	b.bytes(field, m.data)
+}
+
+// strings of a profile, which are referred to by their indices
+type stringTable struct {
+	strs  []string
+	index map[string]int64
+}
+
+func newStringTable() *stringTable {
+	return &stringTable{strs: []string{""}, index: map[string]int64{"": 0}}
+}
+
+func (t *stringTable) get(s string) int64 {
+	i, ok := t.index[s]
+	if !ok {
+		i = int64(len(t.strs))
+		t.strs = append(t.strs, s)
+		t.index[s] = i
+	}
+	return i
+}
+
+// Write the profile gzipped, which is how pprof stores profiles.
+func (p *Profiler) encode(w io.Writer) error {
+	strs := newStringTable()
+	b := &protoBuffer{}
+
+	valueType := func(field int, typ, unit string) {
+		b.message(field, func(m *protoBuffer) {
+			m.int64Opt(vtType, strs.get(typ))
+			m.int64Opt(vtUnit, strs.get(unit))
+		})
+	}
+	valueType(profSampleType, "instructions", "count")
+	valueType(profSampleType, "time", "nanoseconds")
+
+	for _, s := range p.samples {
+		b.message(profSample, func(m *protoBuffer) {
+			m.packedUint64s(sampleLocationID, s.locs)
+			m.packedInt64s(sampleValue, []int64{s.insts, s.nanos})
+		})
+	}
+	for i, loc := range p.locations {
+		b.message(profLocation, func(m *protoBuffer) {
+			m.uint64(locID, uint64(i+1))
+			m.message(locLine, func(l *protoBuffer) {
+				l.uint64(lineFunctionID, loc.function)
+				l.int64Opt(lineLine, int64(loc.line))
+			})
+		})
+	}
+	for i, f := range p.functions {
+		b.message(profFunction, func(m *protoBuffer) {
+			m.uint64(funcID, uint64(i+1))
+			m.int64Opt(funcName, strs.get(f.name))
+			m.int64Opt(funcSystemName, strs.get(f.name))
+			m.int64Opt(funcFilename, strs.get(f.file))
+			m.int64Opt(funcStartLine, int64(f.startLine))
+		})
+	}
+
+	b.int64Opt(profTimeNanos, p.start.UnixNano())
+	b.int64Opt(profDurationNanos, int64(p.duration))
+	valueType(profPeriodType, "instructions", "count")
+	b.int64Opt(profPeriod, int64(p.period))
+	b.int64Opt(profDefaultSampleType, strs.get("time"))
+	for _, s := range strs.strs { // after all strings are known
+		b.string(profStringTable, s)
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,274 @@
+/*
+Package profiler finds the hot lua functions and lines of scripts. It's driven
+by the debug hook: every period instructions, and when functions are called and
+return, the instructions and the time since the last event are attributed to
+the call stack, whose go functions are included. The profile is written in the
+protobuf format of pprof, so `go tool pprof` is able to show it.
+
+Walking the call stack on every call would make scripts many times slower, so
+a shadow stack of each thread is kept by the call and return events, and only
+the samples every period instructions walk the real stack, which corrects the
+shadow one too. Frames unwound by errors get no return events, the shadow stack
+is found out of date when the function it's at isn't the one running.
+
+Lua functions are named after where they're defined like "test.lua:12", go
+functions after how they're called like "[C] print". Time spent in coroutines
+is counted as long as they're created after the profiling starts.
+*/
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gonearewe/lua-compiler/api"
+)
+
+// DefaultPeriod is the number of instructions between samples by default.
+const DefaultPeriod = 1000
+
+// only the innermost levels of a deep call stack are recorded
+const maxDepth = 64
+
+type function struct {
+	name      string
+	file      string
+	startLine int
+}
+
+type location struct {
+	function uint64 // id of the function, which is its index+1
+	line     int
+}
+
+// values attributed to a call stack, locs are the ids of its locations
+type sample struct {
+	locs  []uint64
+	insts int64
+	nanos int64
+}
+
+// A Profiler records the profile of the scripts run by a lua state,
+// see Start().
+type Profiler struct {
+	ls       api.LuaState
+	period   int
+	start    time.Time
+	last     time.Time // when values are last attributed
+	duration time.Duration
+	stopped  bool
+
+	samples   map[string]*sample        // by the locations of call stacks
+	stacks    map[api.LuaState][]uint64 // shadow stacks of the threads, innermost last
+	key       []byte                    // buffer of the keys of samples
+	functions []function
+	locations []location
+	funcIDs   map[function]uint64
+	locIDs    map[location]uint64
+}
+
+// Start profiling the lua state, by setting its debug hook, until Stop().
+// Period is the number of instructions between samples, DefaultPeriod if it's
+// not positive. The hook is also set to the coroutines created later.
+func Start(ls api.LuaState, period int) *Profiler {
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+
+	now := time.Now()
+	p := &Profiler{
+		ls:      ls,
+		period:  period,
+		start:   now,
+		last:    now,
+		samples: map[string]*sample{},
+		stacks:  map[api.LuaState][]uint64{},
+		funcIDs: map[function]uint64{},
+		locIDs:  map[location]uint64{},
+	}
+	ls.SetHook(p.hook, api.LUA_MASKCALL|api.LUA_MASKRET|api.LUA_MASKCOUNT, period)
+
+	return p
+}
+
+// Stop profiling, coroutines that are still alive ignore their hooks then.
+func (p *Profiler) Stop() {
+	if !p.stopped {
+		p.stopped = true
+		p.duration = time.Since(p.start)
+		p.ls.SetHook(nil, 0, 0)
+	}
+}
+
+// Write stops profiling and writes the profile gzipped in the protobuf
+// format of pprof.
+func (p *Profiler) Write(w io.Writer) error {
+	p.Stop()
+	return p.encode(w)
+}
+
+func (p *Profiler) hook(ls api.LuaState, ar *api.Debug) {
+	if p.stopped {
+		return
+	}
+
+	now := time.Now()
+	nanos := int64(now.Sub(p.last))
+	stack := p.stacks[ls]
+	switch ar.Event {
+	case api.LUA_HOOKCOUNT:
+		stack = p.walk(ls, stack)
+		p.add(stack, int64(p.period), nanos)
+	case api.LUA_HOOKCALL: // the time before belongs to the caller
+		stack = p.sync(ls, 1, stack)
+		p.add(stack, 0, nanos)
+		if loc, ok := p.frame(ls, 0); ok {
+			stack = append(stack, loc)
+		}
+	case api.LUA_HOOKTAILCALL: // the caller is replaced already
+		p.add(stack, 0, nanos)
+		if len(stack) > 0 {
+			stack = stack[:len(stack)-1]
+		}
+		if loc, ok := p.frame(ls, 0); ok {
+			stack = append(stack, loc)
+		}
+	case api.LUA_HOOKRET:
+		stack = p.sync(ls, 0, stack)
+		p.add(stack, 0, nanos)
+		if len(stack) > 0 {
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if len(stack) == 0 { // a finished coroutine
+		delete(p.stacks, ls)
+	} else {
+		p.stacks[ls] = stack
+	}
+	p.last = time.Now() // excluding the time of the hook itself
+}
+
+// Return the location of the function at the level of the call stack.
+func (p *Profiler) frame(ls api.LuaState, level int) (uint64, bool) {
+	var ar api.Debug
+	if !ls.GetInfo(level, "Sl", &ar) {
+		return 0, false
+	}
+	if ar.What == "C" { // named after the call, which is slow to find out
+		ls.GetInfo(level, "n", &ar)
+	}
+	return p.locationID(&ar), true
+}
+
+// Update the line of the innermost function of the shadow stack to the one
+// running at the level, or walk the real stack from the level if it isn't
+// the same function.
+func (p *Profiler) sync(ls api.LuaState, level int, stack []uint64) []uint64 {
+	loc, ok := p.frame(ls, level)
+	if !ok {
+		return stack[:0]
+	}
+	if n := len(stack); n > 0 && p.locations[stack[n-1]-1].function == p.locations[loc-1].function {
+		stack[n-1] = loc
+		return stack
+	}
+	return p.walk(ls, stack)
+}
+
+// Replace the innermost levels of the shadow stack with the real ones, up to
+// maxDepth. Outer levels of a deeper stack are kept as they are, since they
+// would take too long to find.
+func (p *Profiler) walk(ls api.LuaState, stack []uint64) []uint64 {
+	var locs []uint64 // innermost first
+	for level := 0; len(locs) < maxDepth; level++ {
+		loc, ok := p.frame(ls, level)
+		if !ok {
+			break
+		}
+		locs = append(locs, loc)
+	}
+
+	if len(locs) < maxDepth || len(stack) < maxDepth {
+		stack = stack[:0]
+	} else {
+		stack = stack[:len(stack)-maxDepth]
+	}
+	for i := len(locs) - 1; i >= 0; i-- {
+		stack = append(stack, locs[i])
+	}
+	return stack
+}
+
+// Attribute the values to the innermost levels of the call stack.
+func (p *Profiler) add(stack []uint64, insts, nanos int64) {
+	if len(stack) == 0 {
+		return
+	}
+
+	if len(stack) > maxDepth {
+		stack = stack[len(stack)-maxDepth:]
+	}
+	key := p.key[:0]
+	for i := len(stack) - 1; i >= 0; i-- {
+		key = strconv.AppendUint(key, stack[i], 16)
+		key = append(key, ',')
+	}
+	p.key = key
+
+	s, ok := p.samples[string(key)]
+	if !ok {
+		locs := make([]uint64, len(stack)) // innermost first
+		for i, loc := range stack {
+			locs[len(stack)-1-i] = loc
+		}
+		s = &sample{locs: locs}
+		p.samples[string(key)] = s
+	}
+	s.insts += insts
+	s.nanos += nanos
+}
+
+func (p *Profiler) locationID(ar *api.Debug) uint64 {
+	// the key has the name of go functions only, names are made once
+	f := function{}
+	line := 0
+	if ar.What == "C" {
+		f.name = ar.Name
+	} else {
+		f.file, f.startLine = ar.ShortSrc, ar.LineDefined
+		line = ar.CurrentLine
+	}
+
+	fid, ok := p.funcIDs[f]
+	if !ok {
+		key := f
+		switch ar.What {
+		case "C":
+			if f.name == "" {
+				f.name = "?"
+			}
+			f.name = "[C] " + f.name
+		case "main":
+			f.name = "main chunk " + f.file
+		default:
+			f.name = fmt.Sprintf("%s:%d", f.file, f.startLine)
+		}
+		p.functions = append(p.functions, f)
+		fid = uint64(len(p.functions))
+		p.funcIDs[key] = fid
+	}
+
+	if line < 0 { // stripped
+		line = 0
+	}
+	loc := location{fid, line}
+	lid, ok := p.locIDs[loc]
+	if !ok {
+		p.locations = append(p.locations, loc)
+		lid = uint64(len(p.locations))
+		p.locIDs[loc] = lid
+	}
+	return lid
+}
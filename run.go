@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/coverage"
+	"github.com/gonearewe/lua-compiler/profiler"
+	"github.com/gonearewe/lua-compiler/state"
+	"github.com/gonearewe/lua-compiler/stdlib"
+)
+
+const (
+	prompt  = "> "
+	prompt2 = ">> " // for continuation lines of an incomplete statement
+)
+
+// options that may be given more than once and must be handled in order
+type orderedOpts struct {
+	opts *[][2]string
+	name string
+}
+
+func (o orderedOpts) String() string { return "" }
+
+func (o orderedOpts) Set(v string) error {
+	*o.opts = append(*o.opts, [2]string{o.name, v})
+	return nil
+}
+
+// Run a script like the standalone `lua` interpreter does, the script
+// receives the remaining arguments as varargs and through the global table `arg`.
+func runCmd(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var opts [][2]string
+	fs.Var(orderedOpts{&opts, "e"}, "e", "execute string `stat`")
+	fs.Var(orderedOpts{&opts, "l"}, "l", "run library `name` and store its result in global name")
+	interactive := fs.Bool("i", false, "enter interactive mode after executing script")
+	profile := fs.String("profile", "", "write a pprof profile of the lua code to `file`")
+	cover := fs.String("coverage", "", "write the line coverage of the scripts to `file`, as Cobertura XML if it ends with .xml or else lcov")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s run [options] [script [args]]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var reports []func() // written before exiting
+	exit := func(code int) {
+		for _, r := range reports {
+			r()
+		}
+		os.Exit(code)
+	}
+
+	ls := state.New()
+	ls.RequireF("os", stdlib.NewOSLib(stdlib.SystemClock(), stdlib.SystemEnv(), stdlib.SystemFS(), exit), false)
+	ls.Pop(1)
+	stdlib.OpenLibs(ls)
+	createArgTable(ls, args, len(args)-fs.NArg())
+	if *profile != "" {
+		p := profiler.Start(ls, profiler.DefaultPeriod)
+		reports = append(reports, func() { writeReport(*profile, p.Write) })
+	}
+	if *cover != "" {
+		c := coverage.New()
+		ls.SetCoverage(c)
+		reports = append(reports, func() { writeCoverage(c, *cover) })
+	}
+
+	for _, opt := range opts {
+		switch opt[0] {
+		case "e":
+			if !doString(ls, opt[1], "=(command line)") {
+				exit(1)
+			}
+		case "l":
+			if !doLibrary(ls, opt[1]) {
+				exit(1)
+			}
+		}
+	}
+
+	if fs.NArg() > 0 {
+		if !doScript(ls, fs.Arg(0), fs.Args()[1:]) {
+			exit(1)
+		}
+		if *interactive {
+			doREPL(ls)
+		}
+	} else if len(opts) == 0 || *interactive {
+		doREPL(ls)
+	}
+	exit(0)
+}
+
+// Write a report into the named file by write, errors are reported but not fatal.
+func writeReport(name string, write func(w io.Writer) error) {
+	f, err := os.Create(name)
+	if err == nil {
+		err = write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+	}
+}
+
+// arg[0] is the script, arg[1], arg[2]... are arguments of the script,
+// while everything before the script goes to negative indices.
+func createArgTable(ls api.LuaState, args []string, script int) {
+	all := append([]string{os.Args[0], "run"}, args...)
+	script += 2
+
+	ls.CreateTable(len(all)-script, script)
+	for i, a := range all {
+		ls.PushString(a)
+		ls.SetI(-2, int64(i-script))
+	}
+	ls.SetGlobal("arg")
+}
+
+// Report the error message on the top of the stack and pop it.
+func report(ls api.LuaState) {
+	msg, ok := ls.ToStringX(-1)
+	if !ok {
+		msg = fmt.Sprintf("(error object is a %s value)", ls.TypeName(ls.Type(-1)))
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], msg)
+	ls.Pop(1)
+}
+
+// Message handler of docall(), which adds the traceback
+// of where the error happens to the error message.
+func msgHandler(ls api.LuaState) int {
+	msg, ok := ls.ToStringX(1)
+	if !ok { // error object is not a string
+		if ls.CallMeta(1, "__tostring") && ls.Type(-1) == api.LUA_TSTRING {
+			return 1 // that is the message
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", ls.TypeName(ls.Type(1)))
+	}
+
+	ls.Traceback(ls, msg, 1)
+	return 1
+}
+
+// Call the function below nArgs arguments on the top of the stack,
+// report the error if any and return whether it succeeds.
+func docall(ls api.LuaState, nArgs, nResults int) bool {
+	base := ls.GetTop() - nArgs // function index
+	ls.PushGoFunction(msgHandler)
+	ls.Insert(base) // put it under function and args
+	status := ls.PCall(nArgs, nResults, base)
+	ls.Remove(base)
+
+	if status != api.LUA_OK {
+		report(ls)
+		return false
+	}
+
+	return true
+}
+
+func doString(ls api.LuaState, s, chunkName string) bool {
+	if ls.Load([]byte(s), chunkName, "t") != api.LUA_OK {
+		report(ls)
+		return false
+	}
+
+	return docall(ls, 0, 0)
+}
+
+// Run `name`.lua(dots in the name mean directories) and
+// save its result in the global variable `name`.
+func doLibrary(ls api.LuaState, name string) bool {
+	file := strings.Replace(name, ".", "/", -1) + ".lua"
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: module '%s' not found: %v\n", os.Args[0], name, err)
+		return false
+	}
+
+	if ls.Load(data, "@"+file, "bt") != api.LUA_OK {
+		report(ls)
+		return false
+	}
+
+	if !docall(ls, 0, 1) {
+		return false
+	}
+	if ls.IsNil(-1) {
+		ls.Pop(1)
+		ls.PushBoolean(true)
+	}
+	ls.SetGlobal(name)
+
+	return true
+}
+
+// Run the script, which is read from stdin if named "-".
+func doScript(ls api.LuaState, script string, args []string) bool {
+	if script == "-" {
+		script = "" // LoadFile() reads stdin then
+	}
+	if ls.LoadFile(script) != api.LUA_OK {
+		report(ls)
+		return false
+	}
+
+	ls.CheckStack(len(args))
+	for _, a := range args {
+		ls.PushString(a)
+	}
+
+	return docall(ls, len(args), 0)
+}
+
+// Read-eval-print loop, every line is first tried as an expression
+// whose values are printed, then as a statement which may span lines.
+func doREPL(ls api.LuaState) {
+	in := bufio.NewReader(os.Stdin)
+	for {
+		line, ok := readLine(in, prompt)
+		if !ok {
+			fmt.Println()
+			return
+		}
+
+		if !loadLine(ls, in, line) {
+			report(ls)
+			continue
+		}
+
+		base := ls.GetTop() - 1 // where the loaded function lies
+		if docall(ls, 0, -1) && ls.GetTop() > base {
+			ls.GetGlobal("print")
+			ls.Insert(base + 1)
+			docall(ls, ls.GetTop()-base-1, 0)
+		}
+	}
+}
+
+func readLine(in *bufio.Reader, p string) (string, bool) {
+	fmt.Print(p)
+	line, err := in.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return "", false
+	}
+
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+// Load the line as `return line` or as a statement, keep reading
+// more lines while the statement is incomplete. A line starting with
+// '=' is `return` followed by the rest of it, like in Lua 5.2.
+func loadLine(ls api.LuaState, in *bufio.Reader, line string) bool {
+	if strings.HasPrefix(line, "=") {
+		line = "return " + line[1:]
+	}
+	if ls.Load([]byte("return "+line), "=stdin", "t") == api.LUA_OK {
+		return true
+	}
+	ls.Pop(1)
+
+	for {
+		if ls.Load([]byte(line), "=stdin", "t") == api.LUA_OK {
+			return true
+		}
+		if !incomplete(ls) {
+			return false
+		}
+		ls.Pop(1)
+
+		more, ok := readLine(in, prompt2)
+		if !ok {
+			ls.Load([]byte(line), "=stdin", "t") // get the error message back
+			return false
+		}
+		line += "\n" + more
+	}
+}
+
+// Whether the syntax error on the top of the stack is due to
+// the end of input, that is, the statement isn't finished yet.
+func incomplete(ls api.LuaState) bool {
+	msg := ls.ToString(-1)
+	return strings.HasSuffix(msg, "near 'EOF'") ||
+		strings.HasSuffix(msg, "unfinished long string or comment")
+}
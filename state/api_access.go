@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	. "github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/number"
 )
 
 func (self *luaState) RawLen(idx int) uint {
@@ -83,6 +84,28 @@ func (l *luaState) IsNumber(idx int) bool {
 	return ok
 }
 
+func (l *luaState) IsTable(idx int) bool {
+	return l.Type(idx) == LUA_TTABLE
+}
+
+func (l *luaState) IsThread(idx int) bool {
+	return l.Type(idx) == LUA_TTHREAD
+}
+
+func (l *luaState) IsFunction(idx int) bool {
+	return l.Type(idx) == LUA_TFUNCTION
+}
+
+// check if the value at index idx is a full or light userdata
+func (l *luaState) IsUserdata(idx int) bool {
+	t := l.Type(idx)
+	return t == LUA_TUSERDATA || t == LUA_TLIGHTUSERDATA
+}
+
+func (l *luaState) IsLightUserdata(idx int) bool {
+	return l.Type(idx) == LUA_TLIGHTUSERDATA
+}
+
 func (l *luaState) IsInteger(idx int) bool {
 	val := l.stack.get(idx)
 	_, ok := val.(int64)
@@ -135,9 +158,13 @@ func (l *luaState) ToStringX(idx int) (string, bool) {
 	switch x := val.(type) {
 	case string:
 		return x, true
-	case int64, float64:
-		s := fmt.Sprintf("%v", x)
-		l.stack.set(idx, s) //
+	case int64:
+		s := fmt.Sprintf("%d", x)
+		l.stack.set(idx, s)
+		return s, true
+	case float64:
+		s := number.FloatToString(x)
+		l.stack.set(idx, s)
 		return s, true
 	default:
 		return "", false
@@ -164,3 +191,42 @@ func (l *luaState) ToGoFunction(idx int) GoFunction {
 
 	return nil
 }
+
+// Return the value at given index if it's a table or function,
+// which only serves to identify the value, like in its string form;
+// return nil for other values.
+func (l *luaState) ToPointer(idx int) interface{} {
+	switch x := l.stack.get(idx).(type) {
+	case *luaTable, *closure, *luaState, *userdata:
+		return x
+	case lightUserdata:
+		return x.p
+	default:
+		return nil
+	}
+}
+
+// Return the Go value of the full or light userdata at given index,
+// or nil if it isn't a userdata.
+func (l *luaState) ToUserdata(idx int) interface{} {
+	switch x := l.stack.get(idx).(type) {
+	case *userdata:
+		return x.data
+	case lightUserdata:
+		return x.p
+	default:
+		return nil
+	}
+}
+
+// Return the thread at given index or nil if it isn't a thread. It's this
+// very luaState if that's the running thread, so it compares equal to it.
+func (l *luaState) ToThread(idx int) LuaState {
+	if t, ok := l.stack.get(idx).(*thread); ok {
+		if t == l.handle {
+			return l
+		}
+		return t
+	}
+	return nil
+}
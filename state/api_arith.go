@@ -72,6 +72,16 @@ func (l *luaState) Arith(op ArithOp) {
 	}
 
 	operator := operators[op]
+	if y, ok := b.(int64); ok && y == 0 { // integer division by zero
+		if _, ok := a.(int64); ok {
+			switch op {
+			case LUA_OPIDIV:
+				l.runError("attempt to perform 'n//0'")
+			case LUA_OPMOD:
+				l.runError("attempt to perform 'n%%0'")
+			}
+		}
+	}
 	if result := _arith(a, b, operator); result != nil {
 		l.stack.push(result)
 		return
@@ -83,7 +93,13 @@ func (l *luaState) Arith(op ArithOp) {
 		return
 	}
 
-	panic("arithmetic error !")
+	if operator.floatFunc != nil {
+		l.opIntError(a, b, "perform arithmetic on")
+	} else if isNumber(a) && isNumber(b) { // strings convertible to numbers too
+		l.toIntError(a, b)
+	} else {
+		l.opIntError(a, b, "perform bitwise operation on")
+	}
 }
 
 func _arith(a, b luaValue, op operator) luaValue {
@@ -109,3 +125,9 @@ func _arith(a, b luaValue, op operator) luaValue {
 	}
 	return nil
 }
+
+// Whether the value is a number or a string convertible to a number.
+func isNumber(val luaValue) bool {
+	_, ok := convertToFloat(val)
+	return ok
+}
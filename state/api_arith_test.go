@@ -0,0 +1,45 @@
+package state_test
+
+import (
+	"testing"
+
+	. "github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/state"
+)
+
+// Run the chunk in a new lua state without the standard libraries,
+// return the message of its error, "" if none.
+func runError(t *testing.T, chunk string) string {
+	t.Helper()
+	ls := state.New()
+	if ls.Load([]byte(chunk), "=test", "t") != LUA_OK {
+		t.Fatalf("%s: %s", chunk, ls.ToString(-1))
+	}
+	if ls.PCall(0, 0, 0) != LUA_OK {
+		return ls.ToString(-1)
+	}
+	return ""
+}
+
+func TestArithErrors(t *testing.T) {
+	tests := []struct {
+		chunk string
+		want  string
+	}{
+		{"return 1 + {}", "test:1: attempt to perform arithmetic on a table value"},
+		{"local s = 'x' return s * 2", "test:1: attempt to perform arithmetic on a string value (local 's')"},
+		{"return 1 // 0", "test:1: attempt to perform 'n//0'"},
+		{"return 1.5 | 0", "test:1: number has no integer representation"},
+		{"return '1.5' | 0", "test:1: number (constant '1.5') has no integer representation"},
+		{"local s = '1e100' return 1 & s", "test:1: number (local 's') has no integer representation"},
+		{"return 'x' | 0", "test:1: attempt to perform bitwise operation on a string value (constant 'x')"},
+		{"return 1 ~ true", "test:1: attempt to perform bitwise operation on a boolean value"},
+		{"return '3' | 0 == 3 or error('')", ""},
+	}
+
+	for _, test := range tests {
+		if got := runError(t, test.chunk); got != test.want {
+			t.Errorf("%s: error %q, want %q", test.chunk, got, test.want)
+		}
+	}
+}
@@ -1,15 +1,52 @@
 package state
 
 import (
-	"github.com/gonearewe/lua-compiler/api"
-	"github.com/gonearewe/lua-compiler/vm"
+	"fmt"
+	"strings"
 
+	"github.com/gonearewe/lua-compiler/api"
 	"github.com/gonearewe/lua-compiler/binchunk"
+	"github.com/gonearewe/lua-compiler/compiler/codegen"
+	"github.com/gonearewe/lua-compiler/compiler/parser"
+	"github.com/gonearewe/lua-compiler/vm"
 )
 
-func (l *luaState) Load(chunk []byte, chunkName, mode string) int {
-	proto := binchunk.Undump(chunk)
+// Load a chunk, either precompiled or in source form, and push it as a
+// function onto the stack; mode is "b" (only binary chunks), "t" (only text
+// chunks) or "bt" (both, also what an empty mode means). When anything goes
+// wrong, an error message is pushed instead and LUA_ERRSYNTAX is returned.
+func (l *luaState) Load(chunk []byte, chunkName, mode string) (status int) {
+	status = api.LUA_ERRSYNTAX
+	if mode == "" {
+		mode = "bt"
+	}
+
+	// both the lexer and the undumper report errors by panicking
+	defer func() {
+		if err := recover(); err != nil {
+			l.stack.check(1)
+			l.stack.push(_errorMessage(err))
+		}
+	}()
+
+	var proto *binchunk.Prototype
+	if binchunk.IsBinaryChunk(chunk) {
+		if !strings.Contains(mode, "b") {
+			panic(fmt.Sprintf("attempt to load a binary chunk (mode is '%s')", mode))
+		}
+		proto = binchunk.Undump(chunk)
+	} else {
+		if !strings.Contains(mode, "t") {
+			panic(fmt.Sprintf("attempt to load a text chunk (mode is '%s')", mode))
+		}
+		proto = codegen.GenProto(parser.Parse(string(chunk), chunkName), chunkName)
+	}
+
+	if l.coverage != nil {
+		l.coverage.AddChunk(proto)
+	}
 	c := newLuaClosure(proto)
+	l.stack.check(1)
 	l.stack.push(c)
 
 	if len(proto.Upvalues) > 0 { // set _ENV
@@ -20,6 +57,18 @@ func (l *luaState) Load(chunk []byte, chunkName, mode string) int {
 	return api.LUA_OK
 }
 
+// Convert a recovered value into an error message.
+func _errorMessage(err interface{}) string {
+	switch x := err.(type) {
+	case string:
+		return x
+	case error:
+		return x.Error()
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
 // Call LuaClosure, GoClosure or Metamethod with nArgs(number of args)
 // and nResults(number of requesting return values).
 // EXAMPLE1: for stack[5,2,fun,45,13], Call(2,1) calls fun(45,13) requesting one return value,
@@ -28,14 +77,34 @@ func (l *luaState) Load(chunk []byte, chunkName, mode string) int {
 // Call(2,1) calls mf(val,45,13) requesting one return value,
 // after that, the stack is [5,2,<returnValue 1>].
 func (l *luaState) Call(nArgs, nResults int) {
+	if l.nCcalls >= api.LUAI_MAXCCALLS && !l.handling {
+		l.runError("C stack overflow")
+	}
+	l.nCcalls++
+
+	c, nArgs := l.funcToCall(nArgs)
+	if c.proto != nil {
+		l.callLuaClosure(nArgs, nResults, c)
+	} else {
+		l.callGoClosure(nArgs, nResults, c)
+	}
+	l.nCcalls--
+}
+
+// Return the closure below nArgs arguments on the top of the stack and the
+// number of arguments. If it's not a closure but has a metamethod __call, the
+// metamethod is called instead with the value as its first argument.
+func (l *luaState) funcToCall(nArgs int) (*closure, int) {
 	val := l.stack.get(-(nArgs + 1))
 
 	c, ok := val.(*closure)
 	if !ok { // support metamethod
 		if mf := getMetafield(val, "__call", l); mf != nil {
 			if c, ok = mf.(*closure); ok { // ok can be modified here
+				l.stack.check(1)
 				l.stack.push(val)
 				l.Insert(-(nArgs + 2))
+				l.stack.set(-(nArgs + 2), mf)
 				nArgs += 1
 				// for stack[5,2,val,45,13], val is not a closure but has a metamehod mf,
 				// now the stack is [5,2,mf,val,45,13] and args include val, 45 and 13
@@ -43,56 +112,79 @@ func (l *luaState) Call(nArgs, nResults int) {
 		}
 	}
 
-	if ok { // call closure or metamethod
-		if c.proto != nil {
-			l.callLuaClosure(nArgs, nResults, c)
-		} else {
-			l.callGoClosure(nArgs, nResults, c)
-		}
-		// DEBUG
-		// fmt.Printf(
-		// 	"call %s<%d,%d>\n",
-		// 	c.proto.Source,
-		// 	c.proto.LineDefined,
-		// 	c.proto.LastLineDefined,
-		// )
-
-	} else {
-		panic("not function !")
+	if !ok {
+		l.operandError(val, "call")
 	}
+	return c, nArgs
 }
 
 // Call a function that is able to throw an exception, offer exception
 // catching and handling support, refer to Call() for details of basic function calling.
-func (l *luaState) PCall(nArgs, nResults int, msgh int) (status int) {
+// If msgh isn't 0, it's the stack index of the message handler, which is called
+// with the error object where the error happens, and its result is what's pushed.
+func (l *luaState) PCall(nArgs, nResults int, msgh int) int {
+	if err := l.pcall(nArgs, nResults, msgh); err != nil {
+		return err.Status
+	}
+	return api.LUA_OK
+}
+
+// PCall() returning the error caught, nil if none.
+func (l *luaState) pcall(nArgs, nResults int, msgh int) (luaErr *api.LuaError) {
 	caller := l.stack
-	status = api.LUA_ERRRUN
+
+	oldErrFunc, oldHandling, oldCcalls, oldInHook := l.errFunc, l.handling, l.nCcalls, l.inHook
+	l.errFunc, l.handling = nil, false
+	if msgh != 0 {
+		l.errFunc = l.stack.get(msgh)
+	}
 
 	// catch error
 	defer func() {
+		l.errFunc, l.handling = oldErrFunc, oldHandling
 		if err := recover(); err != nil {
+			luaErr = toLuaError(err)
+			if luaErr.Uncatchable && (caller.prev != nil || l.IsYieldable()) {
+				panic(luaErr) // inside lua code, let the host catch it
+			}
+			l.nCcalls, l.inHook = oldCcalls, oldInHook
 			// VM recovered, but luaStack remains where exception occurs
 			for l.stack != caller {
 				l.popLuaStack() // roll back to safe luaStack where pcall() is waiting.
 			}
 
-			l.stack.push(err)
+			l.stack.check(1)
+			l.stack.push(luaErr.Value)
 		}
 	}()
 
 	l.Call(nArgs, nResults)
-	status = api.LUA_OK // no exceptions, defer func not excuated
-
-	return
+	return nil // no exceptions, defer func not excuated
 }
 
 func (l *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
+	newStack := l.newLuaClosureStack(nArgs, nResults, c)
+	newStack.fresh = true
+
+	// call the function and run
+	l.pushLuaStack(newStack)
+	l.hookCall(api.LUA_HOOKCALL)
+	l.runLuaClosure()
+}
+
+// Pop the lua closure and nArgs arguments from the top of the stack,
+// return a new luaStack for the closure with the arguments passed.
+func (l *luaState) newLuaClosureStack(nArgs, nResults int, c *closure) *luaStack {
 	nRegs := int(c.proto.MaxStackSize) // number of registers
 	nParams := int(c.proto.NumParams)
 	isVararg := c.proto.IsVararg == 1
 
-	newStack := newLuaStack(nRegs+api.LUA_MINSTACK, l)
+	newStack := newLuaStack(nRegs+vmTemps, l)
 	newStack.closure = c
+	newStack.nResults = nResults
+	if l.coverage != nil {
+		newStack.hits = l.coverage.Counters(c.proto)
+	}
 
 	// pass parameters to the called function
 	funcAndArgs := l.stack.popN(nArgs + 1)
@@ -102,30 +194,89 @@ func (l *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
 		newStack.varargs = funcAndArgs[nParams+1:]
 	}
 
-	// call the function and run
-	l.pushLuaStack(newStack)
-	l.runLuaClosure()
-	l.popLuaStack()
+	return newStack
+}
 
-	// if the called function returns value
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
-		l.stack.check(len(results))
-		l.stack.pushN(results, nResults)
+// Prepare the call to the function below nArgs arguments on the top of the
+// stack for the VM. If it's a lua closure, its luaStack is pushed to be run by
+// the running runLuaClosure() and true is returned; otherwise it's called
+// right away and false is returned.
+func (l *luaState) PreCall(nArgs, nResults int) bool {
+	c, nArgs := l.funcToCall(nArgs)
+	if c.proto == nil {
+		l.callGoClosure(nArgs, nResults, c)
+		return false
 	}
+
+	l.pushLuaStack(l.newLuaClosureStack(nArgs, nResults, c))
+	l.hookCall(api.LUA_HOOKCALL)
+	return true
 }
 
+// Call the function below nArgs arguments on the top of the stack as a
+// tail call. If it's a lua closure, it replaces the running lua function,
+// whose results are its results then, and true is returned; otherwise
+// it's called right away with all results kept and false is returned.
+func (l *luaState) TailCall(nArgs int) bool {
+	c, nArgs := l.funcToCall(nArgs)
+	if c.proto == nil {
+		l.callGoClosure(nArgs, api.LUA_MULTRET, c)
+		return false
+	}
+
+	old := l.stack
+	newStack := l.newLuaClosureStack(nArgs, old.nResults, c)
+	newStack.fresh = old.fresh
+	newStack.isTailCall = true
+	l.popLuaStack()
+	l.pushLuaStack(newStack)
+	l.hookCall(api.LUA_HOOKTAILCALL)
+
+	return true
+}
+
+// Run the lua function on the top luaStack till it returns. Lua functions
+// called by it are run in the same loop, without recursive invocations.
 func (l *luaState) runLuaClosure() {
 	for {
+		l.countInst()
 		inst := vm.Instruction(l.Fetch())
+		if l.hookMask&(api.LUA_MASKLINE|api.LUA_MASKCOUNT) != 0 {
+			l.traceExec()
+		}
+		if hits := l.stack.hits; hits != nil {
+			hits[l.stack.pc-1]++
+		}
 		inst.Execute(l)
 
 		if inst.Opcode() == vm.OP_RETURN {
-			break
+			fresh := l.stack.fresh
+			l.postCall()
+			if fresh {
+				break
+			}
+
+			caller := l.stack // back to the caller's CALL instruction
+			vm.Instruction(caller.closure.proto.Code[caller.pc-1]).FinishCall(l)
 		}
 	}
 }
 
+// Pop the luaStack of the lua function that just returned,
+// and push its results into the caller's luaStack.
+func (l *luaState) postCall() {
+	l.hookReturn()
+	retStack := l.stack
+	l.popLuaStack()
+
+	// if the called function returns value
+	if retStack.nResults != 0 {
+		nRegs := int(retStack.closure.proto.MaxStackSize)
+		results := retStack.popN(retStack.top - nRegs)
+		pushResults(l.stack, results, retStack.nResults)
+	}
+}
+
 func (l *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 	newStack := newLuaStack(nArgs+api.LUA_MINSTACK, l)
 	newStack.closure = c
@@ -135,12 +286,22 @@ func (l *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 	l.stack.pop() // desert goClosure
 
 	l.pushLuaStack(newStack) // call
-	r := c.goFunc(l)         // execuate goFunc
-	l.popLuaStack()          // return
+	l.hookCall(api.LUA_HOOKCALL)
+	r := c.goFunc(l) // execuate goFunc
+	l.hookReturn()
+	l.popLuaStack() // return
 
 	if nResults != 0 { // push return values if any
 		results := newStack.popN(r)
-		l.stack.check(len(results))
-		l.stack.pushN(results, nResults)
+		pushResults(l.stack, results, nResults)
+	}
+}
+
+// Push the results of a call, adjusted to nResults unless it's LUA_MULTRET.
+func pushResults(stack *luaStack, results []luaValue, nResults int) {
+	if nResults < 0 {
+		nResults = len(results)
 	}
+	stack.check(nResults)
+	stack.pushN(results, nResults)
 }
@@ -63,6 +63,13 @@ func _eq(a, b luaValue, ls *luaState) bool {
 			}
 		}
 		return a == b
+	case *userdata: // metamethod
+		if y, ok := b.(*userdata); ok && x != y && ls != nil {
+			if result, ok := callMetamethod(x, y, "__eq", ls); ok {
+				return convertToBoolean(result)
+			}
+		}
+		return a == b
 	default:
 		return a == b
 	}
@@ -94,7 +101,8 @@ func _lt(a, b luaValue, ls *luaState) bool {
 	if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return convertToBoolean(result)
 	} else {
-		panic("comparison error !")
+		ls.orderError(a, b)
+		return false
 	}
 }
 
@@ -126,6 +134,7 @@ func _le(a, b luaValue, ls *luaState) bool {
 	} else if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return convertToBoolean(result)
 	} else {
-		panic("comparison error !")
+		ls.orderError(a, b)
+		return false
 	}
 }
@@ -0,0 +1,245 @@
+package state
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/gonearewe/lua-compiler/api"
+)
+
+/*
+Every coroutine runs on its own goroutine, but only one of them is running
+at a time: Resume() wakes the coroutine up and waits until it yields or
+finishes, Yield() wakes the resumer up and waits until the next Resume().
+So the Go call stack of a coroutine is kept while it's suspended and
+yielding works no matter how many Lua and Go frames are in between.
+
+A coroutine that is never resumed again would block its goroutine forever,
+so the suspended ones are killed by Close() or when the context is done:
+Yield() panics with coKilled, which no PCall() catches, and the goroutine exits.
+
+The goroutine of a suspended coroutine keeps its luaState alive, so the value
+of the coroutine in lua is a thread handle instead, which the luaState refers
+to only while it's running. Once the handle of a suspended coroutine is
+unreachable, its finalizer kills the coroutine as well. A coroutine keeping
+its own handle in its stack, like in a local, still lives until Close().
+*/
+
+// The value of a thread in lua and what NewThread() returns, see above.
+type thread struct {
+	*luaState
+}
+
+// the suspended coroutines of a lua state, which Close() kills
+type suspended struct {
+	mu  sync.Mutex
+	set map[*luaState]bool
+}
+
+func (s *suspended) add(l *luaState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.set == nil {
+		s.set = map[*luaState]bool{}
+	}
+	s.set[l] = true
+}
+
+// Remove the coroutine, return whether it was suspended.
+func (s *suspended) remove(l *luaState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.set[l] {
+		return false
+	}
+	delete(s.set, l)
+	return true
+}
+
+// Remove and return any of the coroutines, nil if none.
+func (s *suspended) pop() *luaState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for l := range s.set {
+		delete(s.set, l)
+		return l
+	}
+	return nil
+}
+
+// sent on coChan instead of resuming the coroutine, to kill it
+const coKill = -1
+
+// what a killed coroutine panics with to unwind its goroutine
+type coKilled struct{}
+
+// Create a new thread sharing the registry (hence the globals) with this one,
+// push it into the luaStack and return it.
+func (l *luaState) NewThread() api.LuaState {
+	t := &luaState{registry: l.registry, sandbox: l.sandbox}
+	t.SetHook(l.GetHook())
+	t.coverage = l.coverage
+	t.pushLuaStack(newLuaStack(api.LUA_MINSTACK, t))
+	h := &thread{t}
+	l.stack.push(h)
+	return h
+}
+
+// Push this thread into its own luaStack, return whether it's the main thread.
+// Only the main thread and running coroutines know their handles.
+func (l *luaState) PushThread() bool {
+	l.stack.push(l.handle)
+	return l.isMainThread()
+}
+
+func (t *thread) PushThread() bool {
+	t.stack.push(t)
+	return t.isMainThread()
+}
+
+// Return the luaState of a thread, which may be given by its handle.
+func toLuaState(ls api.LuaState) *luaState {
+	if t, ok := ls.(*thread); ok {
+		return t.luaState
+	}
+	return ls.(*luaState)
+}
+
+// Pop n values from this thread and push them into thread to.
+func (l *luaState) XMove(to api.LuaState, n int) {
+	vals := l.stack.popN(n)
+	dst := toLuaState(to).stack
+	dst.check(n)
+	dst.pushN(vals, n)
+}
+
+// Resume() the coroutine, which knows its handle while it's running,
+// and let the handle be finalized while it's suspended.
+func (t *thread) Resume(from api.LuaState, nArgs int) int {
+	runtime.SetFinalizer(t, nil)
+	oldHandle := t.handle // set if it's already running
+	t.handle = t
+	status := t.luaState.Resume(from, nArgs)
+	t.handle = oldHandle
+	if status == api.LUA_YIELD {
+		runtime.SetFinalizer(t, (*thread).finalize)
+	}
+	return status
+}
+
+// Kill the coroutine since it can't be resumed any more. It runs on the
+// goroutine of finalizers, but the killed coroutine touches nothing but itself.
+func (t *thread) finalize() {
+	if t.sandbox.suspended.remove(t.luaState) {
+		t.kill()
+	}
+}
+
+// Start or continue this coroutine with nArgs values at the top of its luaStack,
+// which are below the function to run when it's started. Return LUA_YIELD if it
+// yields, LUA_OK if it finishes or an error status with the error object on the top.
+func (l *luaState) Resume(from api.LuaState, nArgs int) int {
+	lsFrom := toLuaState(from)
+	if lsFrom.coChan == nil {
+		lsFrom.coChan = make(chan int)
+	}
+
+	if l.coStatus == api.LUA_OK {
+		if l.coChan != nil || l.stack.prev != nil { // running or has frames?
+			return l.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+
+		l.coCaller = lsFrom
+		l.coChan = make(chan int)
+		go func() {
+			status := api.LUA_ERRRUN
+			defer func() {
+				err := recover()
+				if _, killed := err.(coKilled); killed {
+					ch := l.coChan
+					l.coStatus, l.coCaller, l.coChan = api.LUA_ERRRUN, nil, nil
+					ch <- 1 // tell kill() it's done
+					return
+				}
+				l.coPanic = err // not a lua error, which can't be caught by PCall()
+				// the coroutine is dead, or finished and able to run a new function
+				caller := l.coCaller
+				l.coStatus, l.coCaller, l.coChan = status, nil, nil
+				caller.coChan <- 1
+			}()
+			status = l.PCall(nArgs, api.LUA_MULTRET, 0)
+		}()
+	} else if l.coStatus == api.LUA_YIELD {
+		l.sandbox.suspended.remove(l)
+		l.coStatus = api.LUA_OK
+		l.coCaller = lsFrom
+		l.coChan <- 1
+	} else {
+		return l.resumeError("cannot resume dead coroutine", nArgs)
+	}
+
+	<-lsFrom.coChan // wait until it yields or finishes
+	if err := l.coPanic; err != nil {
+		l.coPanic = nil
+		panic(err)
+	}
+	return l.coStatus
+}
+
+// Replace the arguments with the error message, like a failed resuming does.
+func (l *luaState) resumeError(msg string, nArgs int) int {
+	l.stack.popN(nArgs)
+	l.stack.push(msg)
+	return api.LUA_ERRRUN
+}
+
+// Suspend this coroutine with the top nResults values as the results of Resume(),
+// other values in the current luaStack are discarded. It returns the number of
+// values passed to the next Resume(), which are all values in the luaStack then.
+func (l *luaState) Yield(nResults int) int {
+	if !l.IsYieldable() {
+		l.runError("attempt to yield from outside a coroutine")
+	}
+
+	vals := l.stack.popN(nResults)
+	l.SetTop(0)
+	l.stack.pushN(vals, nResults)
+
+	l.coStatus = api.LUA_YIELD
+	l.sandbox.suspended.add(l)
+	l.coCaller.coChan <- 1
+	if <-l.coChan == coKill { // wait until it's resumed
+		panic(coKilled{})
+	}
+	return l.GetTop()
+}
+
+// Kill the suspended coroutines of the lua state so that their goroutines exit,
+// resuming them fails as they are dead then. It must not be called while
+// a script of the lua state is running on another goroutine.
+func (l *luaState) Close() {
+	for co := l.sandbox.suspended.pop(); co != nil; co = l.sandbox.suspended.pop() {
+		co.kill()
+	}
+}
+
+// Wake the suspended coroutine up to die and wait until its goroutine exits.
+func (l *luaState) kill() {
+	ch := l.coChan
+	ch <- coKill
+	<-ch
+}
+
+func (l *luaState) Status() int {
+	return l.coStatus
+}
+
+// Only a running coroutine can yield.
+func (l *luaState) IsYieldable() bool {
+	return l.coCaller != nil
+}
+
+// Whether the thread has any active function.
+func (l *luaState) GetStack() bool {
+	return l.stack.prev != nil
+}
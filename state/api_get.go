@@ -10,6 +10,7 @@ import (
 // When you know exactly how much space to allocate,
 // avoiding frequent dynamic allocation.
 func (l *luaState) CreateTable(nArr, nRec int) {
+	l.allocate(int64(tableSize + nArr*valueSize + nRec*entrySize))
 	t := newLuaTable(nArr, nRec)
 	l.stack.push(t)
 }
@@ -40,10 +41,8 @@ func (l *luaState) getTable(t, k luaValue, raw bool) LuaType {
 
 	if !raw {
 		if mf := getMetafield(t, "__index", l); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				return l.getTable(x, k, false)
-			case *closure:
+			if _, ok := mf.(*closure); ok {
+				l.stack.check(3)
 				l.stack.push(mf)
 				l.stack.push(t)
 				l.stack.push(k)
@@ -51,10 +50,12 @@ func (l *luaState) getTable(t, k luaValue, raw bool) LuaType {
 				v := l.stack.get(-1)
 				return typeOf(v)
 			}
+			return l.getTable(mf, k, false) // repeat with the metafield
 		}
 	}
 
-	panic("index error !")
+	l.operandError(t, "index")
+	return LUA_TNIL
 }
 
 // Get and push the value who belongs to table whose index
@@ -101,3 +102,36 @@ func (l *luaState) GetMetatable(idx int) bool {
 		return false
 	}
 }
+
+// Push the user value of the full userdata at given index and return its type.
+func (l *luaState) GetUserValue(idx int) LuaType {
+	u, ok := l.stack.get(idx).(*userdata)
+	if !ok {
+		panic("full userdata expected !")
+	}
+
+	l.stack.push(u.uservalue)
+	return typeOf(u.uservalue)
+}
+
+// Push the value of the nth upvalue of the closure at funcIdx, return the
+// name of the upvalue("" if unknown) and true; return false without pushing
+// anything if there is no such upvalue.
+func (l *luaState) GetUpvalue(funcIdx, n int) (string, bool) {
+	c, ok := l.stack.get(funcIdx).(*closure)
+	if !ok || n < 1 || n > len(c.upvals) {
+		return "", false
+	}
+
+	var val luaValue
+	if uv := c.upvals[n-1]; uv != nil {
+		val = *uv.val
+	}
+	l.stack.check(1)
+	l.stack.push(val)
+
+	if c.proto != nil && n <= len(c.proto.UpvalueNames) {
+		return c.proto.UpvalueNames[n-1], true
+	}
+	return "", true
+}
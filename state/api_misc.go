@@ -1,5 +1,10 @@
 package state
 
+import (
+	"github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/number"
+)
+
 // push the length of the string at given index into the luaStack
 func (l *luaState) Len(idx int) {
 	val := l.stack.get(idx)
@@ -10,7 +15,7 @@ func (l *luaState) Len(idx int) {
 	} else if t, ok := val.(*luaTable); ok {
 		l.stack.push(int64(t.len()))
 	} else {
-		panic("length error !")
+		l.operandError(val, "get length of")
 	}
 }
 
@@ -27,7 +32,7 @@ func (l *luaState) Concat(n int) {
 
 				l.stack.pop()
 				l.stack.pop()
-				l.stack.push(s1 + s2)
+				l.PushString(s1 + s2)
 
 				continue
 			}
@@ -39,7 +44,7 @@ func (l *luaState) Concat(n int) {
 				continue
 			}
 
-			panic("concatenation error !")
+			l.concatError(a, b)
 		}
 	}
 	// if n == 1, do nothing
@@ -65,7 +70,39 @@ func (l *luaState) Next(idx int) bool {
 	panic("table expected !")
 }
 
+// Raise an error with the error object on the top of the stack. The message
+// handler of the innermost PCall(), if any, is called here before the stack
+// unwinds, and what it returns becomes the error object.
 func (l *luaState) Error() int {
+	if l.handling { // error in the message handler
+		panic(&api.LuaError{Value: "error in error handling", Status: api.LUA_ERRERR})
+	}
+
+	tb := l.traceback(0)
+	if l.errFunc != nil {
+		l.handling = true
+		l.stack.check(1)
+		l.stack.push(l.errFunc)
+		l.Insert(-2)
+		l.Call(1, 1)
+		l.handling = false
+	}
+
 	err := l.stack.pop()
-	panic(err)
+	panic(&api.LuaError{Value: err, Status: api.LUA_ERRRUN, Traceback: tb})
+}
+
+// Convert the string to number and push it if it's a valid numeral
+// in lua syntax, return whether it succeeds.
+func (l *luaState) StringToNumber(s string) bool {
+	if i, ok := number.ParseInteger(s); ok {
+		l.stack.push(i)
+		return true
+	}
+	if f, ok := number.ParseFloat(s); ok {
+		l.stack.push(f)
+		return true
+	}
+
+	return false
 }
@@ -1,6 +1,8 @@
 package state
 
 import (
+	"fmt"
+
 	"github.com/gonearewe/lua-compiler/api"
 )
 
@@ -21,9 +23,14 @@ func (l *luaState) PushNumber(n float64) {
 }
 
 func (l *luaState) PushString(s string) {
+	l.allocate(int64(stringSize + len(s)))
 	l.stack.push(s)
 }
 
+func (l *luaState) PushFString(format string, a ...interface{}) {
+	l.PushString(fmt.Sprintf(format, a...))
+}
+
 // Wrap f(given,GoFunction) to closure and push it into the stack.
 func (l *luaState) PushGoFunction(f api.GoFunction) {
 	l.stack.push(newGoClosure(f, 0))
@@ -35,12 +42,22 @@ func (l *luaState) PushGoClosure(f api.GoFunction, n int) {
 	closure := newGoClosure(f, n)
 	for i := n; i > 0; i-- {
 		val := l.stack.pop()
-		closure.upvals[n-1] = &upvalue{&val}
+		closure.upvals[i-1] = &upvalue{&val}
 	}
 
 	l.stack.push(closure)
 }
 
+// Push a new full userdata wrapping v, it has no metatable and its user value is nil.
+func (l *luaState) PushUserdata(v interface{}) {
+	l.stack.push(&userdata{data: v})
+}
+
+// Push a light userdata, p must be comparable since it's compared by value.
+func (l *luaState) PushLightUserdata(p interface{}) {
+	l.stack.push(lightUserdata{p})
+}
+
 func (l *luaState) PushGlobalTable() {
 	global := l.registry.get(api.LUA_RIDX_GLOBALS)
 	l.stack.push(global)
@@ -1,6 +1,8 @@
 package state
 
 import (
+	"math"
+
 	"github.com/gonearewe/lua-compiler/api"
 )
 
@@ -17,6 +19,14 @@ func (l *luaState) SetTable(idx int) {
 func (l *luaState) setTable(t, k, v luaValue, raw bool) {
 	if tbl, ok := t.(*luaTable); ok {
 		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
+			if k == nil {
+				l.runError("table index is nil")
+			} else if f, ok := k.(float64); ok && math.IsNaN(f) {
+				l.runError("table index is NaN")
+			}
+			if l.sandbox.limits.Memory > 0 && v != nil && tbl.get(k) == nil {
+				l.allocate(entrySize) // new key
+			}
 			tbl.put(k, v)
 			return
 		}
@@ -24,11 +34,8 @@ func (l *luaState) setTable(t, k, v luaValue, raw bool) {
 
 	if !raw {
 		if mf := getMetafield(t, "__newindex", l); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				l.setTable(x, k, v, false)
-				return
-			case *closure:
+			if _, ok := mf.(*closure); ok {
+				l.stack.check(4)
 				l.stack.push(mf)
 				l.stack.push(t)
 				l.stack.push(k)
@@ -36,10 +43,12 @@ func (l *luaState) setTable(t, k, v luaValue, raw bool) {
 				l.Call(3, 0)
 				return
 			}
+			l.setTable(mf, k, v, false) // repeat with the metafield
+			return
 		}
 	}
 
-	panic("index error !")
+	l.operandError(t, "index")
 }
 
 // Pop value and match it to key that is given by k(string),
@@ -99,3 +108,35 @@ func (l *luaState) SetMetatable(idx int) {
 		panic("table expected !") // TODO
 	}
 }
+
+// Pop a value and set it as the user value of the full userdata at given index.
+func (l *luaState) SetUserValue(idx int) {
+	u, ok := l.stack.get(idx).(*userdata)
+	if !ok {
+		panic("full userdata expected !")
+	}
+
+	u.uservalue = l.stack.pop()
+}
+
+// Pop a value and set it as the nth upvalue of the closure at funcIdx,
+// return the name of the upvalue("" if unknown) and true;
+// return false without popping anything if there is no such upvalue.
+func (l *luaState) SetUpvalue(funcIdx, n int) (string, bool) {
+	c, ok := l.stack.get(funcIdx).(*closure)
+	if !ok || n < 1 || n > len(c.upvals) {
+		return "", false
+	}
+
+	val := l.stack.pop()
+	if c.upvals[n-1] == nil {
+		c.upvals[n-1] = &upvalue{&val}
+	} else {
+		*c.upvals[n-1].val = val
+	}
+
+	if c.proto != nil && n <= len(c.proto.UpvalueNames) {
+		return c.proto.UpvalueNames[n-1], true
+	}
+	return "", true
+}
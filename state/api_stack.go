@@ -1,5 +1,7 @@
 package state
 
+import "github.com/gonearewe/lua-compiler/api"
+
 func (l *luaState) GetTop() int {
 	return l.stack.top
 }
@@ -9,6 +11,9 @@ func (l *luaState) AbsIndex(idx int) int {
 }
 
 func (l *luaState) CheckStack(n int) bool {
+	if free := len(l.stack.slots) - l.stack.top; n > free && l.nSlots+n-free > api.LUAI_MAXSTACK {
+		return false
+	}
 	l.stack.check(n)
 
 	return true
@@ -82,3 +82,7 @@ func (l *luaState) CloseUpvalues(a int) {
 		}
 	}
 }
+
+func (l *luaState) RunError(format string, a ...interface{}) {
+	l.runError(format, a...)
+}
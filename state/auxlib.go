@@ -0,0 +1,407 @@
+/*
+Implementation of the auxiliary library, which offers
+helper functions built on top of the basic API.
+*/
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	. "github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/number"
+)
+
+/**************************
+error-report functions
+**************************/
+
+// Raise an error with the formatted message, which is prefixed with
+// the position where the Go function that called it is called.
+func (l *luaState) Errorf(format string, a ...interface{}) int {
+	l.Where(1)
+	l.PushFString(format, a...)
+	l.Concat(2)
+	return l.Error()
+}
+
+// Raise an error reporting a problem with argument arg of the Go function that called it.
+func (l *luaState) ArgError(arg int, extraMsg string) int {
+	s := l.stackAt(0)
+	if s == nil { // no stack frame
+		return l.Errorf("bad argument #%d (%s)", arg, extraMsg)
+	}
+
+	namewhat, name := funcNameFromCall(s)
+	if namewhat == "method" {
+		arg--         // do not count `self`
+		if arg == 0 { // error is in the self argument itself
+			return l.Errorf("calling '%s' on bad self (%s)", name, extraMsg)
+		}
+	}
+	if name == "" {
+		if name = l.globalFuncName(s); name == "" {
+			name = "?"
+		}
+	}
+
+	return l.Errorf("bad argument #%d to '%s' (%s)", arg, name, extraMsg)
+}
+
+// Push the position like "test.lua:3: " where the function at given level is
+// running, level 0 is the running Go function; push "" if it's unknown.
+func (l *luaState) Where(level int) {
+	l.stack.check(1)
+	if s := l.stackAt(level); s != nil {
+		l.stack.push(where(s))
+	} else {
+		l.stack.push("")
+	}
+}
+
+// Push the traceback of the call stack of thread l1 from given level,
+// msg is put before the traceback unless it's "".
+func (l *luaState) Traceback(l1 LuaState, msg string, level int) {
+	tb := toLuaState(l1).traceback(level)
+	if msg != "" {
+		tb = msg + "\n" + tb
+	}
+
+	l.stack.check(1)
+	l.stack.push(tb)
+}
+
+// Raise an error reporting that the type of argument arg is not the expected one.
+func (l *luaState) typeError(arg int, expected string) int {
+	var actual string
+	if l.GetMetafield(arg, "__name") == LUA_TSTRING {
+		actual = l.ToString(-1)
+	} else {
+		actual = l.TypeNameOf(arg)
+	}
+
+	return l.ArgError(arg, fmt.Sprintf("%s expected, got %s", expected, actual))
+}
+
+/**************************
+argument check functions, arguments are counted from 1
+**************************/
+
+func (l *luaState) ArgCheck(cond bool, arg int, extraMsg string) {
+	if !cond {
+		l.ArgError(arg, extraMsg)
+	}
+}
+
+func (l *luaState) CheckAny(arg int) {
+	if l.Type(arg) == LUA_TNONE {
+		l.ArgError(arg, "value expected")
+	}
+}
+
+func (l *luaState) CheckType(arg int, t LuaType) {
+	if l.Type(arg) != t {
+		l.typeError(arg, l.TypeName(t))
+	}
+}
+
+func (l *luaState) CheckInteger(arg int) int64 {
+	i, ok := l.ToIntegerX(arg)
+	if !ok {
+		if l.IsNumber(arg) {
+			l.ArgError(arg, "number has no integer representation")
+		} else {
+			l.typeError(arg, l.TypeName(LUA_TNUMBER))
+		}
+	}
+
+	return i
+}
+
+func (l *luaState) CheckNumber(arg int) float64 {
+	f, ok := l.ToNumberX(arg)
+	if !ok {
+		l.typeError(arg, l.TypeName(LUA_TNUMBER))
+	}
+
+	return f
+}
+
+// Numbers are accepted and converted to strings in place.
+func (l *luaState) CheckString(arg int) string {
+	s, ok := l.ToStringX(arg)
+	if !ok {
+		l.typeError(arg, l.TypeName(LUA_TSTRING))
+	}
+
+	return s
+}
+
+// following functions return d if the argument is absent or nil
+
+func (l *luaState) OptInteger(arg int, d int64) int64 {
+	if l.IsNoneOrNil(arg) {
+		return d
+	}
+	return l.CheckInteger(arg)
+}
+
+func (l *luaState) OptNumber(arg int, d float64) float64 {
+	if l.IsNoneOrNil(arg) {
+		return d
+	}
+	return l.CheckNumber(arg)
+}
+
+func (l *luaState) OptString(arg int, d string) string {
+	if l.IsNoneOrNil(arg) {
+		return d
+	}
+	return l.CheckString(arg)
+}
+
+/**************************
+load functions, they push the loaded function or the error message
+and return the status just like Load()
+**************************/
+
+func (l *luaState) LoadFile(filename string) int {
+	return l.LoadFileX(filename, "bt")
+}
+
+// Load the file as a chunk, stdin is read if filename is "".
+func (l *luaState) LoadFileX(filename, mode string) int {
+	var data []byte
+	var err error
+	chunkName := "@" + filename
+	if filename == "" {
+		chunkName = "=stdin"
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		l.PushFString("cannot open %s", chunkName[1:])
+		return LUA_ERRFILE
+	}
+
+	return l.Load(data, chunkName, mode)
+}
+
+// Load the string as a chunk which is also named after it.
+func (l *luaState) LoadString(s string) int {
+	return l.Load([]byte(s), s, "bt")
+}
+
+/**************************
+other functions
+**************************/
+
+func (l *luaState) TypeNameOf(idx int) string {
+	return l.TypeName(l.Type(idx))
+}
+
+// Convert the value at given index to a string in a reasonable format,
+// push the result and return it; metafield "__tostring" and "__name" are honored.
+func (l *luaState) ToStringMeta(idx int) string {
+	idx = l.AbsIndex(idx)
+	if l.CallMeta(idx, "__tostring") {
+		if !l.IsString(-1) {
+			l.Errorf("'__tostring' must return a string")
+		}
+	} else {
+		switch l.Type(idx) {
+		case LUA_TNUMBER:
+			if l.IsInteger(idx) {
+				l.PushFString("%d", l.ToInteger(idx))
+			} else {
+				l.PushString(number.FloatToString(l.ToNumber(idx)))
+			}
+		case LUA_TSTRING:
+			l.PushValue(idx)
+		case LUA_TBOOLEAN:
+			l.PushFString("%t", l.ToBoolean(idx))
+		case LUA_TNIL:
+			l.PushString("nil")
+		default:
+			tt := l.GetMetafield(idx, "__name")
+			kind := l.TypeNameOf(idx)
+			if tt == LUA_TSTRING {
+				kind = l.ToString(-1)
+			}
+			l.PushFString("%s: %p", kind, l.ToPointer(idx))
+			if tt != LUA_TNIL {
+				l.Remove(-2) // remove '__name'
+			}
+		}
+	}
+
+	return l.ToString(-1)
+}
+
+// Return the length of the value at given index as an integer, "__len" is honored.
+func (l *luaState) LenInt(idx int) int64 {
+	l.Len(idx)
+	i, ok := l.ToIntegerX(-1)
+	if !ok {
+		l.Errorf("object length is not an integer")
+	}
+	l.Pop(1)
+
+	return i
+}
+
+// Push t[fname] where t is the value at given index and return true if it's a table,
+// otherwise create a new table for it, push the new one and return false.
+func (l *luaState) GetSubTable(idx int, fname string) bool {
+	if l.GetField(idx, fname) == LUA_TTABLE {
+		return true
+	}
+
+	l.Pop(1)
+	idx = l.AbsIndex(idx)
+	l.NewTable()
+	l.PushValue(-1)
+	l.SetField(idx, fname)
+
+	return false
+}
+
+// Push field e of the metatable of the value at index obj and return its type,
+// return LUA_TNIL without pushing anything if there is no such field.
+func (l *luaState) GetMetafield(obj int, e string) LuaType {
+	if !l.GetMetatable(obj) {
+		return LUA_TNIL
+	}
+
+	l.PushString(e)
+	tt := l.RawGet(-2)
+	if tt == LUA_TNIL {
+		l.Pop(2) // remove metatable and metafield
+	} else {
+		l.Remove(-2) // remove only metatable
+	}
+
+	return tt
+}
+
+// Call the metamethod e of the value at index obj with the value as the only argument,
+// push its result and return true; return false if there is no such metamethod.
+func (l *luaState) CallMeta(obj int, e string) bool {
+	obj = l.AbsIndex(obj)
+	if l.GetMetafield(obj, e) == LUA_TNIL {
+		return false
+	}
+
+	l.PushValue(obj)
+	l.Call(1, 1)
+
+	return true
+}
+
+// Call openf with modname to open the module unless it's already
+// in the loaded table, and push the module; set it as a global variable too if glb.
+func (l *luaState) RequireF(modname string, openf GoFunction, glb bool) {
+	l.GetSubTable(LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	l.GetField(-1, modname) // LOADED[modname]
+	if !l.ToBoolean(-1) {   // not loaded yet
+		l.Pop(1)
+		l.PushGoFunction(openf)
+		l.PushString(modname)
+		l.Call(1, 1)
+		l.PushValue(-1)
+		l.SetField(-3, modname) // LOADED[modname] = module
+	}
+	l.Remove(-2) // remove LOADED table
+
+	if glb {
+		l.PushValue(-1)
+		l.SetGlobal(modname)
+	}
+}
+
+// Create a new table and register the functions of the list in it.
+func (l *luaState) NewLib(funcs FuncReg) {
+	l.NewLibTable(funcs)
+	l.SetFuncs(funcs, 0)
+}
+
+// Create a new table with a size suitable for the list.
+func (l *luaState) NewLibTable(funcs FuncReg) {
+	l.CreateTable(0, len(funcs))
+}
+
+// Register the functions of the list in the table below nup upvalues
+// on the top of the stack, all functions share these upvalues, which are popped at last.
+func (l *luaState) SetFuncs(funcs FuncReg, nup int) {
+	l.CheckStack(nup)
+	for name, f := range funcs {
+		for i := 0; i < nup; i++ {
+			l.PushValue(-nup)
+		}
+		l.PushGoClosure(f, nup)
+		l.SetField(-(nup + 2), name)
+	}
+	l.Pop(nup)
+}
+
+/**************************
+userdata functions, metatables of userdata types
+are kept in the registry with their names as keys
+**************************/
+
+// Create a metatable with field "__name" for userdata of type tname and keep it
+// in the registry, return false if there is one already; push the metatable anyway.
+func (l *luaState) NewMetatable(tname string) bool {
+	if l.GetNamedMetatable(tname) != LUA_TNIL {
+		return false // leave previous value on top
+	}
+
+	l.Pop(1)
+	l.CreateTable(0, 2)
+	l.PushString(tname)
+	l.SetField(-2, "__name") // metatable.__name = tname
+	l.PushValue(-1)
+	l.SetField(LUA_REGISTRYINDEX, tname) // registry.tname = metatable
+	return true
+}
+
+// Push the metatable of userdata type tname (nil if there is none).
+func (l *luaState) GetNamedMetatable(tname string) LuaType {
+	return l.GetField(LUA_REGISTRYINDEX, tname)
+}
+
+// Set the metatable of userdata type tname as the metatable of the value on the top.
+func (l *luaState) SetNamedMetatable(tname string) {
+	l.GetNamedMetatable(tname)
+	l.SetMetatable(-2)
+}
+
+// Return the Go value of the userdata at index ud if its metatable is
+// the one of userdata type tname, otherwise return nil.
+func (l *luaState) TestUdata(ud int, tname string) interface{} {
+	if !l.isUdataOf(ud, tname) {
+		return nil
+	}
+	return l.ToUserdata(ud)
+}
+
+// Like TestUdata() but raise an error if it isn't a userdata of type tname.
+func (l *luaState) CheckUdata(ud int, tname string) interface{} {
+	if !l.isUdataOf(ud, tname) {
+		l.typeError(ud, tname)
+	}
+	return l.ToUserdata(ud)
+}
+
+func (l *luaState) isUdataOf(ud int, tname string) bool {
+	if !l.IsUserdata(ud) || !l.GetMetatable(ud) {
+		return false
+	}
+
+	l.GetNamedMetatable(tname)
+	ok := l.RawEqual(-1, -2)
+	l.Pop(2) // remove both metatables
+	return ok
+}
@@ -0,0 +1,10 @@
+package state
+
+import "github.com/gonearewe/lua-compiler/coverage"
+
+// Record the coverage of the chunks loaded from now on into c, stop
+// recording if it's nil. Coroutines created afterwards record into c too.
+// It isn't part of api.LuaState, which knows nothing of the tools.
+func (l *luaState) SetCoverage(c *coverage.Coverage) {
+	l.coverage = c
+}
@@ -0,0 +1,389 @@
+/*
+Debug information of the functions in the call stack, which is a linked list
+of luaStack. The running function is at level 0, the one that called it is at
+level 1 and so on; the bottom luaStack runs nothing and has no level.
+*/
+package state
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/binchunk"
+	"github.com/gonearewe/lua-compiler/compiler/lexer"
+	"github.com/gonearewe/lua-compiler/vm"
+)
+
+// Traceback shows the first levels1 and the last levels2 levels of a deep call stack.
+const (
+	levels1 = 10
+	levels2 = 11
+)
+
+// Return the luaStack of the function at given level, nil if there is no such level.
+func (l *luaState) stackAt(level int) *luaStack {
+	if level < 0 {
+		return nil
+	}
+
+	s := l.stack
+	for ; level > 0 && s.prev != nil; level-- {
+		s = s.prev
+	}
+	if s.prev == nil {
+		return nil
+	}
+
+	return s
+}
+
+// Return the number of levels in the call stack.
+func (l *luaState) stackDepth() int {
+	n := 0
+	for s := l.stack; s.prev != nil; s = s.prev {
+		n++
+	}
+
+	return n
+}
+
+func isLua(s *luaStack) bool {
+	return s.closure != nil && s.closure.proto != nil
+}
+
+// Return the line of the running instruction of a lua function, -1 if unknown.
+func currentLine(s *luaStack) int {
+	if !isLua(s) {
+		return -1
+	}
+
+	pc := s.pc - 1
+	if pc < 0 {
+		pc = 0
+	}
+
+	return lineAt(s.closure.proto, pc)
+}
+
+// Return the line of the instruction at pc, -1 if unknown.
+func lineAt(p *binchunk.Prototype, pc int) int {
+	if pc < 0 || pc >= len(p.LineInfo) { // stripped
+		return -1
+	}
+
+	return int(p.LineInfo[pc])
+}
+
+// Return the printable source of the function, "[C]" for go functions.
+func shortSrc(s *luaStack) string {
+	if !isLua(s) {
+		return "[C]"
+	}
+	if src := s.closure.proto.Source; src != "" {
+		return lexer.ChunkID(src)
+	}
+
+	return "?" // stripped
+}
+
+// Return the position like "test.lua:3: " of the running instruction
+// if the function is a lua one, otherwise "".
+func where(s *luaStack) string {
+	if line := currentLine(s); line > 0 {
+		return fmt.Sprintf("%s:%d: ", shortSrc(s), line)
+	}
+
+	return ""
+}
+
+// Return how the function is named by the instruction calling it, like
+// ("global", "print") or ("method", "insert"), or ("hook", "?") if it's called
+// by the hook; "" if it's called by a go function or by a tail call, after
+// which the calling instruction is gone.
+func funcNameFromCall(s *luaStack) (namewhat, name string) {
+	caller := s.prev
+	if caller != nil && caller.hooked {
+		return "hook", "?"
+	}
+	if s.isTailCall || caller == nil || !isLua(caller) || caller.pc < 1 {
+		return "", ""
+	}
+
+	p := caller.closure.proto
+	pc := caller.pc - 1 // the calling instruction
+	i := vm.Instruction(p.Code[pc])
+	switch i.Opcode() {
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		a, _, _ := i.ABC()
+		return getObjName(p, pc, a)
+	case vm.OP_TFORCALL:
+		return "for iterator", "for iterator"
+	}
+
+	// called as a metamethod by the instruction
+	var event string
+	switch i.Opcode() {
+	case vm.OP_SELF, vm.OP_GETTABUP, vm.OP_GETTABLE:
+		event = "index"
+	case vm.OP_SETTABUP, vm.OP_SETTABLE:
+		event = "newindex"
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV, vm.OP_IDIV,
+		vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR, vm.OP_UNM, vm.OP_BNOT:
+		event = strings.ToLower(strings.TrimSpace(i.OpName()))
+	case vm.OP_LEN:
+		event = "len"
+	case vm.OP_CONCAT:
+		event = "concat"
+	case vm.OP_EQ:
+		event = "eq"
+	case vm.OP_LT:
+		event = "lt"
+	case vm.OP_LE:
+		event = "le"
+	default:
+		return "", ""
+	}
+
+	return "metamethod", event
+}
+
+// Return the name of the function like "print" or "string.format" if it's
+// a field of a loaded module, "" if not found.
+func (l *luaState) globalFuncName(s *luaStack) string {
+	loaded, ok := l.registry.get(api.LUA_LOADED_TABLE).(*luaTable)
+	if !ok || s.closure == nil {
+		return ""
+	}
+
+	for modName, mod := range loaded._map {
+		if mt, ok := mod.(*luaTable); ok {
+			for k, v := range mt._map {
+				if name, ok := k.(string); ok && v == s.closure {
+					if modName == "_G" {
+						return name
+					}
+					return fmt.Sprintf("%s.%s", modName, name)
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// Return the description of the function like "function 'print'",
+// "local 'f'", "main chunk" or "function <test.lua:12>".
+func (l *luaState) funcDescription(s *luaStack) string {
+	if name := l.globalFuncName(s); name != "" {
+		return fmt.Sprintf("function '%s'", name)
+	}
+	if namewhat, name := funcNameFromCall(s); namewhat != "" {
+		return fmt.Sprintf("%s '%s'", namewhat, name)
+	}
+	if !isLua(s) {
+		return "?"
+	}
+	if p := s.closure.proto; p.LineDefined == 0 {
+		return "main chunk"
+	} else {
+		return fmt.Sprintf("function <%s:%d>", shortSrc(s), p.LineDefined)
+	}
+}
+
+// Return the traceback of the call stack from given level,
+// the innermost function first.
+func (l *luaState) traceback(level int) string {
+	var b strings.Builder
+	b.WriteString("stack traceback:")
+
+	last := l.stackDepth() - 1
+	n1 := -1 // number of levels before "...", -1 means all
+	if last-level > levels1+levels2 {
+		n1 = levels1
+	}
+
+	for s := l.stackAt(level); s != nil; s = l.stackAt(level) {
+		level++
+		if n1 == 0 { // too many levels
+			b.WriteString("\n\t...")
+			level = last - levels2 + 1 // skip to the last ones
+			n1--
+			continue
+		}
+		n1--
+
+		fmt.Fprintf(&b, "\n\t%s:", shortSrc(s))
+		if line := currentLine(s); line > 0 {
+			fmt.Fprintf(&b, "%d:", line)
+		}
+		b.WriteString(" in ")
+		b.WriteString(l.funcDescription(s))
+		if s.isTailCall {
+			b.WriteString("\n\t(...tail calls...)")
+		}
+	}
+
+	return b.String()
+}
+
+// Fill ar with the information about the function at given level selected by
+// what, which consists of 'n', 'S', 'l', 'u' and 't' (see api.Debug); besides,
+// 'f' pushes the function and 'L' pushes a table whose keys are the lines with
+// code in it. If what starts with '>', the function is popped from the stack
+// instead and level is ignored. Return false if there is no such level.
+func (l *luaState) GetInfo(level int, what string, ar *api.Debug) bool {
+	var s *luaStack // nil if the function isn't running
+	var c *closure
+	if strings.HasPrefix(what, ">") {
+		what = what[1:]
+		var ok bool
+		if c, ok = l.stack.pop().(*closure); !ok {
+			panic("function expected !")
+		}
+	} else {
+		if s = l.stackAt(level); s == nil {
+			return false
+		}
+		c = s.closure
+	}
+
+	for _, option := range what {
+		switch option {
+		case 'n':
+			ar.NameWhat, ar.Name = "", ""
+			if s != nil {
+				ar.NameWhat, ar.Name = funcNameFromCall(s)
+			}
+		case 'S':
+			funcSource(c, ar)
+		case 'l':
+			ar.CurrentLine = -1
+			if s != nil {
+				ar.CurrentLine = currentLine(s)
+			}
+		case 'u':
+			ar.NUps = len(c.upvals)
+			if c.proto == nil {
+				ar.NParams, ar.IsVararg = 0, true
+			} else {
+				ar.NParams, ar.IsVararg = int(c.proto.NumParams), c.proto.IsVararg == 1
+			}
+		case 't':
+			ar.IsTailCall = s != nil && s.isTailCall
+		}
+	}
+
+	if strings.ContainsRune(what, 'f') {
+		l.stack.check(1)
+		l.stack.push(c)
+	}
+	if strings.ContainsRune(what, 'L') {
+		l.stack.check(1)
+		l.stack.push(activeLines(c))
+	}
+
+	return true
+}
+
+// Fill the 'S' fields of ar.
+func funcSource(c *closure, ar *api.Debug) {
+	if c.proto == nil {
+		ar.Source, ar.ShortSrc, ar.What = "=[C]", "[C]", "C"
+		ar.LineDefined, ar.LastLineDefined = -1, -1
+		return
+	}
+
+	p := c.proto
+	ar.Source = p.Source
+	if ar.Source == "" { // stripped
+		ar.Source = "=?"
+	}
+	ar.ShortSrc = lexer.ChunkID(ar.Source)
+	ar.LineDefined, ar.LastLineDefined = int(p.LineDefined), int(p.LastLineDefined)
+	if ar.LineDefined == 0 {
+		ar.What = "main"
+	} else {
+		ar.What = "Lua"
+	}
+}
+
+// Return a table whose keys are the lines with code of the function,
+// nil for go functions.
+func activeLines(c *closure) luaValue {
+	if c.proto == nil {
+		return nil
+	}
+
+	t := newLuaTable(0, len(c.proto.LineInfo))
+	for _, line := range c.proto.LineInfo {
+		t.put(int64(line), true)
+	}
+	return t
+}
+
+// Return the name of the nth local variable of the function and its slot;
+// "" and nil if there is no such variable. Varargs of a lua function are
+// numbered by negative n, and registers that are not named locals (or slots
+// of a go function) are temporaries.
+func findLocal(s *luaStack, n int) (string, *luaValue) {
+	if n < 0 {
+		if isLua(s) && -n <= len(s.varargs) {
+			return "(*vararg)", &s.varargs[-n-1]
+		}
+		return "", nil
+	}
+
+	if isLua(s) {
+		if name := localName(s.closure.proto, n, s.pc-1); name != "" {
+			return name, &s.slots[n-1]
+		}
+	}
+	if n > 0 && n <= s.top {
+		return "(*temporary)", &s.slots[n-1]
+	}
+
+	return "", nil
+}
+
+// Push the value of the nth local variable of the function at given level and
+// return its name; return "" and push nothing if there is no such variable.
+// Negative n means the varargs of a lua function. If level is negative, the
+// function on the top of the stack is inspected instead, whose parameters are
+// the only locals known since it isn't running, and nothing is pushed.
+func (l *luaState) GetLocal(level, n int) string {
+	if level < 0 {
+		if c, ok := l.stack.get(-1).(*closure); ok && c.proto != nil {
+			return localName(c.proto, n, 0)
+		}
+		return ""
+	}
+
+	s := l.stackAt(level)
+	if s == nil {
+		return ""
+	}
+
+	l.stack.check(1) // before the slot is found, in case the slots are moved
+	name, slot := findLocal(s, n)
+	if slot != nil {
+		l.stack.push(*slot)
+	}
+	return name
+}
+
+// Pop a value and assign it to the nth local variable of the function at given
+// level, return the name of the variable; return "" and pop nothing if there
+// is no such variable.
+func (l *luaState) SetLocal(level, n int) string {
+	s := l.stackAt(level)
+	if s == nil {
+		return ""
+	}
+
+	name, slot := findLocal(s, n)
+	if slot != nil {
+		*slot = l.stack.pop()
+	}
+	return name
+}
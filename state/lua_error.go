@@ -0,0 +1,280 @@
+/*
+Runtime errors of the VM, their messages follow the reference implementation
+and tell where the faulty value comes from whenever the running lua function
+makes it possible, like "attempt to index a nil value (global 'x')".
+*/
+package state
+
+import (
+	"fmt"
+
+	"github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/binchunk"
+	"github.com/gonearewe/lua-compiler/vm"
+)
+
+// Raise an error with the formatted message, which is prefixed with
+// the position of the running instruction if it's in a lua function.
+func (l *luaState) runError(format string, a ...interface{}) {
+	l.stack.check(1)
+	l.PushFString("%s%s", where(l.stack), fmt.Sprintf(format, a...))
+	l.Error()
+}
+
+// Raise an error about the operation on the value of a wrong type,
+// op is like "index" or "perform arithmetic on".
+func (l *luaState) operandError(val luaValue, op string) {
+	l.runError("attempt to %s a %s value%s", op, l.objTypeName(val), l.varInfo(val))
+}
+
+// Raise an error about the arithmetic or bitwise operation on a and b,
+// the faulty one is b unless a isn't a number.
+func (l *luaState) opIntError(a, b luaValue, op string) {
+	if _, ok := convertToFloat(a); !ok {
+		b = a
+	}
+	l.operandError(b, op)
+}
+
+// Raise an error about the bitwise operation on numbers without
+// integer representations, the faulty one is b unless it's a.
+func (l *luaState) toIntError(a, b luaValue) {
+	if _, ok := convertToInteger(a); !ok {
+		b = a
+	}
+	l.runError("number%s has no integer representation", l.varInfo(b))
+}
+
+// Raise an error about the concatenation of a and b, the faulty
+// one is a unless it's a string or a number.
+func (l *luaState) concatError(a, b luaValue) {
+	switch a.(type) {
+	case string, int64, float64:
+		a = b
+	}
+	l.operandError(a, "concatenate")
+}
+
+// Raise an error about the comparison of a and b.
+func (l *luaState) orderError(a, b luaValue) {
+	t1, t2 := l.objTypeName(a), l.objTypeName(b)
+	if t1 == t2 {
+		l.runError("attempt to compare two %s values", t1)
+	} else {
+		l.runError("attempt to compare %s with %s", t1, t2)
+	}
+}
+
+// Return the type name of the value, "__name" of its metatable is honored.
+func (l *luaState) objTypeName(val luaValue) string {
+	if mt := getMetatable(val, l); mt != nil {
+		if name, ok := mt.get("__name").(string); ok {
+			return name
+		}
+	}
+	return l.TypeName(typeOf(val))
+}
+
+/**************************
+variable information, found by analyzing the
+instruction that's running in current lua function
+**************************/
+
+// Return a description like " (local 'x')" for the value if it's one of the
+// operands of the running instruction, "" if nothing can be told.
+func (l *luaState) varInfo(val luaValue) string {
+	c := l.stack.closure
+	if c == nil || c.proto == nil || l.stack.pc < 1 { // not a lua function
+		return ""
+	}
+
+	p := c.proto
+	pc := l.stack.pc - 1 // the running instruction
+	i := vm.Instruction(p.Code[pc])
+	a, b, cc := i.ABC()
+
+	isUpval := func(idx int) bool {
+		return idx < len(c.upvals) && *c.upvals[idx].val == val
+	}
+	isReg := func(reg int) bool {
+		return reg < l.stack.top && l.stack.slots[reg] == val
+	}
+
+	var kind, name string
+	switch i.Opcode() {
+	case vm.OP_GETTABUP:
+		if isUpval(b) {
+			kind, name = "upvalue", upvalName(p, b)
+		}
+	case vm.OP_SETTABUP:
+		if isUpval(a) {
+			kind, name = "upvalue", upvalName(p, a)
+		}
+	case vm.OP_GETTABLE, vm.OP_SELF, vm.OP_UNM, vm.OP_BNOT, vm.OP_LEN:
+		if isReg(b) {
+			kind, name = getObjName(p, pc, b)
+		}
+	case vm.OP_SETTABLE, vm.OP_CALL, vm.OP_TAILCALL:
+		if isReg(a) {
+			kind, name = getObjName(p, pc, a)
+		}
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD, vm.OP_POW, vm.OP_DIV,
+		vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		if b <= 0xff && isReg(b) {
+			kind, name = getObjName(p, pc, b)
+		} else if cc <= 0xff && isReg(cc) {
+			kind, name = getObjName(p, pc, cc)
+		}
+	case vm.OP_CONCAT:
+		for reg := b; reg <= cc; reg++ {
+			if isReg(reg) {
+				kind, name = getObjName(p, pc, reg)
+				break
+			}
+		}
+	}
+
+	if kind == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s '%s')", kind, name)
+}
+
+// Return the kind and the name of the value in register reg at lastPC.
+func getObjName(p *binchunk.Prototype, lastPC, reg int) (kind, name string) {
+	if name = localName(p, reg+1, lastPC); name != "" {
+		return "local", name
+	}
+
+	// try symbolic execution
+	pc := findSetReg(p, lastPC, reg)
+	if pc == -1 { // could not find instruction
+		return "", ""
+	}
+
+	i := vm.Instruction(p.Code[pc])
+	switch i.Opcode() {
+	case vm.OP_MOVE:
+		a, b, _ := i.ABC()
+		if b < a { // move from b to a
+			return getObjName(p, pc, b)
+		}
+	case vm.OP_GETTABUP, vm.OP_GETTABLE:
+		_, t, k := i.ABC()
+		var vn string // name of indexed variable
+		if i.Opcode() == vm.OP_GETTABLE {
+			_, vn = getObjName(p, pc, t)
+		} else {
+			vn = upvalName(p, t)
+		}
+		if vn == "_ENV" {
+			return "global", constName(p, pc, k)
+		}
+		return "field", constName(p, pc, k)
+	case vm.OP_GETUPVAL:
+		_, b, _ := i.ABC()
+		return "upvalue", upvalName(p, b)
+	case vm.OP_LOADK, vm.OP_LOADKX:
+		_, bx := i.ABx()
+		if i.Opcode() == vm.OP_LOADKX {
+			bx = vm.Instruction(p.Code[pc+1]).Ax()
+		}
+		if s, ok := p.Constants[bx].(string); ok {
+			return "constant", s
+		}
+	case vm.OP_SELF:
+		_, _, k := i.ABC()
+		return "method", constName(p, pc, k)
+	}
+
+	return "", "" // could not find reasonable name
+}
+
+// Return the name of RK(c) if it's a string constant, otherwise "?".
+func constName(p *binchunk.Prototype, pc, c int) string {
+	if c > 0xff { // is c a constant?
+		if s, ok := p.Constants[c&0xff].(string); ok {
+			return s
+		}
+	} else if kind, name := getObjName(p, pc, c); kind == "constant" {
+		return name
+	}
+	return "?"
+}
+
+// Return the pc of the last instruction before lastPC that changes
+// register reg, -1 if it's unknown since the code may be skipped.
+func findSetReg(p *binchunk.Prototype, lastPC, reg int) int {
+	setReg := -1   // keep last instruction that changed reg
+	jmpTarget := 0 // any code before this address is conditional
+	filterPC := func(pc int) int {
+		if pc < jmpTarget { // is code conditional (inside a jump)?
+			return -1 // cannot know who sets that register
+		}
+		return pc
+	}
+
+	for pc := 0; pc < lastPC; pc++ {
+		i := vm.Instruction(p.Code[pc])
+		a, b, _ := i.ABC()
+		switch i.Opcode() {
+		case vm.OP_LOADNIL:
+			if a <= reg && reg <= a+b { // set registers from a to a+b
+				setReg = filterPC(pc)
+			}
+		case vm.OP_TFORCALL:
+			if reg >= a+2 { // affect all regs above its base
+				setReg = filterPC(pc)
+			}
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			if reg >= a { // affect all registers above base
+				setReg = filterPC(pc)
+			}
+		case vm.OP_JMP:
+			_, sBx := i.AsBx()
+			dest := pc + 1 + sBx
+			// jump is forward and do not skip lastPC?
+			if pc < dest && dest <= lastPC && dest > jmpTarget {
+				jmpTarget = dest
+			}
+		default:
+			if i.TestAMode() && reg == a { // any instruction that sets A
+				setReg = filterPC(pc)
+			}
+		}
+	}
+
+	return setReg
+}
+
+// Return the name of the nth(starting from 1) local variable active at pc,
+// "" if it's unknown.
+func localName(p *binchunk.Prototype, n, pc int) string {
+	for _, v := range p.LocVars {
+		if int(v.StartPC) > pc {
+			break
+		}
+		if pc < int(v.EndPC) { // is variable active?
+			if n--; n == 0 {
+				return v.VarName
+			}
+		}
+	}
+	return ""
+}
+
+func upvalName(p *binchunk.Prototype, idx int) string {
+	if idx < len(p.UpvalueNames) {
+		return p.UpvalueNames[idx]
+	}
+	return "?"
+}
+
+// Make sure that the value recovered from panicking is a lua error,
+// Go panics of other kinds are bugs to be reported where they happen.
+func toLuaError(err interface{}) *api.LuaError {
+	if luaErr, ok := err.(*api.LuaError); ok {
+		return luaErr
+	}
+	panic(err)
+}
@@ -0,0 +1,78 @@
+package state
+
+import "github.com/gonearewe/lua-compiler/api"
+
+// Set the debug hook of this thread, which is called on the events in mask;
+// with LUA_MASKCOUNT it's called every count instructions. A nil f or a zero
+// mask turns the hook off.
+func (l *luaState) SetHook(f api.Hook, mask, count int) {
+	if f == nil || mask == 0 {
+		f, mask = nil, 0
+	}
+	if count <= 0 {
+		mask &^= api.LUA_MASKCOUNT
+		count = 0
+	}
+
+	l.hook, l.hookMask = f, mask
+	l.baseHookCount, l.hookCount = count, count
+}
+
+// Return the debug hook of this thread, its mask and count.
+func (l *luaState) GetHook() (api.Hook, int, int) {
+	return l.hook, l.hookMask, l.baseHookCount
+}
+
+// Call the hook for the event, line is the new line for LUA_HOOKLINE
+// and -1 for others. Values pushed by the hook are removed then.
+func (l *luaState) callHook(event, line int) {
+	if l.hook == nil || l.inHook {
+		return
+	}
+
+	s := l.stack
+	top := s.top
+	s.check(api.LUA_MINSTACK)
+	l.inHook, s.hooked = true, true
+	l.hook(l, &api.Debug{Event: event, CurrentLine: line})
+	l.inHook, s.hooked = false, false
+	l.SetTop(top)
+}
+
+// Call the hook for a function that is just called,
+// whose luaStack is on the top.
+func (l *luaState) hookCall(event int) {
+	if l.hookMask&api.LUA_MASKCALL != 0 {
+		l.callHook(event, -1)
+	}
+}
+
+// Call the hook for a function that is about to return,
+// whose luaStack is on the top.
+func (l *luaState) hookReturn() {
+	if l.hookMask&api.LUA_MASKRET != 0 {
+		l.callHook(api.LUA_HOOKRET, -1)
+	}
+}
+
+// Call the count and line hooks if needed before the fetched
+// instruction of the running lua function is executed.
+func (l *luaState) traceExec() {
+	if l.hookMask&api.LUA_MASKCOUNT != 0 {
+		if l.hookCount--; l.hookCount == 0 {
+			l.hookCount = l.baseHookCount
+			l.callHook(api.LUA_HOOKCOUNT, -1)
+		}
+	}
+
+	if l.hookMask&api.LUA_MASKLINE != 0 {
+		s := l.stack
+		p := s.closure.proto
+		npc := s.pc - 1
+		// a new function, a jump back (loops) or a new line
+		if newLine := lineAt(p, npc); npc == 0 || npc <= s.oldPC || newLine != lineAt(p, s.oldPC) {
+			l.callHook(api.LUA_HOOKLINE, newLine)
+		}
+		s.oldPC = npc
+	}
+}
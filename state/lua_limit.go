@@ -0,0 +1,175 @@
+/*
+Limits of sandboxed scripts. The instruction count and the memory are shared
+by all threads of a lua state, while the call depth is per thread. Memory is
+only approximate: tables and strings are counted when they're allocated and
+never given back since the Go GC frees them silently, stacks are counted
+while they're in use.
+*/
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gonearewe/lua-compiler/api"
+)
+
+// the context is polled once every pollInterval instructions
+const pollInterval = 1000
+
+// approximate sizes in bytes of what the memory limit counts
+const (
+	valueSize  = 16 // a luaValue in a luaStack or in the array of a luaTable
+	entrySize  = 32 // a key-value pair in the map of a luaTable
+	tableSize  = 64 // a luaTable itself
+	stringSize = 16 // a string besides its bytes
+)
+
+// usage of the limits, shared by the threads of a lua state
+type sandbox struct {
+	limits api.Limits
+	nInsts int64 // instructions executed
+	nBytes int64 // bytes allocated for tables and strings
+	nStack int64 // bytes of the stacks in use
+	ctx    context.Context
+	nPolls int // instructions since the context is polled
+	// coroutines suspended in Yield(), which Close() kills
+	suspended suspended
+}
+
+// what the error raised when the context is done wraps
+type cancelError struct {
+	ctxErr error
+}
+
+func (e cancelError) Error() string {
+	return fmt.Sprintf("%v: %v", api.ErrCancelled, e.ctxErr)
+}
+
+func (e cancelError) Is(target error) bool {
+	return target == api.ErrCancelled
+}
+
+func (e cancelError) Unwrap() error {
+	return e.ctxErr
+}
+
+// Set the limits and restart counting the instructions and the memory of tables and strings.
+func (l *luaState) SetLimits(limits api.Limits) {
+	sb := l.sandbox
+	sb.limits = limits
+	sb.nInsts = 0
+	sb.nBytes = 0
+}
+
+// Set the context of the lua state, whose scripts are aborted with an uncatchable
+// error wrapping api.ErrCancelled once it's done, and whose suspended coroutines
+// are killed then like Close() does. A nil ctx means no context.
+func (l *luaState) SetContext(ctx context.Context) {
+	l.sandbox.ctx = ctx
+}
+
+// Return the context of the lua state, for go functions to pass to their own
+// I/O; it's context.Background() if none is set.
+func (l *luaState) Context() context.Context {
+	if ctx := l.sandbox.ctx; ctx != nil {
+		return ctx
+	}
+
+	return context.Background()
+}
+
+// Call the function below nArgs arguments on the top of the stack in protected
+// mode like PCall() does, with ctx as the context of the lua state meanwhile.
+// It returns nil or the *api.LuaError, whose error object is pushed then.
+func (l *luaState) CallContext(ctx context.Context, nArgs, nResults int) error {
+	oldCtx := l.sandbox.ctx
+	l.SetContext(ctx)
+	defer l.SetContext(oldCtx)
+
+	err := l.pcall(nArgs, nResults, 0)
+	if ctx.Err() != nil { // the script may be done before noticing it
+		l.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Raise the error of a cancelled script if the context is done.
+func (l *luaState) checkContext() {
+	if ctx := l.sandbox.ctx; ctx != nil {
+		if err := ctx.Err(); err != nil {
+			l.Close()
+			err := cancelError{err}
+			panic(&api.LuaError{
+				Value:       where(l.stack) + err.Error(),
+				Status:      api.LUA_ERRRUN,
+				Traceback:   l.traceback(0),
+				Uncatchable: true,
+				Err:         err,
+			})
+		}
+	}
+}
+
+// Count an instruction to execute.
+func (l *luaState) countInst() {
+	sb := l.sandbox
+	if sb.ctx != nil {
+		if sb.nPolls++; sb.nPolls >= pollInterval {
+			sb.nPolls = 0
+			l.checkContext()
+		}
+	}
+	if sb.limits.Instructions > 0 {
+		sb.nInsts++
+		if sb.nInsts > sb.limits.Instructions {
+			l.limitError(api.LUA_ERRRUN, "instruction limit exceeded")
+		}
+	}
+}
+
+// Count a new level in the call stack, n is the level of it.
+func (l *luaState) checkDepth(n int) {
+	if max := l.sandbox.limits.CallDepth; max > 0 && n > max {
+		l.limitError(api.LUA_ERRRUN, "call depth limit exceeded")
+	}
+}
+
+// Count n bytes allocated for tables and strings.
+func (l *luaState) allocate(n int64) {
+	sb := l.sandbox
+	sb.nBytes += n
+	l.checkMemory()
+}
+
+// Count n slots of the stacks in use, or no longer used if n is negative.
+// It's checked by checkMemory() when a luaStack is pushed, so that a full
+// stack still has room for the error object.
+func (l *luaState) allocateStack(n int) {
+	l.sandbox.nStack += int64(n) * valueSize
+}
+
+func (l *luaState) checkMemory() {
+	sb := l.sandbox
+	if sb.limits.Memory > 0 && sb.nBytes+sb.nStack > sb.limits.Memory {
+		l.limitError(api.LUA_ERRMEM, "not enough memory")
+	}
+}
+
+// Raise the error of exceeding a limit, the message handler isn't called
+// since it would most likely exceed the limit again.
+func (l *luaState) limitError(status int, msg string) {
+	if status == api.LUA_ERRRUN {
+		msg = where(l.stack) + msg
+	}
+
+	panic(&api.LuaError{
+		Value:       msg,
+		Status:      status,
+		Traceback:   l.traceback(0),
+		Uncatchable: l.sandbox.limits.Uncatchable,
+	})
+}
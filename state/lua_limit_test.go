@@ -0,0 +1,170 @@
+package state_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/state"
+	"github.com/gonearewe/lua-compiler/stdlib"
+)
+
+// Return a new lua state with the standard libraries and the chunk loaded.
+func newState(t *testing.T, chunk string) LuaState {
+	t.Helper()
+	ls := state.New()
+	stdlib.OpenLibs(ls)
+	if ls.Load([]byte(chunk), "=test", "t") != LUA_OK {
+		t.Fatalf("%s: %s", chunk, ls.ToString(-1))
+	}
+	return ls
+}
+
+const (
+	infiniteLoop  = `while true do end`
+	deepRecursion = `local function f() return 1 + f() end return f()`
+	bigTable      = `local t = {} for i = 1, 1e7 do t[i] = i end`
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		chunk  string
+		status int    // of the PCall() of the host
+		want   string // error message of the host, or the result if it's LUA_OK
+	}{
+		{"instructions", Limits{Instructions: 10000}, infiniteLoop,
+			LUA_ERRRUN, "test:1: instruction limit exceeded"},
+		{"call depth", Limits{CallDepth: 100}, deepRecursion,
+			LUA_ERRRUN, "test:1: call depth limit exceeded"},
+		{"memory", Limits{Memory: 1 << 20}, bigTable,
+			LUA_ERRMEM, "not enough memory"},
+		{"within limits", Limits{Instructions: 10000, CallDepth: 10, Memory: 1 << 20},
+			`local t = {} for i = 1, 100 do t[i] = i end return #t`, LUA_OK, "100"},
+
+		// catchable errors are caught by pcall, also inside a coroutine; the
+		// instructions are still exceeded after that, so the error is raised
+		// again where pcall returns rather than in the loop on line 2
+		{"catchable instructions", Limits{Instructions: 10000},
+			"pcall(function()\n" + infiniteLoop + "\nend)\nreturn 'caught'",
+			LUA_ERRRUN, "test:1: instruction limit exceeded"},
+		{"catchable call depth", Limits{CallDepth: 100},
+			`return select(2, pcall(function() ` + deepRecursion + ` end))`,
+			LUA_OK, "test:1: call depth limit exceeded"},
+		{"catchable memory", Limits{Memory: 1 << 20},
+			`return select(2, pcall(function() ` + bigTable + ` end))`,
+			LUA_OK, "not enough memory"},
+		{"catchable in coroutine", Limits{CallDepth: 100},
+			`return select(2, coroutine.resume(coroutine.create(function() ` + deepRecursion + ` end)))`,
+			LUA_OK, "test:1: call depth limit exceeded"},
+		{"catchable instructions in coroutine", Limits{Instructions: 10000},
+			"coroutine.resume(coroutine.create(function()\n" + infiniteLoop + "\nend))\nreturn 'caught'",
+			LUA_ERRRUN, "test:1: instruction limit exceeded"},
+
+		// uncatchable errors pass through pcall and coroutines to the host
+		{"uncatchable instructions", Limits{Instructions: 10000, Uncatchable: true},
+			"pcall(function()\n" + infiniteLoop + "\nend)\nreturn 'caught'",
+			LUA_ERRRUN, "test:2: instruction limit exceeded"},
+		{"uncatchable call depth", Limits{CallDepth: 100, Uncatchable: true},
+			`pcall(function() ` + deepRecursion + ` end) return "caught"`,
+			LUA_ERRRUN, "test:1: call depth limit exceeded"},
+		{"uncatchable memory", Limits{Memory: 1 << 20, Uncatchable: true},
+			`pcall(function() ` + bigTable + ` end) return "caught"`,
+			LUA_ERRMEM, "not enough memory"},
+		{"uncatchable through coroutine", Limits{Instructions: 10000, Uncatchable: true},
+			`pcall(coroutine.wrap(function()
+				pcall(coroutine.wrap(function() ` + infiniteLoop + ` end))
+			end))
+			return "caught"`,
+			LUA_ERRRUN, "test:2: instruction limit exceeded"},
+		{"uncatchable message handler", Limits{Instructions: 10000, Uncatchable: true},
+			`xpcall(function() ` + infiniteLoop + ` end, function() return "handled" end)
+			return "caught"`,
+			LUA_ERRRUN, "test:1: instruction limit exceeded"},
+	}
+
+	for _, test := range tests {
+		ls := newState(t, test.chunk)
+		ls.SetLimits(test.limits)
+		status := ls.PCall(0, 1, 0)
+		if got := ls.ToString(-1); status != test.status || got != test.want {
+			t.Errorf("%s: status %d and %q, want %d and %q", test.name, status, got, test.status, test.want)
+		}
+		ls.Close()
+	}
+}
+
+func TestInstructionsShared(t *testing.T) {
+	// the instructions of coroutines count, and SetLimits() restarts counting
+	ls := newState(t, `
+		local co = coroutine.wrap(function() for i = 1, 1e6 do coroutine.yield() end end)
+		for i = 1, 1e6 do co() end`)
+	defer ls.Close()
+	ls.SetLimits(Limits{Instructions: 100000})
+	if ls.PCall(0, 0, 0) != LUA_OK {
+		if msg := ls.ToString(-1); !strings.HasSuffix(msg, "instruction limit exceeded") {
+			t.Errorf("error %q, want the instruction limit exceeded", msg)
+		}
+	} else {
+		t.Errorf("no error, want the instruction limit exceeded")
+	}
+
+	ls.Pop(1)
+	ls.SetLimits(Limits{})
+	if ls.Load([]byte("return 1"), "=test", "t") != LUA_OK || ls.PCall(0, 1, 0) != LUA_OK {
+		t.Errorf("error %q after SetLimits(), want none", ls.ToString(-1))
+	}
+}
+
+func TestCancel(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk string
+	}{
+		{"loop", infiniteLoop},
+		{"pcall", `pcall(function() ` + infiniteLoop + ` end)`},
+		{"coroutine", `coroutine.wrap(function() pcall(function() ` + infiniteLoop + ` end) end)()`},
+		{"message handler", `xpcall(function() ` + infiniteLoop + ` end, function() return "handled" end)`},
+	}
+
+	for _, test := range tests {
+		ls := newState(t, test.chunk)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := ls.CallContext(ctx, 0, 0)
+		cancel()
+		if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: error %v, want one wrapping %v and %v",
+				test.name, err, ErrCancelled, context.DeadlineExceeded)
+		}
+		if ls.Context() != context.Background() {
+			t.Errorf("%s: context isn't restored after CallContext()", test.name)
+		}
+	}
+}
+
+func TestCancelKillsCoroutines(t *testing.T) {
+	ls := newState(t, `co = coroutine.create(function() coroutine.yield() end) coroutine.resume(co)`)
+	if ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ls.Load([]byte(infiniteLoop), "=test", "t")
+	if err := ls.CallContext(ctx, 0, 0); !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want one wrapping %v and %v", err, ErrCancelled, context.Canceled)
+	}
+	ls.Pop(1)
+
+	ls.Load([]byte(`return coroutine.status(co), coroutine.resume(co)`), "=test", "t")
+	if ls.PCall(0, 3, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	if status, msg := ls.ToString(-3), ls.ToString(-1); status != "dead" || msg != "cannot resume dead coroutine" {
+		t.Errorf("suspended coroutine is %s after cancelling, resuming it: %q", status, msg)
+	}
+}
@@ -21,6 +21,13 @@ type luaStack struct {
 	openuvs map[int]*upvalue
 	varargs []luaValue
 	pc      int
+	oldPC   int     // last instruction traced by the line hook
+	hooked  bool    // whether the hook is running on it
+	hits    []int64 // coverage counters of the instructions, nil if not covered
+
+	nResults   int  // number of results wanted by the caller
+	fresh      bool // whether it ends the runLuaClosure() invocation running it
+	isTailCall bool // whether it replaced the caller's luaStack by a tail call
 
 	state *luaState
 }
@@ -37,14 +44,24 @@ func newLuaStack(size int, state *luaState) *luaStack {
 // if not, it will enlarge the stack to just enough to contain n elements
 func (l *luaStack) check(n int) {
 	free := len(l.slots) - l.top
-	for i := free; i < n; i++ {
-		l.slots = append(l.slots, nil)
+	if free >= n {
+		return
+	}
+
+	slots := make([]luaValue, l.top+n)
+	copy(slots, l.slots)
+	l.slots = slots
+	// open upvalues point to the registers, which are moved
+	for idx, uv := range l.openuvs {
+		uv.val = &slots[idx]
 	}
+	l.state.nSlots += n - free
+	l.state.allocateStack(n - free)
 }
 
 func (l *luaStack) push(val luaValue) {
 	if l.top == len(l.slots) {
-		panic("stack overflow !")
+		l.state.runError("stack overflow")
 	}
 
 	l.slots[l.top] = val
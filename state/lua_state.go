@@ -1,10 +1,42 @@
 package state
 
-import "github.com/gonearewe/lua-compiler/api"
+import (
+	"github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/coverage"
+)
+
+// room beyond LUAI_MAXSTACK for the message handler of a stack overflow
+const extraStack = 5 * api.LUA_MINSTACK
+
+// slots beyond the registers of a lua function for the operands an instruction
+// pushes, the stack is grown by check() when more room is needed to call
+// functions and metamethods
+const vmTemps = 2
 
 type luaState struct {
 	stack    *luaStack
 	registry *luaTable
+	nSlots   int // slots of all the luaStacks in the call stack
+	nCcalls  int // number of nested Call()s
+	depth    int // levels in the call stack
+	sandbox  *sandbox
+	/* error handling */
+	errFunc  luaValue // message handler of the innermost PCall(), nil if none
+	handling bool     // whether the message handler is running
+	/* debug hook */
+	hook          api.Hook
+	hookMask      int
+	baseHookCount int
+	hookCount     int
+	inHook        bool // whether the hook is running, which can't be hooked
+	/* coverage */
+	coverage *coverage.Coverage // nil unless covering
+	/* coroutine */
+	coStatus int         // LUA_OK, LUA_YIELD or the error status it died with
+	coCaller *luaState   // thread that resumed this one, nil unless running
+	coChan   chan int    // wakes this thread up, nil unless started
+	coPanic  interface{} // Go panic that killed this thread, raised again by the resumer
+	handle   *thread     // value of this thread in lua, nil unless running
 }
 
 // func New(stackSize int, proto *binchunk.Prototype) *luaState {
@@ -21,21 +53,50 @@ func New() *luaState {
 
 	ls := &luaState{
 		registry: registry,
+		sandbox:  &sandbox{},
 	}
 	ls.pushLuaStack(newLuaStack(api.LUA_MINSTACK, ls))
+	ls.handle = &thread{ls}
+	registry.put(api.LUA_RIDX_MAINTHREAD, ls.handle)
 
 	return ls
 }
 
-// Add a head node to the linked list.
+// Add a head node to the linked list, raise "stack overflow" if the slots
+// in the call stack would exceed LUAI_MAXSTACK. The message handler gets
+// some extra room so that it's able to handle the error.
 func (l *luaState) pushLuaStack(stack *luaStack) {
+	limit := api.LUAI_MAXSTACK
+	if l.handling {
+		limit += extraStack
+	}
+	if l.stack != nil {
+		if l.nSlots+len(stack.slots) > limit {
+			l.runError("stack overflow")
+		}
+		l.checkDepth(l.depth + 1)
+		l.depth++
+	}
+
+	l.nSlots += len(stack.slots)
+	l.allocateStack(len(stack.slots))
 	stack.prev = l.stack
 	l.stack = stack
+	l.checkMemory()
 }
 
 // Delete the head node of the linked list.
 func (l *luaState) popLuaStack() {
 	stack := l.stack
+	l.nSlots -= len(stack.slots)
+	l.allocateStack(-len(stack.slots))
+	l.depth--
 	l.stack = stack.prev
 	stack.prev = nil
+	// the line hook doesn't fire again for the line of the calling instruction
+	l.stack.oldPC = l.stack.pc - 1
+}
+
+func (l *luaState) isMainThread() bool {
+	return l.registry.get(api.LUA_RIDX_MAINTHREAD).(*thread).luaState == l
 }
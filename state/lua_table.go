@@ -100,9 +100,10 @@ func (l *luaTable) put(key, val luaValue) {
 
 func (l *luaTable) _shrinkArray() {
 	for i := len(l.arr) - 1; i >= 0; i-- {
-		if l.arr[i] == nil {
-			l.arr = l.arr[0:i]
+		if l.arr[i] != nil {
+			break
 		}
+		l.arr = l.arr[0:i]
 	}
 }
 
@@ -110,7 +111,7 @@ func (l *luaTable) _shrinkArray() {
 func (l *luaTable) _expandArray() {
 	for idx := int64(len(l.arr)) + 1; true; idx++ {
 		if val, found := l._map[idx]; found {
-			delete(l._map, val)
+			delete(l._map, idx)
 			l.arr = append(l.arr, val)
 		} else {
 			break
@@ -0,0 +1,15 @@
+package state
+
+// Full userdata wraps an arbitrary Go value, unlike other
+// non-table values, each one of them has its own metatable.
+type userdata struct {
+	data      interface{}
+	metatable *luaTable
+	uservalue luaValue // any lua value associated with it
+}
+
+// Light userdata is a bare Go value compared by its value, all of them share
+// one metatable; the value must be comparable, a pointer usually.
+type lightUserdata struct {
+	p interface{}
+}
@@ -25,6 +25,12 @@ func typeOf(val luaValue) LuaType {
 		return LUA_TTABLE
 	case *closure:
 		return LUA_TFUNCTION
+	case *thread:
+		return LUA_TTHREAD
+	case *userdata:
+		return LUA_TUSERDATA
+	case lightUserdata:
+		return LUA_TLIGHTUSERDATA
 	default:
 		panic("TODO !")
 	}
@@ -49,7 +55,7 @@ func convertToFloat(val luaValue) (float64, bool) {
 	case int64:
 		return float64(x), true
 	case string:
-		return number.ParserFloat(x)
+		return number.ParseFloat(x)
 	default:
 		return 0, false
 	}
@@ -71,21 +77,25 @@ func convertToInteger(val luaValue) (int64, bool) {
 // if string can not be conversed to integer directly,
 // it will be conversed to float before finally to integer
 func _stringToInteger(s string) (int64, bool) {
-	if i, ok := number.ParserInteger(s); ok {
+	if i, ok := number.ParseInteger(s); ok {
 		return i, true
 	}
-	if f, ok := number.ParserFloat(s); ok {
+	if f, ok := number.ParseFloat(s); ok {
 		return number.FloatToInteger(f)
 	}
 
 	return 0, false
 }
 
-// Set metatable for given luaValue, every luaTable contains a metatable
-// and for other luaValue, each type shares one metatable in the registry.
+// Set metatable for given luaValue, every luaTable and full userdata contains a
+// metatable and for other luaValue, each type shares one metatable in the registry.
 func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
-	if t, ok := val.(*luaTable); ok {
-		t.metatable = mt
+	switch x := val.(type) {
+	case *luaTable:
+		x.metatable = mt
+		return
+	case *userdata:
+		x.metatable = mt
 		return
 	}
 
@@ -93,11 +103,14 @@ func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
 	ls.registry.put(key, mt)
 }
 
-// Get metatable for given luaValue, every luaTable contains a metatable
-// and for other luaValue, each type shares one metatable in the registry.
+// Get metatable for given luaValue, every luaTable and full userdata contains a
+// metatable and for other luaValue, each type shares one metatable in the registry.
 func getMetatable(val luaValue, ls *luaState) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.metatable
+	switch x := val.(type) {
+	case *luaTable:
+		return x.metatable
+	case *userdata:
+		return x.metatable
 	}
 
 	key := fmt.Sprintf("_MT%d", typeOf(val))
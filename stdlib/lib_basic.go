@@ -0,0 +1,463 @@
+/*
+The basic library, which provides core functions to lua,
+they are all registered in the global table.
+*/
+package stdlib
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+var baseFuncs = FuncReg{
+	"assert":         baseAssert,
+	"collectgarbage": baseCollectGarbage,
+	"dofile":         baseDoFile,
+	"error":          baseError,
+	"getmetatable":   baseGetMetatable,
+	"ipairs":         baseIPairs,
+	"loadfile":       baseLoadFile,
+	"load":           baseLoad,
+	"next":           baseNext,
+	"pairs":          basePairs,
+	"pcall":          basePCall,
+	"print":          basePrint,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"select":         baseSelect,
+	"setmetatable":   baseSetMetatable,
+	"tonumber":       baseToNumber,
+	"tostring":       baseToString,
+	"type":           baseType,
+	"xpcall":         baseXPCall,
+}
+
+// Open the basic library in the global table and push the table.
+func OpenBase(ls LuaState) int {
+	ls.PushGlobalTable()
+	ls.SetFuncs(baseFuncs, 0)
+	ls.PushValue(-1)
+	ls.SetField(-2, "_G")
+	ls.PushString("Lua 5.3")
+	ls.SetField(-2, "_VERSION")
+
+	return 1
+}
+
+// print (···)
+// Print all arguments to stdout, converted to strings by the global `tostring`.
+func basePrint(ls LuaState) int {
+	n := ls.GetTop()
+	ls.GetGlobal("tostring")
+	for i := 1; i <= n; i++ {
+		ls.PushValue(-1) // function to be called
+		ls.PushValue(i)  // value to print
+		ls.Call(1, 1)
+		s, ok := ls.ToStringX(-1)
+		if !ok {
+			return ls.Errorf("'tostring' must return a string to 'print'")
+		}
+		if i > 1 {
+			fmt.Print("\t")
+		}
+		fmt.Print(s)
+		ls.Pop(1) // pop result
+	}
+	fmt.Println()
+
+	return 0
+}
+
+// type (v)
+func baseType(ls LuaState) int {
+	t := ls.Type(1)
+	ls.ArgCheck(t != LUA_TNONE, 1, "value expected")
+	ls.PushString(ls.TypeName(t))
+
+	return 1
+}
+
+// tostring (v)
+func baseToString(ls LuaState) int {
+	ls.CheckAny(1)
+	ls.ToStringMeta(1)
+
+	return 1
+}
+
+// tonumber (e [, base])
+// Strings in base other than 10 must be integer numerals.
+func baseToNumber(ls LuaState) int {
+	if ls.IsNoneOrNil(2) { // standard conversion
+		if ls.Type(1) == LUA_TNUMBER {
+			ls.SetTop(1)
+			return 1
+		}
+		if s, ok := ls.ToStringX(1); ok && ls.StringToNumber(s) {
+			return 1
+		}
+		ls.CheckAny(1)
+	} else {
+		base := ls.CheckInteger(2)
+		ls.CheckType(1, LUA_TSTRING)
+		s := strings.TrimSpace(ls.ToString(1))
+		ls.ArgCheck(2 <= base && base <= 36, 2, "base out of range")
+		if n, ok := _stringToInteger(s, int(base)); ok {
+			ls.PushInteger(n)
+			return 1
+		}
+	}
+
+	ls.PushNil() // not a number
+	return 1
+}
+
+// Convert the string in given base to an integer, which wraps around on overflow.
+func _stringToInteger(s string, base int) (int64, bool) {
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg = true
+		s = s[1:]
+	}
+	if s == "" {
+		return 0, false
+	}
+
+	var n int64
+	for _, c := range strings.ToLower(s) {
+		var digit int
+		switch {
+		case c >= '0' && c <= '9':
+			digit = int(c - '0')
+		case c >= 'a' && c <= 'z':
+			digit = int(c-'a') + 10
+		default:
+			return 0, false
+		}
+		if digit >= base {
+			return 0, false
+		}
+		n = n*int64(base) + int64(digit)
+	}
+
+	if neg {
+		n = -n
+	}
+	return n, true
+}
+
+// select (index, ···)
+func baseSelect(ls LuaState) int {
+	n := int64(ls.GetTop())
+	if ls.Type(1) == LUA_TSTRING && ls.ToString(1) == "#" {
+		ls.PushInteger(n - 1)
+		return 1
+	}
+
+	i := ls.CheckInteger(1)
+	if i < 0 {
+		i = n + i
+	} else if i > n {
+		i = n
+	}
+	ls.ArgCheck(1 <= i, 1, "index out of range")
+
+	return int(n - i)
+}
+
+// assert (v [, message])
+func baseAssert(ls LuaState) int {
+	if ls.ToBoolean(1) { // condition is true
+		return ls.GetTop() // return all arguments
+	}
+
+	ls.CheckAny(1)                     // there must be a condition
+	ls.Remove(1)                       // remove it
+	ls.PushString("assertion failed!") // default message
+	ls.SetTop(1)                       // leave only message (default if no other one)
+
+	return ls.Error() // call 'error'
+}
+
+// error (message [, level])
+// The level tells which function to blame for the error: 1(default) for
+// the function calling error, 2 for its caller and so on, 0 for none.
+func baseError(ls LuaState) int {
+	level := int(ls.OptInteger(2, 1))
+	ls.SetTop(1)
+	if ls.Type(1) == LUA_TSTRING && level > 0 {
+		ls.Where(level) // add position information
+		ls.PushValue(1)
+		ls.Concat(2)
+	}
+
+	return ls.Error()
+}
+
+// pcall (f [, arg1, ···])
+func basePCall(ls LuaState) int {
+	ls.CheckAny(1)
+	ls.PushBoolean(true) // first result if no errors
+	ls.Insert(1)
+	status := ls.PCall(ls.GetTop()-2, LUA_MULTRET, 0)
+
+	return finishPCall(ls, status, 0)
+}
+
+// xpcall (f, msgh [, arg1, ···])
+func baseXPCall(ls LuaState) int {
+	n := ls.GetTop()
+	ls.CheckType(2, LUA_TFUNCTION)
+	ls.PushBoolean(true) // first result if no errors
+	ls.PushValue(1)      // function
+	ls.Rotate(3, 2)      // move them below function's arguments
+	status := ls.PCall(n-2, LUA_MULTRET, 2)
+
+	return finishPCall(ls, status, 2)
+}
+
+// Return true and all results of the call, or false and the error message;
+// extra is the number of values below the results.
+func finishPCall(ls LuaState, status, extra int) int {
+	if status != LUA_OK {
+		ls.PushBoolean(false)
+		ls.PushValue(-2) // error message
+		return 2
+	}
+
+	return ls.GetTop() - extra
+}
+
+// getmetatable (object)
+// Field "__metatable" of the metatable is returned instead if present.
+func baseGetMetatable(ls LuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetatable(1) {
+		ls.PushNil()
+		return 1
+	}
+	ls.GetMetafield(1, "__metatable")
+
+	return 1 // returns either __metatable field (if present) or metatable
+}
+
+// setmetatable (table, metatable)
+func baseSetMetatable(ls LuaState) int {
+	t := ls.Type(2)
+	ls.CheckType(1, LUA_TTABLE)
+	ls.ArgCheck(t == LUA_TNIL || t == LUA_TTABLE, 2, "nil or table expected")
+	if ls.GetMetafield(1, "__metatable") != LUA_TNIL {
+		return ls.Errorf("cannot change a protected metatable")
+	}
+	ls.SetTop(2)
+	ls.SetMetatable(1)
+
+	return 1
+}
+
+// rawequal (v1, v2)
+func baseRawEqual(ls LuaState) int {
+	ls.CheckAny(1)
+	ls.CheckAny(2)
+	ls.PushBoolean(ls.RawEqual(1, 2))
+
+	return 1
+}
+
+// rawlen (v)
+func baseRawLen(ls LuaState) int {
+	t := ls.Type(1)
+	ls.ArgCheck(t == LUA_TTABLE || t == LUA_TSTRING, 1, "table or string expected")
+	ls.PushInteger(int64(ls.RawLen(1)))
+
+	return 1
+}
+
+// rawget (table, index)
+func baseRawGet(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.RawGet(1)
+
+	return 1
+}
+
+// rawset (table, index, value)
+func baseRawSet(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	ls.CheckAny(2)
+	ls.CheckAny(3)
+	ls.SetTop(3)
+	ls.RawSet(1)
+
+	return 1
+}
+
+// next (table [, index])
+func baseNext(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	ls.SetTop(2) // create a 2nd argument if there isn't one
+	if ls.Next(1) {
+		return 2
+	}
+
+	ls.PushNil()
+	return 1
+}
+
+// pairs (t)
+// Metamethod "__pairs" of t is called if present.
+func basePairs(ls LuaState) int {
+	ls.CheckAny(1)
+	if ls.GetMetafield(1, "__pairs") == LUA_TNIL { // no metamethod?
+		ls.PushGoFunction(baseNext) // will return generator,
+		ls.PushValue(1)             // state,
+		ls.PushNil()                // and initial value
+	} else {
+		ls.PushValue(1) // argument 'self' to metamethod
+		ls.Call(1, 3)   // get 3 values from metamethod
+	}
+
+	return 3
+}
+
+// ipairs (t)
+func baseIPairs(ls LuaState) int {
+	ls.CheckAny(1)
+	ls.PushGoFunction(_iPairsAux) // iteration function
+	ls.PushValue(1)               // state
+	ls.PushInteger(0)             // initial value
+
+	return 3
+}
+
+func _iPairsAux(ls LuaState) int {
+	i := ls.CheckInteger(2) + 1
+	ls.PushInteger(i)
+	if ls.GetI(1, i) == LUA_TNIL {
+		return 1
+	}
+
+	return 2
+}
+
+// load (chunk [, chunkname [, mode [, env]]])
+// The chunk is either a string or a function returning its pieces.
+func baseLoad(ls LuaState) int {
+	var status int
+	mode := ls.OptString(3, "bt")
+	env := 0 // 'env' index or 0 if no 'env'
+	if !ls.IsNone(4) {
+		env = 4
+	}
+
+	if s, ok := ls.ToStringX(1); ok { // loading a string?
+		chunkName := ls.OptString(2, s)
+		status = ls.Load([]byte(s), chunkName, mode)
+	} else { // loading from a reader function
+		chunkName := ls.OptString(2, "=(load)")
+		ls.CheckType(1, LUA_TFUNCTION)
+		status = ls.Load(_readChunk(ls), chunkName, mode)
+	}
+
+	return loadAux(ls, status, env)
+}
+
+// Call the reader function at index 1 until it returns nil or
+// an empty string, return the concatenation of all pieces.
+func _readChunk(ls LuaState) []byte {
+	var chunk []byte
+	for {
+		ls.PushValue(1)
+		ls.Call(0, 1)
+		if ls.IsNil(-1) {
+			ls.Pop(1)
+			return chunk
+		}
+		if ls.Type(-1) != LUA_TSTRING {
+			ls.Errorf("reader function must return a string")
+		}
+
+		piece := ls.ToString(-1)
+		ls.Pop(1)
+		if piece == "" {
+			return chunk
+		}
+		chunk = append(chunk, piece...)
+	}
+}
+
+// Return the loaded function, whose first upvalue is set to
+// the value at index env unless env is 0; or nil and the error message.
+func loadAux(ls LuaState, status, env int) int {
+	if status != LUA_OK {
+		ls.PushNil()
+		ls.Insert(-2) // put before error message
+		return 2      // return nil plus error message
+	}
+
+	if env != 0 {
+		ls.PushValue(env)
+		if _, ok := ls.SetUpvalue(-2, 1); !ok { // set 'env' as first upvalue
+			ls.Pop(1) // remove 'env' if not used by previous call
+		}
+	}
+	return 1
+}
+
+// loadfile ([filename [, mode [, env]]])
+func baseLoadFile(ls LuaState) int {
+	fname := ls.OptString(1, "")
+	mode := ls.OptString(2, "bt")
+	env := 0 // 'env' index or 0 if no 'env'
+	if !ls.IsNone(3) {
+		env = 3
+	}
+	status := ls.LoadFileX(fname, mode)
+
+	return loadAux(ls, status, env)
+}
+
+// dofile ([filename])
+// Run the file(stdin by default) and return all its results.
+func baseDoFile(ls LuaState) int {
+	fname := ls.OptString(1, "")
+	ls.SetTop(1)
+	if ls.LoadFile(fname) != LUA_OK {
+		return ls.Error()
+	}
+	ls.Call(0, LUA_MULTRET)
+
+	return ls.GetTop() - 1
+}
+
+// collectgarbage ([opt [, arg]])
+// Memory is managed by the Go runtime, so options other than
+// "collect" and "count" do nothing.
+func baseCollectGarbage(ls LuaState) int {
+	opt := ls.OptString(1, "collect")
+	switch opt {
+	case "collect":
+		runtime.GC()
+		ls.PushInteger(0)
+	case "count": // in Kbytes
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		ls.PushNumber(float64(m.HeapAlloc) / 1024)
+	case "step", "isrunning":
+		ls.PushBoolean(true)
+	case "stop", "restart", "incremental", "generational",
+		"setpause", "setstepmul":
+		ls.PushInteger(0)
+	default:
+		return ls.ArgError(1, fmt.Sprintf("invalid option '%s'", opt))
+	}
+
+	return 1
+}
@@ -0,0 +1,139 @@
+package stdlib
+
+import . "github.com/gonearewe/lua-compiler/api"
+
+var coFuncs = FuncReg{
+	"create":      coCreate,
+	"resume":      coResume,
+	"running":     coRunning,
+	"status":      coStatus,
+	"wrap":        coWrap,
+	"yield":       coYield,
+	"isyieldable": coYieldable,
+}
+
+func OpenCoroutine(ls LuaState) int {
+	ls.NewLib(coFuncs)
+
+	return 1
+}
+
+func getCo(ls LuaState) LuaState {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "coroutine expected")
+	return co
+}
+
+// Resume the coroutine with the top nArgs values, return the number of
+// values it yields or returns, or -1 with the error object pushed.
+func auxResume(ls, co LuaState, nArgs int) int {
+	if !co.CheckStack(nArgs) {
+		ls.PushString("too many arguments to resume")
+		return -1 // error flag
+	}
+	if co.Status() == LUA_OK && co.GetTop() == 0 {
+		ls.PushString("cannot resume dead coroutine")
+		return -1 // error flag
+	}
+
+	ls.XMove(co, nArgs)
+	status := co.Resume(ls, nArgs)
+	if status == LUA_OK || status == LUA_YIELD {
+		nRes := co.GetTop()
+		if !ls.CheckStack(nRes + 1) {
+			co.Pop(nRes) // remove results anyway
+			ls.PushString("too many results to resume")
+			return -1 // error flag
+		}
+		co.XMove(ls, nRes) // move yielded values
+		return nRes
+	}
+
+	co.XMove(ls, 1) // move error message
+	return -1       // error flag
+}
+
+// coroutine.resume (co [, val1, ···])
+func coResume(ls LuaState) int {
+	co := getCo(ls)
+	r := auxResume(ls, co, ls.GetTop()-1)
+	if r < 0 {
+		ls.PushBoolean(false)
+		ls.Insert(-2)
+		return 2 // return false + error message
+	}
+
+	ls.PushBoolean(true)
+	ls.Insert(-(r + 1))
+	return r + 1 // return true + 'resume' returns
+}
+
+func auxWrap(ls LuaState) int {
+	co := ls.ToThread(LuaUpvalueIndex(1))
+	r := auxResume(ls, co, ls.GetTop())
+	if r < 0 {
+		return ls.Error() // propagate error
+	}
+	return r
+}
+
+// coroutine.create (f)
+func coCreate(ls LuaState) int {
+	ls.CheckType(1, LUA_TFUNCTION)
+	co := ls.NewThread()
+	ls.PushValue(1) // move function to top
+	ls.XMove(co, 1) // move function from ls to co
+	return 1
+}
+
+// coroutine.wrap (f)
+func coWrap(ls LuaState) int {
+	coCreate(ls)
+	ls.PushGoClosure(auxWrap, 1)
+	return 1
+}
+
+// coroutine.yield (···)
+func coYield(ls LuaState) int {
+	return ls.Yield(ls.GetTop())
+}
+
+// coroutine.status (co)
+func coStatus(ls LuaState) int {
+	co := getCo(ls)
+	if ls == co {
+		ls.PushString("running")
+		return 1
+	}
+
+	switch co.Status() {
+	case LUA_YIELD:
+		ls.PushString("suspended")
+	case LUA_OK:
+		if co.GetStack() { // does it have frames?
+			ls.PushString("normal") // it is running
+		} else if co.GetTop() == 0 {
+			ls.PushString("dead")
+		} else {
+			ls.PushString("suspended") // initial state
+		}
+	default: // some error occurred
+		ls.PushString("dead")
+	}
+
+	return 1
+}
+
+// coroutine.isyieldable ()
+func coYieldable(ls LuaState) int {
+	ls.PushBoolean(ls.IsYieldable())
+	return 1
+}
+
+// coroutine.running ()
+// Return the running coroutine plus a boolean, true when it's the main one.
+func coRunning(ls LuaState) int {
+	isMain := ls.PushThread()
+	ls.PushBoolean(isMain)
+	return 2
+}
@@ -0,0 +1,63 @@
+package stdlib_test
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	. "github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/state"
+	"github.com/gonearewe/lua-compiler/stdlib"
+)
+
+func TestAbandonedCoroutines(t *testing.T) {
+	ls := state.New()
+	stdlib.OpenLibs(ls)
+	defer ls.Close()
+
+	before := runtime.NumGoroutine()
+	chunk := `for i = 1, 1000 do
+		local co = coroutine.wrap(function() coroutine.yield(1) coroutine.yield(2) end)
+		co()
+	end`
+	if ls.LoadString(chunk) != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+
+	// finalizers run on their own goroutine after the collection
+	n := runtime.NumGoroutine()
+	for i := 0; i < 100 && n > before+10; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+		n = runtime.NumGoroutine()
+	}
+	if n > before+10 {
+		t.Errorf("%d goroutines after dropping 1000 suspended coroutines, want about %d", n, before)
+	}
+}
+
+func TestCoroutines(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{`coroutine.resume(coroutine.create(function(a, b) return a + b end), 1, 2)`, "true\t3"},
+		{`(function()
+			local co = coroutine.create(function() coroutine.yield(coroutine.status(coroutine.running())) end)
+			local _, s = coroutine.resume(co)
+			return s, coroutine.status(co)
+		end)()`, "running\tsuspended"},
+		{`(function()
+			local co
+			co = coroutine.create(function() return coroutine.running() == co end)
+			return coroutine.resume(co)
+		end)()`, "true\ttrue"},
+		{`select(2, coroutine.running())`, "true"},
+		{`(function()
+			local main = coroutine.running()
+			local co = coroutine.wrap(function() return coroutine.status(main), coroutine.isyieldable() end)
+			return co()
+		end)()`, "normal\ttrue"},
+		{`(function()
+			local gen = coroutine.wrap(function() for i = 1, 3 do coroutine.yield(i) end end)
+			return gen() + gen() + gen()
+		end)()`, "6"},
+	})
+}
@@ -0,0 +1,331 @@
+package stdlib
+
+import (
+	"strings"
+
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+var dbFuncs = FuncReg{
+	"gethook":    dbGetHook,
+	"getinfo":    dbGetInfo,
+	"getlocal":   dbGetLocal,
+	"getupvalue": dbGetUpvalue,
+	"sethook":    dbSetHook,
+	"setlocal":   dbSetLocal,
+	"setupvalue": dbSetUpvalue,
+	"traceback":  dbTraceback,
+}
+
+// key in the registry of the table of hook functions set by debug.sethook, indexed by thread
+const hookKey = "_HOOKKEY"
+
+var hookNames = []string{"call", "return", "line", "count", "tail call"}
+
+func OpenDebug(ls LuaState) int {
+	ls.NewLib(dbFuncs)
+
+	return 1
+}
+
+// Return the thread given as the first argument or the running one,
+// and the number of arguments before the other ones.
+func getThread(ls LuaState) (LuaState, int) {
+	if ls.IsThread(1) {
+		return ls.ToThread(1), 1
+	}
+
+	return ls, 0
+}
+
+// debug.traceback ([thread,] [message [, level]])
+func dbTraceback(ls LuaState) int {
+	ls1, arg := getThread(ls)
+	msg, ok := ls.ToStringX(arg + 1)
+	if !ok && !ls.IsNoneOrNil(arg+1) { // non-string message
+		ls.PushValue(arg + 1) // return it untouched
+		return 1
+	}
+
+	level := 0
+	if ls1 == ls {
+		level = 1 // skip traceback itself
+	}
+	level = int(ls.OptInteger(arg+2, int64(level)))
+	ls.Traceback(ls1, msg, level)
+
+	return 1
+}
+
+// Move the value on the top of ls1 to ls if they're different threads.
+func moveTo(ls1, ls LuaState) {
+	if ls1 != ls {
+		ls1.XMove(ls, 1)
+	}
+}
+
+// debug.getinfo ([thread,] f [, what])
+func dbGetInfo(ls LuaState) int {
+	ls1, arg := getThread(ls)
+	what := ls.OptString(arg+2, "flnStu")
+	ls.ArgCheck(!strings.HasPrefix(what, ">"), arg+2, "invalid option")
+	for _, option := range what {
+		ls.ArgCheck(strings.ContainsRune("flnStuL", option), arg+2, "invalid option")
+	}
+
+	var ar Debug
+	if ls.IsFunction(arg + 1) { // info about a function
+		ls.PushValue(arg + 1)
+		if ls1 != ls {
+			ls.XMove(ls1, 1)
+		}
+		ls1.GetInfo(0, ">"+what, &ar)
+	} else { // stack level
+		level := int(ls.CheckInteger(arg + 1))
+		if !ls1.GetInfo(level, what, &ar) {
+			ls.PushNil() // level out of range
+			return 1
+		}
+	}
+
+	ls.CreateTable(0, 2)
+	if strings.ContainsRune(what, 'S') {
+		setStrField(ls, "source", ar.Source)
+		setStrField(ls, "short_src", ar.ShortSrc)
+		setIntField(ls, "linedefined", ar.LineDefined)
+		setIntField(ls, "lastlinedefined", ar.LastLineDefined)
+		setStrField(ls, "what", ar.What)
+	}
+	if strings.ContainsRune(what, 'l') {
+		setIntField(ls, "currentline", ar.CurrentLine)
+	}
+	if strings.ContainsRune(what, 'u') {
+		setIntField(ls, "nups", ar.NUps)
+		setIntField(ls, "nparams", ar.NParams)
+		ls.PushBoolean(ar.IsVararg)
+		ls.SetField(-2, "isvararg")
+	}
+	if strings.ContainsRune(what, 'n') && ar.NameWhat != "" {
+		setStrField(ls, "name", ar.Name)
+		setStrField(ls, "namewhat", ar.NameWhat)
+	}
+	if strings.ContainsRune(what, 't') {
+		ls.PushBoolean(ar.IsTailCall)
+		ls.SetField(-2, "istailcall")
+	}
+	// pushed by GetInfo() in the order of 'f' and 'L', below the table
+	if strings.ContainsRune(what, 'L') {
+		setPushedField(ls, ls1, "activelines")
+	}
+	if strings.ContainsRune(what, 'f') {
+		setPushedField(ls, ls1, "func")
+	}
+
+	return 1
+}
+
+func setStrField(ls LuaState, k, v string) {
+	ls.PushString(v)
+	ls.SetField(-2, k)
+}
+
+func setIntField(ls LuaState, k string, v int) {
+	ls.PushInteger(int64(v))
+	ls.SetField(-2, k)
+}
+
+// Set the value on the top of ls1 (below the table if it's ls) as field k of the table.
+func setPushedField(ls, ls1 LuaState, k string) {
+	if ls1 == ls {
+		ls.Rotate(-2, 1) // put the value above the table
+	} else {
+		ls1.XMove(ls, 1)
+	}
+	ls.SetField(-2, k)
+}
+
+// debug.getlocal ([thread,] level, local)
+func dbGetLocal(ls LuaState) int {
+	ls1, arg := getThread(ls)
+	if ls.IsFunction(arg + 1) { // names of its parameters
+		n := int(ls.CheckInteger(arg + 2))
+		ls.PushValue(arg + 1)
+		name := ls.GetLocal(-1, n)
+		if name == "" {
+			ls.PushNil()
+		} else {
+			ls.PushString(name)
+		}
+		return 1
+	}
+
+	level := int(ls.CheckInteger(arg + 1))
+	n := int(ls.CheckInteger(arg + 2))
+	var ar Debug
+	if !ls1.GetInfo(level, "", &ar) {
+		return ls.ArgError(arg+1, "level out of range")
+	}
+
+	name := ls1.GetLocal(level, n)
+	if name == "" {
+		ls.PushNil()
+		return 1
+	}
+	moveTo(ls1, ls)
+	ls.PushString(name)
+	ls.Insert(-2)
+	return 2
+}
+
+// debug.setlocal ([thread,] level, local, value)
+func dbSetLocal(ls LuaState) int {
+	ls1, arg := getThread(ls)
+	level := int(ls.CheckInteger(arg + 1))
+	n := int(ls.CheckInteger(arg + 2))
+	var ar Debug
+	if !ls1.GetInfo(level, "", &ar) {
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	ls.CheckAny(arg + 3)
+	ls.SetTop(arg + 3)
+
+	if ls1 != ls {
+		ls.XMove(ls1, 1)
+	}
+	name := ls1.SetLocal(level, n)
+	if name == "" {
+		ls1.Pop(1) // not assigned
+		ls.PushNil()
+	} else {
+		ls.PushString(name)
+	}
+	return 1
+}
+
+// debug.getupvalue (f, up)
+func dbGetUpvalue(ls LuaState) int {
+	n := int(ls.CheckInteger(2))
+	ls.CheckType(1, LUA_TFUNCTION)
+
+	name, ok := ls.GetUpvalue(1, n)
+	if !ok {
+		return 0
+	}
+	ls.PushString(name)
+	ls.Insert(-2)
+	return 2
+}
+
+// debug.setupvalue (f, up, value)
+func dbSetUpvalue(ls LuaState) int {
+	ls.CheckAny(3)
+	n := int(ls.CheckInteger(2))
+	ls.CheckType(1, LUA_TFUNCTION)
+
+	name, ok := ls.SetUpvalue(1, n)
+	if !ok {
+		return 0
+	}
+	ls.PushString(name)
+	return 1
+}
+
+// The hook set by debug.sethook, which calls the hook function
+// of the thread with the event name and the new line.
+func hookF(ls LuaState, ar *Debug) {
+	ls.GetSubTable(LUA_REGISTRYINDEX, hookKey)
+	ls.PushThread()
+	if ls.RawGet(-2) != LUA_TFUNCTION {
+		return
+	}
+
+	ls.PushString(hookNames[ar.Event])
+	if ar.CurrentLine >= 0 {
+		ls.PushInteger(int64(ar.CurrentLine))
+	} else {
+		ls.PushNil()
+	}
+	ls.Call(2, 0)
+}
+
+// debug.sethook ([thread,] hook, mask [, count])
+func dbSetHook(ls LuaState) int {
+	ls1, arg := getThread(ls)
+	var f Hook
+	mask, count := 0, 0
+	if !ls.IsNoneOrNil(arg + 1) {
+		ls.CheckType(arg+1, LUA_TFUNCTION)
+		smask := ls.CheckString(arg + 2)
+		count = int(ls.OptInteger(arg+3, 0))
+		f, mask = hookF, makeMask(smask, count)
+	} else { // turn off hooks
+		ls.SetTop(arg + 1)
+	}
+
+	ls.GetSubTable(LUA_REGISTRYINDEX, hookKey)
+	pushThread(ls, ls1)
+	ls.PushValue(arg + 1) // hook function or nil
+	ls.RawSet(-3)
+	ls1.SetHook(f, mask, count)
+	return 0
+}
+
+// debug.gethook ([thread])
+func dbGetHook(ls LuaState) int {
+	ls1, _ := getThread(ls)
+	f, mask, count := ls1.GetHook()
+	if f == nil {
+		ls.PushNil()
+		return 1
+	}
+
+	ls.GetSubTable(LUA_REGISTRYINDEX, hookKey)
+	pushThread(ls, ls1)
+	if ls.RawGet(-2) == LUA_TNIL { // hook set by the host
+		ls.PushString("external hook")
+	}
+	ls.PushString(unmakeMask(mask))
+	ls.PushInteger(int64(count))
+	return 3
+}
+
+// Push thread ls1 into ls.
+func pushThread(ls, ls1 LuaState) {
+	if ls1 == ls {
+		ls.PushThread()
+	} else {
+		ls1.PushThread()
+		ls1.XMove(ls, 1)
+	}
+}
+
+func makeMask(smask string, count int) int {
+	mask := 0
+	if strings.ContainsRune(smask, 'c') {
+		mask |= LUA_MASKCALL
+	}
+	if strings.ContainsRune(smask, 'r') {
+		mask |= LUA_MASKRET
+	}
+	if strings.ContainsRune(smask, 'l') {
+		mask |= LUA_MASKLINE
+	}
+	if count > 0 {
+		mask |= LUA_MASKCOUNT
+	}
+	return mask
+}
+
+func unmakeMask(mask int) string {
+	smask := ""
+	if mask&LUA_MASKCALL != 0 {
+		smask += "c"
+	}
+	if mask&LUA_MASKRET != 0 {
+		smask += "r"
+	}
+	if mask&LUA_MASKLINE != 0 {
+		smask += "l"
+	}
+	return smask
+}
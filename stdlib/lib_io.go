@@ -0,0 +1,245 @@
+/*
+The io library. Files are opened through a filesystem given to NewIOLib, so
+that sandboxed scripts only reach what the host exposes, like an fstest.MapFS
+in memory or a DirFS chroot. Files of an fs.FS can only be read, writing is up
+to filesystems implementing WritableFS too. OpenIO uses the files of the
+process, with names passed to the os package as they are; OpenLibs opens it,
+so sandboxed hosts open the libraries one by one with RequireF() instead.
+
+File handles are userdata closed by file:close(), or by the Go GC after they
+become unreachable since the VM doesn't call __gc. io.popen and io.tmpfile
+are not supported.
+*/
+package stdlib
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+// keys of the default input and output files in the registry
+const (
+	ioInput  = "_IO_input"
+	ioOutput = "_IO_output"
+)
+
+// OpenIO opens the io library with the files and the standard streams of the process.
+func OpenIO(ls LuaState) int {
+	return NewIOLib(systemFS{}, os.Stdin, os.Stdout, os.Stderr)(ls)
+}
+
+// NewIOLib returns the opener of an io library opening files in fsys,
+// which may implement WritableFS, and using the standard streams given,
+// to be given to RequireF() like OpenIO.
+func NewIOLib(fsys fs.FS, stdin io.Reader, stdout, stderr io.Writer) GoFunction {
+	lib := &ioLib{fsys}
+	return func(ls LuaState) int {
+		ls.NewLib(FuncReg{
+			"close":  ioClose,
+			"flush":  ioFlush,
+			"input":  lib.input,
+			"lines":  lib.lines,
+			"open":   lib.open,
+			"output": lib.output,
+			"read":   ioRead,
+			"type":   ioType,
+			"write":  ioWrite,
+		})
+		createFileMeta(ls)
+
+		// the standard files, writes go out right away like print() does
+		stdFile := func(f *luaFile, field, regKey string) {
+			pushFile(ls, f)
+			if regKey != "" {
+				ls.PushValue(-1)
+				ls.SetField(LUA_REGISTRYINDEX, regKey)
+			}
+			ls.SetField(-2, field)
+		}
+		seeker := func(v interface{}) io.Seeker {
+			s, _ := v.(io.Seeker)
+			return s
+		}
+		stdFile(newLuaFile(stdin, nil, seeker(stdin), nil, bufFull), "stdin", ioInput)
+		stdFile(newLuaFile(nil, stdout, seeker(stdout), nil, bufNo), "stdout", ioOutput)
+		stdFile(newLuaFile(nil, stderr, seeker(stderr), nil, bufNo), "stderr", "")
+
+		return 1
+	}
+}
+
+type ioLib struct {
+	fsys fs.FS
+}
+
+// Open the file in the mode of io.open(), which is checked already.
+func (lib *ioLib) openFile(name, mode string) (*luaFile, error) {
+	if mode[0] == 'r' && !strings.Contains(mode, "+") {
+		f, err := lib.fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		s, _ := f.(io.Seeker)
+		return newLuaFile(f, nil, s, f, bufFull), nil
+	}
+
+	wfs, err := writable(lib.fsys, "open", name)
+	if err != nil {
+		return nil, err
+	}
+	var flag int
+	switch mode[0] {
+	case 'r':
+		flag = os.O_RDWR
+	case 'w':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case 'a':
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	if strings.Contains(mode, "+") {
+		flag = flag&^os.O_WRONLY | os.O_RDWR
+	}
+
+	f, err := wfs.OpenFile(name, flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	var rd io.Reader
+	if flag&os.O_RDWR != 0 {
+		rd, _ = f.(io.Reader)
+	}
+	s, _ := f.(io.Seeker)
+	return newLuaFile(rd, f, s, f, bufFull), nil
+}
+
+// Report whether the mode of io.open() is valid: one of "r", "w" and "a",
+// optionally followed by '+' and then by any number of 'b'.
+func checkMode(mode string) bool {
+	if mode == "" || strings.IndexByte("rwa", mode[0]) < 0 {
+		return false
+	}
+	mode = strings.TrimPrefix(mode[1:], "+")
+	return strings.Trim(mode, "b") == ""
+}
+
+// io.open (filename [, mode])
+func (lib *ioLib) open(ls LuaState) int {
+	name := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	ls.ArgCheck(checkMode(mode), 2, "invalid mode")
+
+	f, err := lib.openFile(name, mode)
+	if err != nil {
+		return fileResult(ls, err, name)
+	}
+	pushFile(ls, f)
+	return 1
+}
+
+// Push the file opened in the mode, raise an error if it can't be opened.
+func (lib *ioLib) openCheckFile(ls LuaState, name, mode string) {
+	f, err := lib.openFile(name, mode)
+	if err != nil {
+		fileResult(ls, err, "")
+		ls.Errorf("cannot open file '%s' (%s)", name, ls.ToString(-2))
+	}
+	pushFile(ls, f)
+}
+
+// Return the default input or output file, which must be open.
+func getIOFile(ls LuaState, regKey string) *luaFile {
+	ls.GetField(LUA_REGISTRYINDEX, regKey)
+	f := ls.ToUserdata(-1).(*luaFile)
+	ls.Pop(1)
+	if f.closed {
+		ls.Errorf("standard %s file is closed", regKey[len("_IO_"):])
+	}
+	return f
+}
+
+// Set the default input or output file to the file or the file name at
+// index 1 if any, push the default file anyway.
+func (lib *ioLib) ioFile(ls LuaState, regKey, mode string) int {
+	if !ls.IsNoneOrNil(1) {
+		if ls.Type(1) == LUA_TSTRING {
+			lib.openCheckFile(ls, ls.ToString(1), mode)
+		} else {
+			checkOpenFile(ls, 1)
+			ls.PushValue(1)
+		}
+		ls.SetField(LUA_REGISTRYINDEX, regKey)
+	}
+
+	ls.GetField(LUA_REGISTRYINDEX, regKey)
+	return 1
+}
+
+// io.input ([file])
+func (lib *ioLib) input(ls LuaState) int {
+	return lib.ioFile(ls, ioInput, "r")
+}
+
+// io.output ([file])
+func (lib *ioLib) output(ls LuaState) int {
+	return lib.ioFile(ls, ioOutput, "w")
+}
+
+// io.lines ([filename, ···])
+// Without the file name, it reads the default input file and leaves it open.
+func (lib *ioLib) lines(ls LuaState) int {
+	if ls.IsNone(1) {
+		ls.PushNil() // at least one argument
+	}
+	if ls.IsNil(1) {
+		getIOFile(ls, ioInput)
+		ls.GetField(LUA_REGISTRYINDEX, ioInput)
+		ls.Replace(1)
+		return pushLines(ls, false)
+	}
+
+	lib.openCheckFile(ls, ls.CheckString(1), "r")
+	ls.Replace(1)
+	return pushLines(ls, true)
+}
+
+// io.close ([file])
+func ioClose(ls LuaState) int {
+	if ls.IsNone(1) {
+		ls.GetField(LUA_REGISTRYINDEX, ioOutput)
+	}
+	return closeFile(ls, 1)
+}
+
+// io.flush ()
+func ioFlush(ls LuaState) int {
+	return fileResult(ls, getIOFile(ls, ioOutput).flush(), "")
+}
+
+// io.read (···)
+func ioRead(ls LuaState) int {
+	return readFile(ls, getIOFile(ls, ioInput), 1)
+}
+
+// io.write (···)
+func ioWrite(ls LuaState) int {
+	f := getIOFile(ls, ioOutput)
+	ls.GetField(LUA_REGISTRYINDEX, ioOutput) // file is returned
+	return writeFile(ls, f, 1)
+}
+
+// io.type (obj)
+func ioType(ls LuaState) int {
+	ls.CheckAny(1)
+	if f, ok := ls.TestUdata(1, fileHandle).(*luaFile); !ok {
+		ls.PushNil()
+	} else if f.closed {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
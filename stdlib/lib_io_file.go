@@ -0,0 +1,535 @@
+package stdlib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"syscall"
+
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+// userdata type of file handles
+const fileHandle = "FILE*"
+
+// buffering modes of writes, see file:setvbuf()
+const (
+	bufNo = iota
+	bufFull
+	bufLine
+)
+
+// size of the buffers of files by default
+const bufferSize = 4096
+
+// numerals read by format "n" are at most this long
+const maxNumeralLen = 200
+
+// luaFile is the Go value of a file handle. Reads and writes are buffered
+// separately: the reader is emptied before writing, and the writer is flushed
+// before reading, like C's stdio requires a seek or flush in between.
+type luaFile struct {
+	rd     io.Reader // nil if it's not opened for reading
+	wr     io.Writer // nil if it's not opened for writing
+	seeker io.Seeker // nil if it can't seek
+	closer io.Closer // nil for the standard files, which can't be closed
+	r      *bufio.Reader
+	w      *bufio.Writer
+	mode   int // buffering of writes
+	closed bool
+}
+
+func newLuaFile(rd io.Reader, wr io.Writer, seeker io.Seeker, closer io.Closer, mode int) *luaFile {
+	f := &luaFile{rd: rd, wr: wr, seeker: seeker, closer: closer, mode: mode}
+	if rd != nil {
+		f.r = bufio.NewReaderSize(rd, bufferSize)
+	}
+	if wr != nil {
+		f.w = bufio.NewWriterSize(wr, bufferSize)
+	}
+	if closer != nil {
+		// the VM never calls __gc, so the Go GC closes files left open
+		runtime.SetFinalizer(f, (*luaFile).close)
+	}
+	return f
+}
+
+// Flush the writes and close the file, which is closed only once.
+func (f *luaFile) close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	runtime.SetFinalizer(f, nil)
+
+	err := f.flush()
+	if cerr := f.closer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *luaFile) flush() error {
+	if f.w == nil {
+		return nil
+	}
+	return f.w.Flush()
+}
+
+// Prepare for reading, return syscall.EBADF if it's not opened for it.
+func (f *luaFile) startRead() error {
+	if f.r == nil {
+		return syscall.EBADF
+	}
+	return f.flush()
+}
+
+// Prepare for writing, whatever is read ahead is given back by seeking.
+func (f *luaFile) startWrite() error {
+	if f.w == nil {
+		return syscall.EBADF
+	}
+	if f.r != nil && f.r.Buffered() > 0 && f.seeker != nil {
+		if _, err := f.seeker.Seek(int64(-f.r.Buffered()), io.SeekCurrent); err != nil {
+			return err
+		}
+		f.r.Reset(f.rd)
+	}
+	return nil
+}
+
+func (f *luaFile) write(s string) error {
+	if err := f.startWrite(); err != nil {
+		return err
+	}
+	if _, err := f.w.WriteString(s); err != nil {
+		return err
+	}
+	if f.mode == bufNo || f.mode == bufLine && strings.IndexByte(s, '\n') >= 0 {
+		return f.w.Flush()
+	}
+	return nil
+}
+
+func (f *luaFile) seek(offset int64, whence int) (int64, error) {
+	if f.seeker == nil {
+		return 0, syscall.ESPIPE
+	}
+	if err := f.flush(); err != nil {
+		return 0, err
+	}
+	if f.r != nil {
+		if whence == io.SeekCurrent { // where the reader is, not the file
+			offset -= int64(f.r.Buffered())
+		}
+		f.r.Reset(f.rd)
+	}
+	return f.seeker.Seek(offset, whence)
+}
+
+func (f *luaFile) setvbuf(mode, size int) error {
+	if err := f.flush(); err != nil {
+		return err
+	}
+	f.mode = mode
+	if f.w != nil {
+		f.w = bufio.NewWriterSize(f.wr, size)
+	}
+	return nil
+}
+
+var fileMethods = FuncReg{
+	"close":   fileClose,
+	"flush":   fileFlush,
+	"lines":   fileLines,
+	"read":    fileRead,
+	"seek":    fileSeek,
+	"setvbuf": fileSetvbuf,
+	"write":   fileWrite,
+}
+
+var fileMetamethods = FuncReg{
+	"__gc":       fileGC,
+	"__close":    fileGC,
+	"__tostring": fileToString,
+}
+
+// Create the metatable of file handles in the registry.
+func createFileMeta(ls LuaState) {
+	if ls.NewMetatable(fileHandle) {
+		ls.SetFuncs(fileMetamethods, 0)
+		ls.NewLibTable(fileMethods)
+		ls.SetFuncs(fileMethods, 0)
+		ls.SetField(-2, "__index")
+	}
+	ls.Pop(1)
+}
+
+func pushFile(ls LuaState, f *luaFile) {
+	ls.PushUserdata(f)
+	ls.SetNamedMetatable(fileHandle)
+}
+
+// Return the file handle at arg, which may be closed.
+func toLuaFile(ls LuaState, arg int) *luaFile {
+	return ls.CheckUdata(arg, fileHandle).(*luaFile)
+}
+
+// Return the file handle at arg, raise an error if it's closed.
+func checkOpenFile(ls LuaState, arg int) *luaFile {
+	f := toLuaFile(ls, arg)
+	if f.closed {
+		ls.Errorf("attempt to use a closed file")
+	}
+	return f
+}
+
+// Close the file at arg and push the results of file:close().
+func closeFile(ls LuaState, arg int) int {
+	f := checkOpenFile(ls, arg)
+	if f.closer == nil {
+		ls.PushNil()
+		ls.PushString("cannot close standard file")
+		return 2
+	}
+	return fileResult(ls, f.close(), "")
+}
+
+// file:close ()
+func fileClose(ls LuaState) int {
+	return closeFile(ls, 1)
+}
+
+// __gc and __close of file handles, which may be closed already.
+func fileGC(ls LuaState) int {
+	if f := toLuaFile(ls, 1); f.closer != nil {
+		f.close()
+	}
+	return 0
+}
+
+func fileToString(ls LuaState) int {
+	if f := toLuaFile(ls, 1); f.closed {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushString(fmt.Sprintf("file (%p)", f))
+	}
+	return 1
+}
+
+// file:flush ()
+func fileFlush(ls LuaState) int {
+	return fileResult(ls, checkOpenFile(ls, 1).flush(), "")
+}
+
+// file:seek ([whence [, offset]])
+func fileSeek(ls LuaState) int {
+	f := checkOpenFile(ls, 1)
+	var whence int
+	switch opt := ls.OptString(2, "cur"); opt {
+	case "set":
+		whence = io.SeekStart
+	case "cur":
+		whence = io.SeekCurrent
+	case "end":
+		whence = io.SeekEnd
+	default:
+		return ls.ArgError(2, fmt.Sprintf("invalid option '%s'", opt))
+	}
+	offset := ls.OptInteger(3, 0)
+
+	pos, err := f.seek(offset, whence)
+	if err != nil {
+		return fileResult(ls, err, "")
+	}
+	ls.PushInteger(pos)
+	return 1
+}
+
+// file:setvbuf (mode [, size])
+func fileSetvbuf(ls LuaState) int {
+	f := checkOpenFile(ls, 1)
+	var mode int
+	switch opt := ls.CheckString(2); opt {
+	case "no":
+		mode = bufNo
+	case "full":
+		mode = bufFull
+	case "line":
+		mode = bufLine
+	default:
+		return ls.ArgError(2, fmt.Sprintf("invalid option '%s'", opt))
+	}
+	size := ls.OptInteger(3, bufferSize)
+	ls.ArgCheck(size > 0, 3, "size must be positive")
+
+	return fileResult(ls, f.setvbuf(mode, int(size)), "")
+}
+
+// file:write (···)
+func fileWrite(ls LuaState) int {
+	f := checkOpenFile(ls, 1)
+	ls.PushValue(1) // file is returned
+	return writeFile(ls, f, 2)
+}
+
+// Write the arguments from arg on to the file, push the file at the top if
+// it succeeds.
+func writeFile(ls LuaState, f *luaFile, arg int) int {
+	n := ls.GetTop() - 1 // without the file pushed
+	for ; arg <= n; arg++ {
+		if err := f.write(ls.CheckString(arg)); err != nil {
+			return fileResult(ls, err, "")
+		}
+	}
+	return 1
+}
+
+// file:read (···)
+func fileRead(ls LuaState) int {
+	return readFile(ls, checkOpenFile(ls, 1), 2)
+}
+
+// Read the file in the formats of the arguments from first on, "l" if there
+// is none. Every format pushes a result, nil for the one that fails and it's
+// the last; a read error pushes the results of fileResult() instead.
+func readFile(ls LuaState, f *luaFile, first int) int {
+	if err := f.startRead(); err != nil {
+		return fileResult(ls, err, "")
+	}
+
+	nArgs := ls.GetTop() - first + 1
+	if nArgs <= 0 {
+		nArgs = 1
+		ls.PushString("l") // the default format at first
+	}
+	ls.CheckStack(nArgs + 20)
+
+	var err error
+	success := true
+	n := first
+	for ; n < first+nArgs && success && err == nil; n++ {
+		if ls.Type(n) == LUA_TNUMBER {
+			l := ls.CheckInteger(n)
+			if l == 0 {
+				success, err = testEOF(ls, f.r)
+			} else {
+				success, err = readChars(ls, f.r, l)
+			}
+			continue
+		}
+
+		format := ls.CheckString(n)
+		if strings.HasPrefix(format, "*") { // the format of 5.2
+			format = format[1:]
+		}
+		switch {
+		case strings.HasPrefix(format, "n"):
+			success, err = readNumber(ls, f.r)
+		case strings.HasPrefix(format, "l"):
+			success, err = readLine(ls, f.r, true)
+		case strings.HasPrefix(format, "L"):
+			success, err = readLine(ls, f.r, false)
+		case strings.HasPrefix(format, "a"):
+			success, err = readAll(ls, f.r)
+		default:
+			return ls.ArgError(n, "invalid format")
+		}
+	}
+
+	if err != nil {
+		return fileResult(ls, err, "")
+	}
+	if !success {
+		ls.Pop(1)
+		ls.PushNil()
+	}
+	return n - first
+}
+
+// Push "" unless the reader is at the end of the file.
+func testEOF(ls LuaState, r *bufio.Reader) (bool, error) {
+	ls.PushString("")
+	if _, err := r.Peek(1); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Push at most n bytes, which fails if none is left.
+func readChars(ls LuaState, r *bufio.Reader, n int64) (bool, error) {
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, io.LimitReader(r, n)); err != nil {
+		return false, err
+	}
+	ls.PushString(b.String())
+	return b.Len() > 0, nil
+}
+
+// Push the next line, without the end of line if chop is true.
+func readLine(ls LuaState, r *bufio.Reader, chop bool) (bool, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	success := err == nil || len(line) > 0
+	if chop && err == nil {
+		line = line[:len(line)-1]
+	}
+	ls.PushString(line)
+	return success, nil
+}
+
+// Push the rest of the file, which may be "".
+func readAll(ls LuaState, r *bufio.Reader) (bool, error) {
+	var b bytes.Buffer
+	if _, err := io.Copy(&b, r); err != nil {
+		return false, err
+	}
+	ls.PushString(b.String())
+	return true, nil
+}
+
+// reader of a numeral, which reads as long as the numeral may go on
+type numeralReader struct {
+	r   *bufio.Reader
+	c   int // current character, -1 at the end of the file
+	buf []byte
+	err error
+}
+
+// Add the current character to the numeral and read the next one.
+func (nr *numeralReader) next() bool {
+	if len(nr.buf) >= maxNumeralLen { // too long, invalid anyway
+		nr.buf = nr.buf[:0]
+		return false
+	}
+	nr.buf = append(nr.buf, byte(nr.c))
+	nr.read()
+	return true
+}
+
+func (nr *numeralReader) read() {
+	c, err := nr.r.ReadByte()
+	if err != nil {
+		nr.c = -1
+		if err != io.EOF {
+			nr.err = err
+		}
+	} else {
+		nr.c = int(c)
+	}
+}
+
+// Accept the current character if it's one of set.
+func (nr *numeralReader) test(set string) bool {
+	if nr.c >= 0 && strings.IndexByte(set, byte(nr.c)) >= 0 {
+		return nr.next()
+	}
+	return false
+}
+
+func (nr *numeralReader) digits(hex bool) int {
+	count := 0
+	for nr.c >= 0 && (isDigit(byte(nr.c)) || hex && isHexLetter(byte(nr.c))) && nr.next() {
+		count++
+	}
+	return count
+}
+
+func isHexLetter(c byte) bool {
+	return c|0x20 >= 'a' && c|0x20 <= 'f'
+}
+
+// Push the numeral read as a number, like C's fscanf() reads one but at most
+// maxNumeralLen characters; it fails if they don't make a number.
+func readNumber(ls LuaState, r *bufio.Reader) (bool, error) {
+	nr := &numeralReader{r: r}
+	for nr.read(); nr.c >= 0 && strings.IndexByte(" \f\n\r\t\v", byte(nr.c)) >= 0; {
+		nr.read() // skip spaces
+	}
+
+	count, hex := 0, false
+	nr.test("-+")
+	if nr.test("0") {
+		if nr.test("xX") {
+			hex = true
+		} else {
+			count = 1
+		}
+	}
+	count += nr.digits(hex)
+	if nr.test(".") {
+		count += nr.digits(hex)
+	}
+	if count > 0 {
+		exp := "eE"
+		if hex {
+			exp = "pP"
+		}
+		if nr.test(exp) {
+			nr.test("-+")
+			nr.digits(false)
+		}
+	}
+	if nr.c >= 0 {
+		r.UnreadByte() // the character after the numeral
+	}
+	if nr.err != nil {
+		return false, nr.err
+	}
+
+	if ls.StringToNumber(string(nr.buf)) {
+		return true, nil
+	}
+	ls.PushNil() // "result" to be removed
+	return false, nil
+}
+
+// file:lines (···)
+func fileLines(ls LuaState) int {
+	checkOpenFile(ls, 1)
+	return pushLines(ls, false)
+}
+
+// Push the iterator reading the file at index 1 in the formats after it,
+// which closes the file at the end if toClose is true.
+func pushLines(ls LuaState, toClose bool) int {
+	n := ls.GetTop() - 1 // number of formats
+	ls.ArgCheck(n <= 250, 252, "too many arguments")
+	ls.PushBoolean(toClose)
+	ls.Insert(2)
+	ls.PushGoClosure(ioReadLine, n+2) // the file, toClose and the formats
+	return 1
+}
+
+func ioReadLine(ls LuaState) int {
+	f := toLuaFile(ls, LuaUpvalueIndex(1))
+	if f.closed {
+		return ls.Errorf("file is already closed")
+	}
+
+	ls.SetTop(0) // the arguments are ignored
+	for i := 3; ls.Type(LuaUpvalueIndex(i)) != LUA_TNONE; i++ {
+		ls.PushValue(LuaUpvalueIndex(i))
+	}
+	n := readFile(ls, f, 1)
+
+	if !ls.IsNil(-n) { // read at least one value
+		return n
+	}
+	if n > 1 { // an error message
+		return ls.Errorf("%s", ls.ToString(-n+1))
+	}
+	if ls.ToBoolean(LuaUpvalueIndex(2)) {
+		f.close()
+	}
+	return 0
+}
@@ -0,0 +1,146 @@
+package stdlib
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// WritableFS is a filesystem whose files can be written besides being read,
+// the io library opens files in modes other than "r" through OpenFile(),
+// and the os library removes and renames files through it.
+type WritableFS interface {
+	fs.FS
+	// OpenFile is like os.OpenFile, flag is made of the os.O_* flags.
+	OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error)
+	Remove(name string) error
+	Rename(oldName, newName string) error
+}
+
+// WritableFile is a file opened by a WritableFS, reads and seeks are done if
+// it implements io.Reader and io.Seeker like *os.File.
+type WritableFile interface {
+	io.Writer
+	io.Closer
+}
+
+// SystemFS returns the filesystem of the process, which OpenIO uses.
+func SystemFS() WritableFS {
+	return systemFS{}
+}
+
+// names are passed to the os package as they are, unlike
+// an fs.FS which only takes unrooted slash-separated paths
+type systemFS struct{}
+
+func (systemFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (systemFS) OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (systemFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (systemFS) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+// DirFS returns a writable filesystem for the tree of files rooted at dir,
+// the chroot of the scripts using it. Names must be valid in the sense of
+// fs.ValidPath(), so scripts can't reach files outside of dir by "..".
+func DirFS(dir string) WritableFS {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (dir dirFS) join(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(string(dir), filepath.FromSlash(name)), nil
+}
+
+func (dir dirFS) Open(name string) (fs.File, error) {
+	path, err := dir.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (dir dirFS) OpenFile(name string, flag int, perm fs.FileMode) (WritableFile, error) {
+	path, err := dir.join("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return systemFS{}.OpenFile(path, flag, perm)
+}
+
+func (dir dirFS) Remove(name string) error {
+	path, err := dir.join("remove", name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func (dir dirFS) Rename(oldName, newName string) error {
+	oldPath, err := dir.join("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := dir.join("rename", newName)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// Return the file system as a WritableFS, or an error for a read-only one
+// like an fs.FS writing to the named file fails with.
+func writable(fsys fs.FS, op, name string) (WritableFS, error) {
+	if wfs, ok := fsys.(WritableFS); ok {
+		return wfs, nil
+	}
+	return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrPermission}
+}
+
+// Create an empty file with a new name in the filesystem and return its name,
+// which is in the temporary directory of the system for SystemFS, and at the
+// root for other filesystems.
+func createTemp(fsys fs.FS) (string, error) {
+	dir := ""
+	if _, ok := fsys.(systemFS); ok {
+		dir = os.TempDir()
+	}
+
+	for try := 0; ; try++ {
+		name := fmt.Sprintf("lua_%06d", rand.Intn(1000000))
+		if dir != "" {
+			name = filepath.Join(dir, name)
+		}
+		wfs, err := writable(fsys, "open", name)
+		if err != nil {
+			return "", err
+		}
+		f, err := wfs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			return name, f.Close()
+		}
+		if !os.IsExist(err) || try >= 100 {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,345 @@
+/*
+The math library, functions keep integers as integers where it makes sense,
+like math.floor(3.0) returns integer 3 while math.floor(1e100) stays a float.
+*/
+package stdlib
+
+import (
+	"math"
+	"math/rand"
+
+	. "github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/number"
+)
+
+var mathLib = FuncReg{
+	"abs":       mathAbs,
+	"acos":      mathAcos,
+	"asin":      mathAsin,
+	"atan":      mathAtan,
+	"ceil":      mathCeil,
+	"cos":       mathCos,
+	"deg":       mathDeg,
+	"exp":       mathExp,
+	"floor":     mathFloor,
+	"fmod":      mathFmod,
+	"log":       mathLog,
+	"max":       mathMax,
+	"min":       mathMin,
+	"modf":      mathModf,
+	"rad":       mathRad,
+	"sin":       mathSin,
+	"sqrt":      mathSqrt,
+	"tan":       mathTan,
+	"tointeger": mathToInt,
+	"type":      mathType,
+	"ult":       mathUlt,
+}
+
+func OpenMath(ls LuaState) int {
+	ls.NewLib(mathLib)
+
+	// every state has its own generator, which starts with the same
+	// seed so that runs are reproducible until math.randomseed is called
+	g := &randGen{rand.New(rand.NewSource(1))}
+	ls.PushGoFunction(g.random)
+	ls.SetField(-2, "random")
+	ls.PushGoFunction(g.randomSeed)
+	ls.SetField(-2, "randomseed")
+
+	ls.PushNumber(math.Pi)
+	ls.SetField(-2, "pi")
+	ls.PushNumber(math.Inf(1))
+	ls.SetField(-2, "huge")
+	ls.PushInteger(math.MaxInt64)
+	ls.SetField(-2, "maxinteger")
+	ls.PushInteger(math.MinInt64)
+	ls.SetField(-2, "mininteger")
+
+	return 1
+}
+
+// Push the float as an integer if it has an exact integer representation.
+func pushNumInt(ls LuaState, f float64) {
+	if i, ok := number.FloatToInteger(f); ok {
+		ls.PushInteger(i)
+	} else {
+		ls.PushNumber(f)
+	}
+}
+
+// math.floor (x)
+func mathFloor(ls LuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1) // integer is its own floor
+	} else {
+		pushNumInt(ls, math.Floor(ls.CheckNumber(1)))
+	}
+
+	return 1
+}
+
+// math.ceil (x)
+func mathCeil(ls LuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1) // integer is its own ceil
+	} else {
+		pushNumInt(ls, math.Ceil(ls.CheckNumber(1)))
+	}
+
+	return 1
+}
+
+// math.abs (x)
+func mathAbs(ls LuaState) int {
+	if ls.IsInteger(1) {
+		n := ls.ToInteger(1)
+		if n < 0 {
+			ls.PushInteger(0 - n) // math.mininteger stays unchanged
+		} else {
+			ls.PushInteger(n)
+		}
+	} else {
+		ls.PushNumber(math.Abs(ls.CheckNumber(1)))
+	}
+
+	return 1
+}
+
+// math.fmod (x, y)
+// The remainder of x / y that rounds the quotient towards zero.
+func mathFmod(ls LuaState) int {
+	if ls.IsInteger(1) && ls.IsInteger(2) {
+		d := ls.ToInteger(2)
+		if uint64(d)+1 <= 1 { // special cases: -1 or 0
+			ls.ArgCheck(d != 0, 2, "zero")
+			ls.PushInteger(0) // avoid overflow with 0x80000... / -1
+		} else {
+			ls.PushInteger(ls.ToInteger(1) % d)
+		}
+	} else {
+		ls.PushNumber(math.Mod(ls.CheckNumber(1), ls.CheckNumber(2)))
+	}
+
+	return 1
+}
+
+// math.modf (x)
+// Return the integral part and the fractional part of x, both as floats.
+func mathModf(ls LuaState) int {
+	if ls.IsInteger(1) {
+		ls.SetTop(1)     // number is its own integer part
+		ls.PushNumber(0) // no fractional part
+	} else {
+		n := ls.CheckNumber(1)
+		ip := math.Trunc(n) // integer part (rounds toward zero)
+		ls.PushNumber(ip)
+		if n == ip { // test needed for inf/-inf
+			ls.PushNumber(0)
+		} else {
+			ls.PushNumber(n - ip)
+		}
+	}
+
+	return 2
+}
+
+// math.sqrt (x)
+func mathSqrt(ls LuaState) int {
+	ls.PushNumber(math.Sqrt(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.exp (x)
+func mathExp(ls LuaState) int {
+	ls.PushNumber(math.Exp(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.log (x [, base])
+func mathLog(ls LuaState) int {
+	x := ls.CheckNumber(1)
+	var res float64
+	if ls.IsNoneOrNil(2) {
+		res = math.Log(x)
+	} else {
+		switch base := ls.CheckNumber(2); base {
+		case 2:
+			res = math.Log2(x)
+		case 10:
+			res = math.Log10(x)
+		default:
+			res = math.Log(x) / math.Log(base)
+		}
+	}
+
+	ls.PushNumber(res)
+	return 1
+}
+
+// math.deg (x)
+func mathDeg(ls LuaState) int {
+	ls.PushNumber(ls.CheckNumber(1) * (180 / math.Pi))
+	return 1
+}
+
+// math.rad (x)
+func mathRad(ls LuaState) int {
+	ls.PushNumber(ls.CheckNumber(1) * (math.Pi / 180))
+	return 1
+}
+
+// math.sin (x)
+func mathSin(ls LuaState) int {
+	ls.PushNumber(math.Sin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.cos (x)
+func mathCos(ls LuaState) int {
+	ls.PushNumber(math.Cos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.tan (x)
+func mathTan(ls LuaState) int {
+	ls.PushNumber(math.Tan(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.asin (x)
+func mathAsin(ls LuaState) int {
+	ls.PushNumber(math.Asin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.acos (x)
+func mathAcos(ls LuaState) int {
+	ls.PushNumber(math.Acos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.atan (y [, x])
+func mathAtan(ls LuaState) int {
+	y := ls.CheckNumber(1)
+	x := ls.OptNumber(2, 1)
+	ls.PushNumber(math.Atan2(y, x))
+	return 1
+}
+
+// math.tointeger (x)
+// Return nil if x is not convertible to an integer.
+func mathToInt(ls LuaState) int {
+	if i, ok := ls.ToIntegerX(1); ok {
+		ls.PushInteger(i)
+	} else {
+		ls.CheckAny(1)
+		ls.PushNil() // value is not convertible to integer
+	}
+
+	return 1
+}
+
+// math.type (x)
+// Return "integer", "float" or nil if x is not a number.
+func mathType(ls LuaState) int {
+	if ls.Type(1) == LUA_TNUMBER {
+		if ls.IsInteger(1) {
+			ls.PushString("integer")
+		} else {
+			ls.PushString("float")
+		}
+	} else {
+		ls.CheckAny(1)
+		ls.PushNil()
+	}
+
+	return 1
+}
+
+// math.ult (m, n)
+// Whether m is below n when they are compared as unsigned integers.
+func mathUlt(ls LuaState) int {
+	m := ls.CheckInteger(1)
+	n := ls.CheckInteger(2)
+	ls.PushBoolean(uint64(m) < uint64(n))
+	return 1
+}
+
+// math.max (x, ···)
+func mathMax(ls LuaState) int {
+	n := ls.GetTop() // number of arguments
+	imax := 1        // index of current maximum value
+	ls.ArgCheck(n >= 1, 1, "number expected")
+	for i := 1; i <= n; i++ {
+		ls.CheckNumber(i)
+		if ls.Compare(imax, i, LUA_OPLT) {
+			imax = i
+		}
+	}
+
+	ls.PushValue(imax)
+	return 1
+}
+
+// math.min (x, ···)
+func mathMin(ls LuaState) int {
+	n := ls.GetTop() // number of arguments
+	imin := 1        // index of current minimum value
+	ls.ArgCheck(n >= 1, 1, "number expected")
+	for i := 1; i <= n; i++ {
+		ls.CheckNumber(i)
+		if ls.Compare(i, imin, LUA_OPLT) {
+			imin = i
+		}
+	}
+
+	ls.PushValue(imin)
+	return 1
+}
+
+// pseudo-random generator of a state
+type randGen struct {
+	r *rand.Rand
+}
+
+// math.random ([m [, n]])
+// Return a float in [0,1) without arguments, otherwise an integer in [m, n],
+// m is 1 by default.
+func (g *randGen) random(ls LuaState) int {
+	r := g.r.Float64()
+	var low, up int64
+	switch ls.GetTop() { // check number of arguments
+	case 0: // no arguments
+		ls.PushNumber(r) // Number between 0 and 1
+		return 1
+	case 1: // only upper limit
+		low = 1
+		up = ls.CheckInteger(1)
+	case 2: // lower and upper limits
+		low = ls.CheckInteger(1)
+		up = ls.CheckInteger(2)
+	default:
+		return ls.Errorf("wrong number of arguments")
+	}
+
+	// random integer in the interval [low, up]
+	ls.ArgCheck(low <= up, 1, "interval is empty")
+	ls.ArgCheck(low >= 0 || up <= math.MaxInt64+low, 1, "interval too large")
+	r *= float64(up-low) + 1.0
+	ls.PushInteger(int64(r) + low)
+	return 1
+}
+
+// math.randomseed (x)
+// The same seed always produces the same sequence.
+func (g *randGen) randomSeed(ls LuaState) int {
+	seed := ls.CheckNumber(1)
+	if i, ok := number.FloatToInteger(seed); ok {
+		g.r.Seed(i)
+	} else {
+		g.r.Seed(int64(math.Float64bits(seed)))
+	}
+
+	return 0
+}
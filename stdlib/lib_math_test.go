@@ -0,0 +1,55 @@
+package stdlib_test
+
+import "testing"
+
+func TestRandom(t *testing.T) {
+	// every lua state starts with the same seed
+	const sequence = `(function()
+		local t = {}
+		for i = 1, 10 do t[i] = math.random(1000) end
+		return table.concat(t, ",")
+	end)()`
+	first := eval(t, sequence)
+	if again := eval(t, sequence); again != first {
+		t.Errorf("sequences of new lua states differ: %s and %s", first, again)
+	}
+
+	runEvalTests(t, []evalTest{
+		// the same seed gives the same sequence
+		{`(function()
+			math.randomseed(42)
+			local a, b, c = math.random(), math.random(1, 100), math.random(-5, 5)
+			math.randomseed(42)
+			return a == math.random() and b == math.random(1, 100) and c == math.random(-5, 5)
+		end)()`, "true"},
+		{`(function()
+			math.randomseed(1.5)
+			local a = math.random(1 << 40)
+			math.randomseed(1.5)
+			return a == math.random(1 << 40)
+		end)()`, "true"},
+		{`(function()
+			math.randomseed(1)
+			local a = math.random(1 << 40)
+			math.randomseed(2)
+			return a ~= math.random(1 << 40)
+		end)()`, "true"},
+		// ranges
+		{`(function()
+			for i = 1, 1000 do
+				local f, n, m = math.random(), math.random(3), math.random(-2, 2)
+				if f < 0 or f >= 1 or n < 1 or n > 3 or m < -2 or m > 2 then return f, n, m end
+			end
+			return "ok"
+		end)()`, "ok"},
+		{`math.random(7, 7), math.type(math.random(7, 7)), math.type(math.random())`, "7\tinteger\tfloat"},
+		{`math.random(math.maxinteger, math.maxinteger)`, "9223372036854775807"},
+		// errors
+		{`math.random(0)`, "error: bad argument #1 to 'random' (interval is empty)"},
+		{`math.random(2, 1)`, "error: bad argument #1 to 'random' (interval is empty)"},
+		{`math.random(math.mininteger, math.maxinteger)`, "error: bad argument #1 to 'random' (interval too large)"},
+		{`math.random(1, 2, 3)`, "error: wrong number of arguments"},
+		{`math.random(1.5)`, "error: bad argument #1 to 'random' (number has no integer representation)"},
+		{`math.randomseed()`, "error: bad argument #1 to 'randomseed' (number expected, got no value)"},
+	})
+}
@@ -0,0 +1,308 @@
+/*
+The os library. What it learns about the world goes through the Clock and the
+Env, so that tests can freeze the time and sandboxes can hide the environment
+variables of the host, and files are handled through the filesystem of the io
+library: OpenOS uses the real ones, NewOSLib takes others.
+
+Time is in seconds since the Unix epoch, and dates are in the location of the
+clock, which is what Now() returns its time in.
+*/
+package stdlib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"syscall"
+	"time"
+
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+// Clock is where the os library gets the time.
+type Clock interface {
+	Now() time.Time         // for os.time and os.date
+	CPUTime() time.Duration // processor time used by the program, for os.clock
+}
+
+// Env holds the environment variables for os.getenv.
+type Env interface {
+	LookupEnv(key string) (string, bool)
+}
+
+// the clock of the system, Go can't tell the processor time of the program
+// portably so the time since the library is opened is taken for it
+type systemClock struct {
+	start time.Time
+}
+
+func (c systemClock) Now() time.Time         { return time.Now() }
+func (c systemClock) CPUTime() time.Duration { return time.Since(c.start) }
+
+// environment variables of the process
+type systemEnv struct{}
+
+func (systemEnv) LookupEnv(key string) (string, bool) { return os.LookupEnv(key) }
+
+// SystemClock returns the clock of the system, which OpenOS uses.
+func SystemClock() Clock {
+	return systemClock{time.Now()}
+}
+
+// SystemEnv returns the environment variables of the process, which OpenOS uses.
+func SystemEnv() Env {
+	return systemEnv{}
+}
+
+// OpenOS opens the os library with the system clock, the environment and the
+// files of the process. os.exit only aborts the script, see NewOSLib.
+func OpenOS(ls LuaState) int {
+	return NewOSLib(SystemClock(), SystemEnv(), SystemFS(), nil)(ls)
+}
+
+// NewOSLib returns the opener of an os library using the clock and the env,
+// to be given to RequireF() like OpenOS. os.remove, os.rename and os.tmpname
+// work on the files of fsys, which is meant to be the one of the io library,
+// and fail unless it implements WritableFS. os.exit calls exit with the exit
+// code if it isn't nil, like os.Exit for a standalone interpreter; then, or if
+// exit returns, it raises an uncatchable error wrapping an *api.ExitError, so
+// that embedding hosts are never killed by scripts.
+func NewOSLib(clock Clock, env Env, fsys fs.FS, exit func(code int)) GoFunction {
+	lib := &osLib{clock, env, fsys, exit}
+	return func(ls LuaState) int {
+		ls.NewLib(FuncReg{
+			"clock":    lib.clock,
+			"date":     lib.date,
+			"difftime": osDiffTime,
+			"exit":     lib.exit,
+			"getenv":   lib.getenv,
+			"remove":   lib.remove,
+			"rename":   lib.rename,
+			"time":     lib.time,
+			"tmpname":  lib.tmpName,
+		})
+		return 1
+	}
+}
+
+type osLib struct {
+	c      Clock
+	env    Env
+	fsys   fs.FS
+	exitFn func(code int) // nil if os.exit only raises the error
+}
+
+// os.clock ()
+func (lib *osLib) clock(ls LuaState) int {
+	ls.PushNumber(lib.c.CPUTime().Seconds())
+	return 1
+}
+
+// os.getenv (varname)
+func (lib *osLib) getenv(ls LuaState) int {
+	if v, ok := lib.env.LookupEnv(ls.CheckString(1)); ok {
+		ls.PushString(v)
+	} else {
+		ls.PushNil()
+	}
+	return 1
+}
+
+// os.time ([table])
+// Without the table it's the current time, otherwise the time of the date
+// in the table, whose fields are normalized like 'month = 14' is February of
+// the next year, and updated to the normalized values.
+func (lib *osLib) time(ls LuaState) int {
+	if ls.IsNoneOrNil(1) {
+		ls.PushInteger(lib.c.Now().Unix())
+		return 1
+	}
+
+	ls.CheckType(1, LUA_TTABLE)
+	ls.SetTop(1) // make sure the table is at the top
+	year := dateField(ls, "year", -1, 1900)
+	month := dateField(ls, "month", -1, 1)
+	day := dateField(ls, "day", -1, 0)
+	hour := dateField(ls, "hour", 12, 0)
+	min := dateField(ls, "min", 0, 0)
+	sec := dateField(ls, "sec", 0, 0)
+
+	t := time.Date(year, time.Month(month), day, hour, min, sec, 0, lib.c.Now().Location())
+	setDateFields(ls, t)
+	ls.PushInteger(t.Unix())
+	return 1
+}
+
+// Get the integer field of the date table at the top, d is the default value
+// if it's absent or negative if it's required. The field is stored with delta
+// subtracted in C so it must fit an int after that.
+func dateField(ls LuaState, key string, d, delta int64) int {
+	t := ls.GetField(-1, key)
+	res, isNum := ls.ToIntegerX(-1)
+	ls.Pop(1)
+
+	if !isNum {
+		if t != LUA_TNIL {
+			ls.Errorf("field '%s' is not an integer", key)
+		} else if d < 0 {
+			ls.Errorf("field '%s' missing in date table", key)
+		}
+		return int(d)
+	}
+	if res >= 0 && res-delta > math.MaxInt32 || res < 0 && res < math.MinInt32+delta {
+		ls.Errorf("field '%s' is out-of-bound", key)
+	}
+	return int(res)
+}
+
+// Set the fields of the date table at the top.
+func setDateFields(ls LuaState, t time.Time) {
+	setField := func(key string, value int) {
+		ls.PushInteger(int64(value))
+		ls.SetField(-2, key)
+	}
+	setField("sec", t.Second())
+	setField("min", t.Minute())
+	setField("hour", t.Hour())
+	setField("day", t.Day())
+	setField("month", int(t.Month()))
+	setField("year", t.Year())
+	setField("wday", int(t.Weekday())+1)
+	setField("yday", t.YearDay())
+	ls.PushBoolean(isDST(t))
+	ls.SetField(-2, "isdst")
+}
+
+// Report whether daylight saving time is in effect, which is when the offset
+// is beyond the smaller one of January and July in the year.
+func isDST(t time.Time) bool {
+	_, offset := t.Zone()
+	_, jan := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location()).Zone()
+	_, jul := time.Date(t.Year(), time.July, 1, 0, 0, 0, 0, t.Location()).Zone()
+	if jul < jan {
+		jan = jul
+	}
+	return offset > jan
+}
+
+// os.date ([format [, time]])
+// Format the time, the current one by default, like strftime() in C does
+// in the C locale. A format starting with '!' formats it in UTC, "*t" gives
+// a table with the fields of the date instead.
+func (lib *osLib) date(ls LuaState) int {
+	format := ls.OptString(1, "%c")
+	now := lib.c.Now()
+	t := now
+	if !ls.IsNoneOrNil(2) {
+		t = time.Unix(ls.CheckInteger(2), 0).In(now.Location())
+	}
+
+	if len(format) > 0 && format[0] == '!' {
+		format = format[1:]
+		t = t.UTC()
+	}
+	if len(format) >= 2 && format[:2] == "*t" {
+		ls.CreateTable(0, 9)
+		setDateFields(ls, t)
+		return 1
+	}
+
+	s, err := strftime(format, t)
+	if err != nil {
+		return ls.ArgError(1, err.Error())
+	}
+	ls.PushString(s)
+	return 1
+}
+
+// os.difftime (t2, t1)
+func osDiffTime(ls LuaState) int {
+	t2 := ls.CheckInteger(1)
+	t1 := ls.OptInteger(2, 0)
+	ls.PushNumber(float64(t2) - float64(t1))
+	return 1
+}
+
+// os.exit ([code [, close]])
+// The code is true for success by default, the state is never closed since
+// the Go GC frees it anyway.
+func (lib *osLib) exit(ls LuaState) int {
+	code := 0
+	if ls.IsBoolean(1) {
+		if !ls.ToBoolean(1) {
+			code = 1
+		}
+	} else {
+		code = int(ls.OptInteger(1, 0))
+	}
+
+	if lib.exitFn != nil {
+		lib.exitFn(code)
+	}
+	err := &ExitError{Code: code}
+	panic(&LuaError{Value: err.Error(), Status: LUA_ERRRUN, Uncatchable: true, Err: err})
+}
+
+// os.remove (filename)
+func (lib *osLib) remove(ls LuaState) int {
+	name := ls.CheckString(1)
+	wfs, err := writable(lib.fsys, "remove", name)
+	if err == nil {
+		err = wfs.Remove(name)
+	}
+	return fileResult(ls, err, name)
+}
+
+// os.rename (oldname, newname)
+func (lib *osLib) rename(ls LuaState) int {
+	oldName := ls.CheckString(1)
+	newName := ls.CheckString(2)
+	wfs, err := writable(lib.fsys, "rename", oldName)
+	if err == nil {
+		err = wfs.Rename(oldName, newName)
+	}
+	return fileResult(ls, err, "")
+}
+
+// os.tmpname ()
+// The file is created to reserve its name, the script should remove it.
+func (lib *osLib) tmpName(ls LuaState) int {
+	name, err := createTemp(lib.fsys)
+	if err != nil {
+		return ls.Errorf("unable to generate a unique filename")
+	}
+
+	ls.PushString(name)
+	return 1
+}
+
+// Push true if err is nil, otherwise nil, the message and the error number
+// like C functions do, where the message starts with the file name if any.
+func fileResult(ls LuaState, err error, name string) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		return 1
+	}
+
+	// the message of the error without the operation and the file names
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	} else if errors.As(err, &linkErr) {
+		err = linkErr.Err
+	}
+	var errno syscall.Errno
+	errors.As(err, &errno)
+
+	ls.PushNil()
+	if name != "" {
+		ls.PushString(fmt.Sprintf("%s: %v", name, err))
+	} else {
+		ls.PushString(err.Error())
+	}
+	ls.PushInteger(int64(errno))
+	return 3
+}
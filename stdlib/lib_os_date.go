@@ -0,0 +1,138 @@
+package stdlib
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// conversions of strftime() in C99, each is one or two characters after '%'
+var strftimeOptions = []string{
+	"a", "A", "b", "B", "c", "C", "d", "D", "e", "F", "g", "G", "h", "H", "I",
+	"j", "m", "M", "n", "p", "r", "R", "S", "t", "T", "u", "U", "V", "w", "W",
+	"x", "X", "y", "Y", "z", "Z", "%",
+	"Ec", "EC", "Ex", "EX", "Ey", "EY",
+	"Od", "Oe", "OH", "OI", "Om", "OM", "OS", "Ou", "OU", "OV", "Ow", "OW", "Oy",
+}
+
+// conversions that are made of others in the C locale
+var strftimeComposites = map[byte]string{
+	'c': "%a %b %e %H:%M:%S %Y",
+	'D': "%m/%d/%y",
+	'F': "%Y-%m-%d",
+	'r': "%I:%M:%S %p",
+	'R': "%H:%M",
+	'T': "%H:%M:%S",
+	'x': "%m/%d/%y",
+	'X': "%H:%M:%S",
+}
+
+// Format the time like strftime() in C does in the C locale. The 'E' and 'O'
+// modifiers make no difference there. An invalid conversion is reported by
+// an error.
+func strftime(format string, t time.Time) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != L_ESC {
+			b.WriteByte(format[i])
+			continue
+		}
+
+		conv := ""
+		for _, opt := range strftimeOptions {
+			if strings.HasPrefix(format[i+1:], opt) {
+				conv = opt
+				break
+			}
+		}
+		if conv == "" {
+			return "", fmt.Errorf("invalid conversion specifier '%%%s'", format[i+1:])
+		}
+		i += len(conv)
+		strftimeConv(&b, conv[len(conv)-1], t)
+	}
+
+	return b.String(), nil
+}
+
+func strftimeConv(b *strings.Builder, c byte, t time.Time) {
+	if composite, ok := strftimeComposites[c]; ok {
+		s, _ := strftime(composite, t)
+		b.WriteString(s)
+		return
+	}
+
+	wday := int(t.Weekday())
+	yday := t.YearDay() - 1 // from 0
+	switch c {
+	case 'a':
+		b.WriteString(t.Weekday().String()[:3])
+	case 'A':
+		b.WriteString(t.Weekday().String())
+	case 'b', 'h':
+		b.WriteString(t.Month().String()[:3])
+	case 'B':
+		b.WriteString(t.Month().String())
+	case 'C':
+		fmt.Fprintf(b, "%02d", t.Year()/100)
+	case 'd':
+		fmt.Fprintf(b, "%02d", t.Day())
+	case 'e':
+		fmt.Fprintf(b, "%2d", t.Day())
+	case 'g':
+		year, _ := t.ISOWeek()
+		fmt.Fprintf(b, "%02d", year%100)
+	case 'G':
+		year, _ := t.ISOWeek()
+		fmt.Fprintf(b, "%d", year)
+	case 'H':
+		fmt.Fprintf(b, "%02d", t.Hour())
+	case 'I':
+		fmt.Fprintf(b, "%02d", (t.Hour()+11)%12+1)
+	case 'j':
+		fmt.Fprintf(b, "%03d", yday+1)
+	case 'm':
+		fmt.Fprintf(b, "%02d", int(t.Month()))
+	case 'M':
+		fmt.Fprintf(b, "%02d", t.Minute())
+	case 'n':
+		b.WriteByte('\n')
+	case 'p':
+		if t.Hour() < 12 {
+			b.WriteString("AM")
+		} else {
+			b.WriteString("PM")
+		}
+	case 'S':
+		fmt.Fprintf(b, "%02d", t.Second())
+	case 't':
+		b.WriteByte('\t')
+	case 'u': // Monday is 1 and Sunday is 7
+		fmt.Fprintf(b, "%d", (wday+6)%7+1)
+	case 'U': // weeks start on Sunday, days before the first Sunday are in week 0
+		fmt.Fprintf(b, "%02d", (yday+7-wday)/7)
+	case 'V':
+		_, week := t.ISOWeek()
+		fmt.Fprintf(b, "%02d", week)
+	case 'w':
+		fmt.Fprintf(b, "%d", wday)
+	case 'W': // like 'U' but weeks start on Monday
+		fmt.Fprintf(b, "%02d", (yday+7-(wday+6)%7)/7)
+	case 'y':
+		fmt.Fprintf(b, "%02d", t.Year()%100)
+	case 'Y':
+		fmt.Fprintf(b, "%d", t.Year())
+	case 'z':
+		_, offset := t.Zone()
+		sign := '+'
+		if offset < 0 {
+			sign, offset = '-', -offset
+		}
+		fmt.Fprintf(b, "%c%02d%02d", sign, offset/3600, offset/60%60)
+	case 'Z':
+		name, _ := t.Zone()
+		b.WriteString(name)
+	case '%':
+		b.WriteByte('%')
+	}
+}
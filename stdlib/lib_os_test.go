@@ -0,0 +1,65 @@
+package stdlib_test
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gonearewe/lua-compiler/state"
+	"github.com/gonearewe/lua-compiler/stdlib"
+)
+
+// a clock stopped at the time
+type frozenClock struct {
+	t time.Time
+}
+
+func (c frozenClock) Now() time.Time         { return c.t }
+func (c frozenClock) CPUTime() time.Duration { return 1500 * time.Millisecond }
+
+type mapEnv map[string]string
+
+func (e mapEnv) LookupEnv(key string) (string, bool) {
+	v, ok := e[key]
+	return v, ok
+}
+
+func TestOSClock(t *testing.T) {
+	zone := time.FixedZone("UTC+8", 8*60*60)
+	now := time.Date(2024, time.February, 29, 13, 4, 5, 0, zone) // a Thursday
+	ls := state.New()
+	ls.RequireF("os", stdlib.NewOSLib(frozenClock{now}, mapEnv{"HOME": "/home/lua"}, fstest.MapFS{}, nil), false)
+	ls.Pop(1)
+	stdlib.OpenLibs(ls)
+
+	tests := []evalTest{
+		{`os.time()`, "1709183045"},
+		{`os.clock()`, "1.5"},
+		{`os.date("%Y-%m-%d %H:%M:%S")`, "2024-02-29 13:04:05"},
+		{`os.date("!%Y-%m-%d %H:%M:%S")`, "2024-02-29 05:04:05"},
+		{`os.date("%c")`, "Thu Feb 29 13:04:05 2024"},
+		{`os.date("%A %B %j %p %y %%")`, "Thursday February 060 PM 24 %"},
+		{`os.date("%Y-%m-%d", 0)`, "1970-01-01"},
+		{`os.date("!%H", 3600)`, "01"},
+		{`(function() local d = os.date("*t") return d.year, d.month, d.day, d.hour, d.wday, d.yday, d.isdst end)()`,
+			"2024\t2\t29\t13\t5\t60\tfalse"},
+		// round trips
+		{`os.time(os.date("*t")) == os.time()`, "true"},
+		{`os.date("%c", os.time{year = 2024, month = 2, day = 29, hour = 13, min = 4, sec = 5})`, "Thu Feb 29 13:04:05 2024"},
+		{`os.time{year = 2024, month = 2, day = 29}`, "1709179200"}, // 12:00 by default
+		// normalized fields
+		{`(function() local d = {year = 2024, month = 14, day = 0} os.time(d) return d.year, d.month, d.day end)()`,
+			"2025\t1\t31"},
+		{`os.difftime(os.time(), os.time{year = 2024, month = 2, day = 28, hour = 13, min = 4, sec = 5})`, "86400.0"},
+		{`os.getenv("HOME"), os.getenv("PATH")`, "/home/lua\tnil"},
+		// errors
+		{`os.time{year = 2024, month = 1}`, "error: field 'day' missing in date table"},
+		{`os.time{year = 2024, month = 1, day = 1.5}`, "error: field 'day' is not an integer"},
+		{`os.date("%Ez")`, "error: bad argument #1 to 'date' (invalid conversion specifier '%Ez')"},
+	}
+	for _, test := range tests {
+		if got := evalIn(t, ls, test.exps); got != test.want {
+			t.Errorf("%s = %q, want %q", test.exps, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+/*
+The string library, strings share a metatable whose "__index" is
+the library, so that its functions can be called as methods.
+Strings are sequences of bytes, indices may be negative to count from the end.
+*/
+package stdlib
+
+import (
+	"math"
+	"strings"
+
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+var strLib = FuncReg{
+	"byte":     strByte,
+	"char":     strChar,
+	"find":     strFind,
+	"format":   strFormat,
+	"gmatch":   strGmatch,
+	"gsub":     strGsub,
+	"len":      strLen,
+	"lower":    strLower,
+	"match":    strMatch,
+	"pack":     strPack,
+	"packsize": strPackSize,
+	"rep":      strRep,
+	"reverse":  strReverse,
+	"sub":      strSub,
+	"unpack":   strUnpack,
+	"upper":    strUpper,
+}
+
+// strings longer than this are considered too large to build
+const MAX_STRING_SIZE = math.MaxInt32
+
+func OpenString(ls LuaState) int {
+	ls.NewLib(strLib)
+	createStrMetatable(ls)
+
+	return 1
+}
+
+// Create the metatable for strings with the library on the top of the stack as "__index".
+func createStrMetatable(ls LuaState) {
+	ls.CreateTable(0, 1) // table to be metatable for strings
+	ls.PushString("")    // dummy string
+	ls.PushValue(-2)     // copy table
+	ls.SetMetatable(-2)  // set table as metatable for strings
+	ls.Pop(1)            // pop dummy string
+	ls.PushValue(-2)     // get string library
+	ls.SetField(-2, "__index")
+	ls.Pop(1) // pop metatable
+}
+
+// Translate a relative string position: negative means back from end.
+func posRelat(pos int64, l int) int64 {
+	if pos >= 0 {
+		return pos
+	} else if -pos > int64(l) {
+		return 0
+	}
+	return int64(l) + pos + 1
+}
+
+// string.len (s)
+func strLen(ls LuaState) int {
+	s := ls.CheckString(1)
+	ls.PushInteger(int64(len(s)))
+
+	return 1
+}
+
+// string.sub (s, i [, j])
+func strSub(ls LuaState) int {
+	s := ls.CheckString(1)
+	l := int64(len(s))
+	i := posRelat(ls.CheckInteger(2), len(s))
+	j := posRelat(ls.OptInteger(3, -1), len(s))
+	if i < 1 {
+		i = 1
+	}
+	if j > l {
+		j = l
+	}
+
+	if i <= j {
+		ls.PushString(s[i-1 : j])
+	} else {
+		ls.PushString("")
+	}
+	return 1
+}
+
+// string.byte (s [, i [, j]])
+func strByte(ls LuaState) int {
+	s := ls.CheckString(1)
+	l := int64(len(s))
+	i := posRelat(ls.OptInteger(2, 1), len(s))
+	j := posRelat(ls.OptInteger(3, i), len(s))
+	if i < 1 {
+		i = 1
+	}
+	if j > l {
+		j = l
+	}
+	if i > j {
+		return 0 // empty interval; return no values
+	}
+	if j-i >= math.MaxInt32 { // arithmetic overflow?
+		return ls.Errorf("string slice too long")
+	}
+
+	n := int(j-i) + 1
+	ls.CheckStack(n)
+	for k := 0; k < n; k++ {
+		ls.PushInteger(int64(s[int(i)+k-1]))
+	}
+	return n
+}
+
+// string.char (···)
+func strChar(ls LuaState) int {
+	n := ls.GetTop()
+	b := make([]byte, n)
+	for i := 1; i <= n; i++ {
+		c := ls.CheckInteger(i)
+		ls.ArgCheck(uint64(c) <= math.MaxUint8, i, "value out of range")
+		b[i-1] = byte(c)
+	}
+
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.rep (s, n [, sep])
+func strRep(ls LuaState) int {
+	s := ls.CheckString(1)
+	n := ls.CheckInteger(2)
+	sep := ls.OptString(3, "")
+
+	if n <= 0 {
+		ls.PushString("")
+	} else if l := int64(len(s) + len(sep)); l > 0 && n > MAX_STRING_SIZE/l {
+		return ls.Errorf("resulting string too large")
+	} else if sep == "" {
+		ls.PushString(strings.Repeat(s, int(n)))
+	} else {
+		ls.PushString(strings.Repeat(s+sep, int(n-1)) + s)
+	}
+
+	return 1
+}
+
+// string.reverse (s)
+func strReverse(ls LuaState) int {
+	s := ls.CheckString(1)
+	b := make([]byte, len(s))
+	for i := range s {
+		b[len(s)-1-i] = s[i]
+	}
+
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.lower (s)
+// Only ASCII letters are changed, like in the C locale.
+func strLower(ls LuaState) int {
+	s := ls.CheckString(1)
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			b[i] = c + ('a' - 'A')
+		}
+	}
+
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.upper (s)
+// Only ASCII letters are changed, like in the C locale.
+func strUpper(ls LuaState) int {
+	s := ls.CheckString(1)
+	b := []byte(s)
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' {
+			b[i] = c - ('a' - 'A')
+		}
+	}
+
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.find (s, pattern [, init [, plain]])
+func strFind(ls LuaState) int {
+	return strFindAux(ls, true)
+}
+
+// string.match (s, pattern [, init])
+func strMatch(ls LuaState) int {
+	return strFindAux(ls, false)
+}
+
+func strFindAux(ls LuaState, find bool) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	init := posRelat(ls.OptInteger(3, 1), len(s))
+	if init < 1 {
+		init = 1
+	} else if init > int64(len(s))+1 { // start after string's end?
+		ls.PushNil() // cannot find anything
+		return 1
+	}
+
+	// explicit request or no special characters?
+	if find && (ls.ToBoolean(4) || !strings.ContainsAny(p, SPECIALS)) {
+		// do a plain search
+		if i := strings.Index(s[init-1:], p); i >= 0 {
+			start := int(init) - 1 + i
+			ls.PushInteger(int64(start) + 1)
+			ls.PushInteger(int64(start + len(p)))
+			return 2
+		}
+	} else {
+		anchor := strings.HasPrefix(p, "^")
+		if anchor {
+			p = p[1:] // skip anchor character
+		}
+
+		ms := newMatchState(ls, s, p)
+		for s1 := int(init) - 1; s1 <= len(s); s1++ {
+			ms.reprep()
+			if e := ms.match(s1, 0); e != -1 {
+				if find {
+					ls.PushInteger(int64(s1) + 1) // start
+					ls.PushInteger(int64(e))      // end
+					return ms.pushCaptures(-1, -1, false) + 2
+				}
+				return ms.pushCaptures(s1, e, true)
+			}
+			if anchor {
+				break
+			}
+		}
+	}
+
+	ls.PushNil() // not found
+	return 1
+}
+
+// string.gmatch (s, pattern)
+// Return an iterator function that returns the captures of
+// the next match each time it's called.
+func strGmatch(ls LuaState) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	ms := newMatchState(ls, s, p)
+	src, lastMatch := 0, -1
+
+	ls.PushGoFunction(func(ls LuaState) int {
+		ms.ls = ls
+		for ; src <= len(s); src++ {
+			ms.reprep()
+			if e := ms.match(src, 0); e != -1 && e != lastMatch {
+				start := src
+				src, lastMatch = e, e
+				return ms.pushCaptures(start, e, true)
+			}
+		}
+		return 0 // not found
+	})
+	return 1
+}
+
+// string.gsub (s, pattern, repl [, n])
+// The replacement may be a string, a table or a function.
+func strGsub(ls LuaState) int {
+	src := ls.CheckString(1)
+	p := ls.CheckString(2)
+	tr := ls.Type(3) // replacement type
+	maxS := ls.OptInteger(4, int64(len(src))+1)
+	ls.ArgCheck(tr == LUA_TNUMBER || tr == LUA_TSTRING ||
+		tr == LUA_TFUNCTION || tr == LUA_TTABLE, 3, "string/function/table expected")
+
+	anchor := strings.HasPrefix(p, "^")
+	if anchor {
+		p = p[1:] // skip anchor character
+	}
+
+	var b strings.Builder
+	ms := newMatchState(ls, src, p)
+	s, lastMatch := 0, -1
+	n := int64(0) // replacement count
+	for n < maxS {
+		ms.reprep()
+		if e := ms.match(s, 0); e != -1 && e != lastMatch { // match?
+			n++
+			addValue(ms, &b, s, e, tr) // add replacement to buffer
+			s, lastMatch = e, e
+		} else if s < len(src) { // otherwise, skip one character
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break // end of subject
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[s:])
+
+	ls.PushString(b.String())
+	ls.PushInteger(n) // number of substitutions
+	return 2
+}
+
+// Add the replacement value of the match between s and e to the buffer.
+func addValue(ms *matchState, b *strings.Builder, s, e int, tr LuaType) {
+	ls := ms.ls
+	switch tr {
+	case LUA_TFUNCTION:
+		ls.PushValue(3)
+		n := ms.pushCaptures(s, e, true)
+		ls.Call(n, 1) // call it
+	case LUA_TTABLE:
+		ms.pushOneCapture(0, s, e) // index the table with the first capture
+		ls.GetTable(3)
+	default: // LUA_TNUMBER or LUA_TSTRING
+		addString(ms, b, s, e)
+		return
+	}
+
+	if !ls.ToBoolean(-1) { // nil or false?
+		b.WriteString(ms.src[s:e]) // keep original text
+	} else if !ls.IsString(-1) {
+		ls.Errorf("invalid replacement value (a %s)", ls.TypeNameOf(-1))
+	} else {
+		b.WriteString(ls.ToString(-1)) // add result to accumulator
+	}
+	ls.Pop(1)
+}
+
+// Add the replacement string to the buffer, where "%d" stands for
+// the dth capture, "%0" for the whole match and "%%" for a single '%'.
+func addString(ms *matchState, b *strings.Builder, s, e int) {
+	ls := ms.ls
+	news := ls.ToString(3)
+	for i := 0; i < len(news); i++ {
+		if news[i] != L_ESC {
+			b.WriteByte(news[i])
+			continue
+		}
+
+		i++ // skip ESC
+		var c byte
+		if i < len(news) {
+			c = news[i]
+		}
+		if !isDigit(c) {
+			if c != L_ESC {
+				ls.Errorf("invalid use of '%c' in replacement string", L_ESC)
+			}
+			b.WriteByte(c) // %%
+		} else if c == '0' {
+			b.WriteString(ms.src[s:e])
+		} else {
+			ms.pushOneCapture(int(c-'1'), s, e)
+			b.WriteString(ls.ToStringMeta(-1)) // if number, convert it to string
+			ls.Pop(2)                          // remove original value and its string form
+		}
+	}
+}
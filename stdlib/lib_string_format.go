@@ -0,0 +1,225 @@
+package stdlib
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+const L_FMTFLAGS = "-+ #0" // valid flags in a format specification
+
+// Go writes exponents of hexadecimal floats with at least two digits, C doesn't
+var reHexExponent = regexp.MustCompile(`([pP][+-])0+([0-9])`)
+
+// string.format (formatstring, ···)
+// The directives are the ones of C's printf, with option 'q' for
+// a literal form of the value; '*', 'h', 'l', 'L' and 'n' are not supported.
+func strFormat(ls LuaState) int {
+	top := ls.GetTop()
+	arg := 1
+	fmtStr := ls.CheckString(arg)
+
+	var b strings.Builder
+	for i := 0; i < len(fmtStr); i++ {
+		if fmtStr[i] != L_ESC {
+			b.WriteByte(fmtStr[i])
+			continue
+		}
+		i++
+		if i < len(fmtStr) && fmtStr[i] == L_ESC {
+			b.WriteByte(L_ESC) // %%
+			continue
+		}
+
+		// format item
+		arg++
+		if arg > top {
+			ls.ArgError(arg, "no value")
+		}
+		spec := scanFormat(ls, fmtStr[i:])
+		i += len(spec)
+		if i >= len(fmtStr) {
+			ls.Errorf("invalid conversion '%%%s' to 'format'", spec)
+		}
+		conv := fmtStr[i]
+
+		switch conv {
+		case 'c':
+			b.WriteString(padString(spec, string([]byte{byte(ls.CheckInteger(arg))})))
+		case 'd', 'i':
+			b.WriteString(fmt.Sprintf("%"+spec+"d", ls.CheckInteger(arg)))
+		case 'o', 'u', 'x', 'X': // integers are converted to unsigned ones like C does
+			verb := conv
+			if conv == 'u' {
+				verb = 'd'
+			}
+			b.WriteString(fmt.Sprintf("%"+spec+string(verb), uint64(ls.CheckInteger(arg))))
+		case 'a', 'A', 'e', 'E', 'f', 'F', 'g', 'G':
+			b.WriteString(formatFloat(spec, conv, ls.CheckNumber(arg)))
+		case 'q':
+			if spec != "" {
+				ls.Errorf("specifier '%%q' cannot have modifiers")
+			}
+			addLiteral(ls, &b, arg)
+		case 's':
+			s := ls.ToStringMeta(arg)
+			if !strings.Contains(spec, ".") && len(s) >= 100 {
+				// no precision and string is too long to be formatted
+				b.WriteString(s) // keep entire string
+			} else {
+				b.WriteString(padString(spec, s))
+			}
+			ls.Pop(1)
+		default: // also treat cases 'pnLlh'
+			ls.Errorf("invalid option '%%%c' to 'format'", conv)
+		}
+	}
+
+	ls.PushString(b.String())
+	return 1
+}
+
+// Return the flags, width and precision at the beginning of the string.
+func scanFormat(ls LuaState, s string) string {
+	p := 0
+	for p < len(s) && strings.IndexByte(L_FMTFLAGS, s[p]) >= 0 {
+		p++ // skip flags
+	}
+	if p > len(L_FMTFLAGS) {
+		ls.Errorf("invalid format (repeated flags)")
+	}
+
+	digits := func() {
+		for n := 0; n < 2 && p < len(s) && isDigit(s[p]); n++ {
+			p++ // 2 digits at most
+		}
+	}
+	digits() // skip width
+	if p < len(s) && s[p] == '.' {
+		p++
+		digits() // skip precision
+	}
+	if p < len(s) && isDigit(s[p]) {
+		ls.Errorf("invalid format (width or precision too long)")
+	}
+
+	return s[:p]
+}
+
+// Format the float like C does, whose default precision of '%g'
+// is 6 and infinities and NaNs are written as "inf" and "nan".
+func formatFloat(spec string, conv byte, f float64) string {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		s := "inf"
+		if math.IsNaN(f) {
+			s = "nan"
+		} else if f < 0 {
+			s = "-inf"
+		} else if strings.Contains(spec, "+") {
+			s = "+inf"
+		}
+		if conv >= 'A' && conv <= 'Z' {
+			s = strings.ToUpper(s)
+		}
+		return padString(strings.Replace(spec, "0", "", -1), s)
+	}
+
+	switch conv {
+	case 'a', 'A':
+		verb := "x"
+		if conv == 'A' {
+			verb = "X"
+		}
+		return reHexExponent.ReplaceAllString(fmt.Sprintf("%"+spec+verb, f), "$1$2")
+	case 'g', 'G':
+		if !strings.Contains(spec, ".") {
+			spec += ".6"
+		}
+	case 'F':
+		conv = 'f'
+	}
+	return fmt.Sprintf("%"+spec+string(conv), f)
+}
+
+// Format the string with the flags, width and precision of the spec,
+// which are counted in bytes.
+func padString(spec, s string) string {
+	var width, precision int
+	leftAlign := strings.Contains(spec, "-")
+	spec = strings.TrimLeft(spec, L_FMTFLAGS)
+	if i := strings.IndexByte(spec, '.'); i >= 0 {
+		fmt.Sscan(spec[i+1:], &precision)
+		if precision < len(s) {
+			s = s[:precision]
+		}
+		spec = spec[:i]
+	}
+	fmt.Sscan(spec, &width)
+
+	if pad := width - len(s); pad > 0 {
+		if leftAlign {
+			return s + strings.Repeat(" ", pad)
+		}
+		return strings.Repeat(" ", pad) + s
+	}
+	return s
+}
+
+// Add the value as it would be written in lua source code.
+func addLiteral(ls LuaState, b *strings.Builder, arg int) {
+	switch ls.Type(arg) {
+	case LUA_TSTRING:
+		addQuoted(b, ls.ToString(arg))
+	case LUA_TNUMBER:
+		if !ls.IsInteger(arg) { // float?
+			b.WriteString(quoteFloat(ls.ToNumber(arg)))
+		} else if n := ls.ToInteger(arg); n == math.MinInt64 { // corner case?
+			b.WriteString(fmt.Sprintf("0x%x", uint64(n))) // use hexa
+		} else {
+			b.WriteString(fmt.Sprintf("%d", n))
+		}
+	case LUA_TNIL, LUA_TBOOLEAN:
+		b.WriteString(ls.ToStringMeta(arg))
+		ls.Pop(1)
+	default:
+		ls.ArgError(arg, "value has no literal form")
+	}
+}
+
+func addQuoted(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\' || c == '\n':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 32 || c == 127: // control characters
+			if i+1 < len(s) && isDigit(s[i+1]) {
+				b.WriteString(fmt.Sprintf("\\%03d", c))
+			} else {
+				b.WriteString(fmt.Sprintf("\\%d", c))
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+}
+
+// Floats are written in hexadecimal to preserve their values.
+func quoteFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "1e9999"
+	case math.IsInf(f, -1):
+		return "-1e9999"
+	case math.IsNaN(f):
+		return "(0/0)"
+	default:
+		return formatFloat("", 'a', f)
+	}
+}
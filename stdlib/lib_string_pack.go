@@ -0,0 +1,365 @@
+package stdlib
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+/**************************
+binary packing of values like the reference implementation,
+sizes of C types are the ones of 64-bit platforms
+**************************/
+
+const (
+	MAXINTSIZE  = 16 // maximum size for the binary representation of an integer
+	NB          = 8  // number of bits in a byte
+	SZINT       = 8  // size of a lua integer
+	MAXALIGN    = 8  // alignment selected by option '!' without size
+	PACKPADBYTE = 0x00
+	MAXSIZE     = math.MaxInt32 // maximum size of a packed result
+)
+
+// options of a format
+type kOption int
+
+const (
+	kInt       kOption = iota // signed integers
+	kUint                     // unsigned integers
+	kFloat                    // floating-point numbers
+	kChar                     // fixed-length strings
+	kString                   // strings with prefixed length
+	kZstr                     // zero-terminated strings
+	kPadding                  // padding
+	kPaddAlign                // padding for alignment
+	kNop                      // no-op (configuration or spaces)
+)
+
+// information to pack/unpack stuff
+type packHeader struct {
+	ls       LuaState
+	little   bool
+	maxAlign int
+}
+
+func newPackHeader(ls LuaState) *packHeader {
+	return &packHeader{ls: ls, little: true, maxAlign: 1} // native little endian
+}
+
+// Read an integer numeral from the format, return df if there is none.
+func getNum(fmt *string, df int) int {
+	if *fmt == "" || !isDigit((*fmt)[0]) { // no number?
+		return df // return default value
+	}
+
+	a := 0
+	for *fmt != "" && isDigit((*fmt)[0]) && a <= (MAXSIZE-9)/10 {
+		a = a*10 + int((*fmt)[0]-'0')
+		*fmt = (*fmt)[1:]
+	}
+	return a
+}
+
+// Read an integer numeral and raise an error if it is larger
+// than the maximum size for integers.
+func (h *packHeader) getNumLimit(fmt *string, df int) int {
+	sz := getNum(fmt, df)
+	if sz > MAXINTSIZE || sz <= 0 {
+		h.ls.Errorf("integral size (%d) out of limits [1,%d]", sz, MAXINTSIZE)
+	}
+	return sz
+}
+
+// Read and classify next option, return it with its size.
+func (h *packHeader) getOption(fmt *string) (kOption, int) {
+	opt := (*fmt)[0]
+	*fmt = (*fmt)[1:]
+	switch opt {
+	case 'b':
+		return kInt, 1
+	case 'B':
+		return kUint, 1
+	case 'h':
+		return kInt, 2
+	case 'H':
+		return kUint, 2
+	case 'l', 'j':
+		return kInt, 8
+	case 'L', 'J', 'T':
+		return kUint, 8
+	case 'f':
+		return kFloat, 4
+	case 'd', 'n':
+		return kFloat, 8
+	case 'i':
+		return kInt, h.getNumLimit(fmt, 4)
+	case 'I':
+		return kUint, h.getNumLimit(fmt, 4)
+	case 's':
+		return kString, h.getNumLimit(fmt, 8)
+	case 'c':
+		size := getNum(fmt, -1)
+		if size == -1 {
+			h.ls.Errorf("missing size for format option 'c'")
+		}
+		return kChar, size
+	case 'z':
+		return kZstr, 0
+	case 'x':
+		return kPadding, 1
+	case 'X':
+		return kPaddAlign, 0
+	case ' ':
+	case '<':
+		h.little = true
+	case '>':
+		h.little = false
+	case '=':
+		h.little = true // native
+	case '!':
+		h.maxAlign = h.getNumLimit(fmt, MAXALIGN)
+	default:
+		h.ls.Errorf("invalid format option '%c'", opt)
+	}
+	return kNop, 0
+}
+
+// Read, classify and return the next option with its size and the
+// padding needed for its alignment, given the total size so far.
+func (h *packHeader) getDetails(totalSize int, fmt *string) (kOption, int, int) {
+	opt, size := h.getOption(fmt)
+	align := size          // usually, alignment follows size
+	if opt == kPaddAlign { // 'X' gets alignment from following option
+		if *fmt == "" {
+			h.ls.ArgError(1, "invalid next option for option 'X'")
+		} else {
+			var nextOpt kOption
+			nextOpt, align = h.getOption(fmt)
+			if nextOpt == kChar || align == 0 {
+				h.ls.ArgError(1, "invalid next option for option 'X'")
+			}
+		}
+	}
+
+	ntoAlign := 0
+	if align > 1 && opt != kChar { // need no alignment otherwise
+		if align > h.maxAlign { // enforce maximum alignment
+			align = h.maxAlign
+		}
+		if align&(align-1) != 0 { // is 'align' not a power of 2?
+			h.ls.ArgError(1, "format asks for alignment not power of 2")
+		}
+		ntoAlign = (align - totalSize&(align-1)) & (align - 1)
+	}
+	return opt, size, ntoAlign
+}
+
+// Pack the integer with given size and endianness,
+// bytes beyond the size of a lua integer are filled for the sign.
+func packInt(b *strings.Builder, n uint64, little bool, size int, neg bool) {
+	buff := make([]byte, size)
+	for i := 0; i < size && i < SZINT; i++ {
+		buff[i] = byte(n >> (i * NB))
+	}
+	for i := SZINT; i < size; i++ {
+		if neg {
+			buff[i] = 0xff
+		}
+	}
+
+	if !little {
+		reverseBytes(buff)
+	}
+	b.Write(buff)
+}
+
+// Unpack the integer with given size and endianness, bytes beyond the size
+// of a lua integer must be just for the sign.
+func (h *packHeader) unpackInt(s string, little bool, size int, signed bool) int64 {
+	buff := []byte(s[:size])
+	if !little {
+		reverseBytes(buff)
+	}
+
+	var res uint64
+	limit := size
+	if limit > SZINT {
+		limit = SZINT
+	}
+	for i := limit - 1; i >= 0; i-- {
+		res = res<<NB | uint64(buff[i])
+	}
+
+	if size < SZINT { // real size smaller than lua integer?
+		if signed { // needs sign extension?
+			mask := uint64(1) << (size*NB - 1)
+			res = (res ^ mask) - mask
+		}
+	} else if size > SZINT { // must check unread bytes
+		var mask byte
+		if signed && int64(res) < 0 {
+			mask = 0xff
+		}
+		for i := limit; i < size; i++ {
+			if buff[i] != mask {
+				h.ls.Errorf("%d-byte integer does not fit into Lua Integer", size)
+			}
+		}
+	}
+	return int64(res)
+}
+
+func reverseBytes(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+func (h *packHeader) byteOrder() binary.ByteOrder {
+	if h.little {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// string.pack (fmt, v1, v2, ···)
+func strPack(ls LuaState) int {
+	h := newPackHeader(ls)
+	fmt := ls.CheckString(1)
+	arg := 1 // current argument to pack
+	totalSize := 0
+	var b strings.Builder
+	for fmt != "" {
+		opt, size, ntoAlign := h.getDetails(totalSize, &fmt)
+		totalSize += ntoAlign + size
+		for ; ntoAlign > 0; ntoAlign-- {
+			b.WriteByte(PACKPADBYTE) // fill alignment
+		}
+
+		arg++
+		switch opt {
+		case kInt: // signed integers
+			n := ls.CheckInteger(arg)
+			if size < SZINT { // need overflow check?
+				lim := int64(1) << (size*NB - 1)
+				ls.ArgCheck(-lim <= n && n < lim, arg, "integer overflow")
+			}
+			packInt(&b, uint64(n), h.little, size, n < 0)
+		case kUint: // unsigned integers
+			n := ls.CheckInteger(arg)
+			if size < SZINT { // need overflow check?
+				ls.ArgCheck(uint64(n) < uint64(1)<<(size*NB), arg, "unsigned overflow")
+			}
+			packInt(&b, uint64(n), h.little, size, false)
+		case kFloat:
+			f := ls.CheckNumber(arg)
+			if size == 4 {
+				buff := make([]byte, 4)
+				h.byteOrder().PutUint32(buff, math.Float32bits(float32(f)))
+				b.Write(buff)
+			} else {
+				buff := make([]byte, 8)
+				h.byteOrder().PutUint64(buff, math.Float64bits(f))
+				b.Write(buff)
+			}
+		case kChar: // fixed-size string
+			s := ls.CheckString(arg)
+			ls.ArgCheck(len(s) <= size, arg, "string longer than given size")
+			b.WriteString(s)
+			for l := len(s); l < size; l++ { // pad extra space
+				b.WriteByte(PACKPADBYTE)
+			}
+		case kString: // strings with length count
+			s := ls.CheckString(arg)
+			ls.ArgCheck(size >= SZINT || uint64(len(s)) < uint64(1)<<(size*NB),
+				arg, "string length does not fit in given size")
+			packInt(&b, uint64(len(s)), h.little, size, false) // pack length
+			b.WriteString(s)
+			totalSize += len(s)
+		case kZstr: // zero-terminated string
+			s := ls.CheckString(arg)
+			ls.ArgCheck(strings.IndexByte(s, 0) < 0, arg, "string contains zeros")
+			b.WriteString(s)
+			b.WriteByte(0) // add zero at the end
+			totalSize += len(s) + 1
+		case kPadding:
+			b.WriteByte(PACKPADBYTE)
+			arg-- // undo increment
+		case kPaddAlign, kNop:
+			arg-- // undo increment
+		}
+	}
+
+	ls.PushString(b.String())
+	return 1
+}
+
+// string.packsize (fmt)
+func strPackSize(ls LuaState) int {
+	h := newPackHeader(ls)
+	fmt := ls.CheckString(1)
+	totalSize := 0 // accumulate total size of result
+	for fmt != "" {
+		opt, size, ntoAlign := h.getDetails(totalSize, &fmt)
+		ls.ArgCheck(opt != kString && opt != kZstr, 1, "variable-length format")
+		size += ntoAlign // total space used by option
+		ls.ArgCheck(totalSize <= MAXSIZE-size, 1, "format result too large")
+		totalSize += size
+	}
+
+	ls.PushInteger(int64(totalSize))
+	return 1
+}
+
+// string.unpack (fmt, s [, pos])
+// Return the unpacked values and the position after them.
+func strUnpack(ls LuaState) int {
+	h := newPackHeader(ls)
+	fmt := ls.CheckString(1)
+	data := ls.CheckString(2)
+	ld := len(data)
+	pos := int(posRelat(ls.OptInteger(3, 1), ld)) - 1
+	ls.ArgCheck(pos >= 0 && pos <= ld, 3, "initial position out of string")
+
+	n := 0 // number of results
+	for fmt != "" {
+		opt, size, ntoAlign := h.getDetails(pos, &fmt)
+		if pos+ntoAlign+size > ld {
+			ls.ArgError(2, "data string too short")
+		}
+		pos += ntoAlign // skip alignment
+		ls.CheckStack(2)
+		n++
+
+		switch opt {
+		case kInt, kUint:
+			ls.PushInteger(h.unpackInt(data[pos:], h.little, size, opt == kInt))
+		case kFloat:
+			if size == 4 {
+				ls.PushNumber(float64(math.Float32frombits(h.byteOrder().Uint32([]byte(data[pos:])))))
+			} else {
+				ls.PushNumber(math.Float64frombits(h.byteOrder().Uint64([]byte(data[pos:]))))
+			}
+		case kChar:
+			ls.PushString(data[pos : pos+size])
+		case kString:
+			l := int(h.unpackInt(data[pos:], h.little, size, false))
+			ls.ArgCheck(l >= 0 && pos+l+size <= ld, 2, "data string too short")
+			ls.PushString(data[pos+size : pos+size+l])
+			pos += l // skip string
+		case kZstr:
+			l := strings.IndexByte(data[pos:], 0)
+			ls.ArgCheck(l >= 0, 2, "unfinished string for format 'z'")
+			ls.PushString(data[pos : pos+l])
+			pos += l + 1 // skip string plus final '\0'
+		case kPaddAlign, kPadding, kNop:
+			n-- // undo increment
+		}
+		pos += size
+	}
+
+	ls.PushInteger(int64(pos) + 1) // next position
+	return n + 1
+}
@@ -0,0 +1,413 @@
+/*
+The pattern matching engine of the string library, it works
+on bytes exactly like the one of the reference implementation.
+Positions in the subject and the pattern are indices of bytes,
+-1 stands for a failed match.
+*/
+package stdlib
+
+import (
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+const (
+	LUA_MAXCAPTURES = 32
+	MAXCCALLS       = 200 // maximum recursion depth of match()
+	L_ESC           = '%'
+	SPECIALS        = "^$*+?.([%-"
+)
+
+// special values of the length of a capture
+const (
+	CAP_UNFINISHED = -1
+	CAP_POSITION   = -2
+)
+
+type capture struct {
+	init int
+	len  int
+}
+
+type matchState struct {
+	src        string // subject
+	pat        string // pattern, without the leading anchor
+	level      int    // total number of captures (finished or unfinished)
+	matchDepth int    // control for recursive depth (to avoid stack overflow)
+	capture    [LUA_MAXCAPTURES]capture
+	ls         LuaState
+}
+
+func newMatchState(ls LuaState, src, pat string) *matchState {
+	return &matchState{src: src, pat: pat, ls: ls}
+}
+
+// Prepare the state for a new match.
+func (ms *matchState) reprep() {
+	ms.level = 0
+	ms.matchDepth = MAXCCALLS
+}
+
+// Return the byte of the pattern at given index or 0 beyond its end,
+// like the '\0' terminating C strings.
+func (ms *matchState) patAt(p int) byte {
+	if p < len(ms.pat) {
+		return ms.pat[p]
+	}
+	return 0
+}
+
+func (ms *matchState) checkCapture(l byte) int {
+	i := int(l) - '1'
+	if i < 0 || i >= ms.level || ms.capture[i].len == CAP_UNFINISHED {
+		ms.ls.Errorf("invalid capture index %%%d", i+1)
+	}
+	return i
+}
+
+func (ms *matchState) captureToClose() int {
+	for level := ms.level - 1; level >= 0; level-- {
+		if ms.capture[level].len == CAP_UNFINISHED {
+			return level
+		}
+	}
+	ms.ls.Errorf("invalid pattern capture")
+	return 0
+}
+
+// Return the end of the single char class starting at p.
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case L_ESC:
+		if p >= len(ms.pat) {
+			ms.ls.Errorf("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if ms.patAt(p) == '^' {
+			p++
+		}
+		for { // look for a ']'
+			if p >= len(ms.pat) {
+				ms.ls.Errorf("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == L_ESC && p < len(ms.pat) {
+				p++ // skip escapes (e.g. '%]')
+			}
+			if ms.patAt(p) == ']' {
+				return p + 1
+			}
+		}
+	default:
+		return p
+	}
+}
+
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 { // to lower case
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < 32 || c == 127
+	case 'd':
+		res = isDigit(c)
+	case 'g':
+		res = c > 32 && c < 127
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = c > 32 && c < 127 && !isAlpha(c) && !isDigit(c)
+	case 's':
+		res = c == ' ' || c >= '\t' && c <= '\r'
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = isAlpha(c) || isDigit(c)
+	case 'x':
+		res = isDigit(c) || c|0x20 >= 'a' && c|0x20 <= 'f'
+	default:
+		return cl == c
+	}
+
+	if cl >= 'A' && cl <= 'Z' { // upper case classes are complements
+		return !res
+	}
+	return res
+}
+
+func isAlpha(c byte) bool {
+	return c|0x20 >= 'a' && c|0x20 <= 'z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Whether c matches the set [...] between p('[') and ec(']').
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++ // skip the '^'
+	}
+
+	for p++; p < ec; p++ {
+		if ms.pat[p] == L_ESC {
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		} else if ms.pat[p+1] == '-' && p+2 < ec {
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		} else if ms.pat[p] == c {
+			return sig
+		}
+	}
+
+	return !sig
+}
+
+// Whether the byte at s matches the single char class between p and ep.
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true // matches any char
+	case L_ESC:
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pat) {
+		ms.ls.Errorf("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		if ms.src[s] == e {
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		} else if ms.src[s] == b {
+			cont++
+		}
+	}
+
+	return -1 // string ends out of balance
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0 // counts maximum expand for item
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	// keeps trying to match with the maximum repetitions
+	for ; i >= 0; i-- {
+		if res := ms.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res != -1 {
+			return res
+		} else if ms.singleMatch(s, p, ep) {
+			s++ // try with one more repetition
+		} else {
+			return -1
+		}
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	if ms.level >= LUA_MAXCAPTURES {
+		ms.ls.Errorf("too many captures")
+	}
+
+	ms.capture[ms.level] = capture{s, what}
+	ms.level++
+	res := ms.match(s, p)
+	if res == -1 { // match failed?
+		ms.level-- // undo capture
+	}
+
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init // close capture
+	res := ms.match(s, p)
+	if res == -1 { // match failed?
+		ms.capture[l].len = CAP_UNFINISHED // undo capture
+	}
+
+	return res
+}
+
+func (ms *matchState) matchCapture(s int, l byte) int {
+	i := ms.checkCapture(l)
+	c := ms.capture[i]
+	if len(ms.src)-s >= c.len && ms.src[c.init:c.init+c.len] == ms.src[s:s+c.len] {
+		return s + c.len
+	}
+
+	return -1
+}
+
+// Match the subject from s against the pattern from p,
+// return the end of the match or -1 if it fails.
+func (ms *matchState) match(s, p int) int {
+	if ms.matchDepth == 0 {
+		ms.ls.Errorf("pattern too complex")
+	}
+	ms.matchDepth--
+	defer func() { ms.matchDepth++ }()
+
+	for p < len(ms.pat) { // loop instead of tail calls
+		switch ms.pat[p] {
+		case '(': // start capture
+			if ms.patAt(p+1) == ')' { // position capture?
+				return ms.startCapture(s, p+2, CAP_POSITION)
+			}
+			return ms.startCapture(s, p+1, CAP_UNFINISHED)
+		case ')': // end capture
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pat) { // is the '$' the last char in pattern?
+				if s == len(ms.src) { // check end of string
+					return s
+				}
+				return -1
+			}
+		case L_ESC: // escaped sequences not in the format class[*+?-]?
+			switch c := ms.patAt(p + 1); {
+			case c == 'b': // balanced string?
+				if s = ms.matchBalance(s, p+2); s == -1 {
+					return -1
+				}
+				p += 4
+				continue
+			case c == 'f': // frontier?
+				p += 2
+				if ms.patAt(p) != '[' {
+					ms.ls.Errorf("missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p) // points to what is next
+				var previous, current byte
+				if s > 0 {
+					previous = ms.src[s-1]
+				}
+				if s < len(ms.src) {
+					current = ms.src[s]
+				}
+				if !ms.matchBracketClass(previous, p, ep-1) &&
+					ms.matchBracketClass(current, p, ep-1) {
+					p = ep
+					continue
+				}
+				return -1 // match failed
+			case isDigit(c): // capture results (%0-%9)?
+				if s = ms.matchCapture(s, c); s == -1 {
+					return -1
+				}
+				p += 2
+				continue
+			}
+		}
+
+		// default: pattern class plus optional suffix
+		ep := ms.classEnd(p)           // points to optional suffix
+		if !ms.singleMatch(s, p, ep) { // does not match at least once?
+			switch ms.patAt(ep) {
+			case '*', '?', '-': // accept empty?
+				p = ep + 1
+				continue
+			default: // '+' or no suffix
+				return -1
+			}
+		}
+
+		// matched once
+		switch ms.patAt(ep) { // handle optional suffix
+		case '?': // optional
+			if res := ms.match(s+1, ep+1); res != -1 {
+				return res
+			}
+			p = ep + 1
+		case '+': // 1 or more repetitions
+			return ms.maxExpand(s+1, p, ep) // 1 match already done
+		case '*': // 0 or more repetitions
+			return ms.maxExpand(s, p, ep)
+		case '-': // 0 or more repetitions (minimum)
+			return ms.minExpand(s, p, ep)
+		default: // no suffix
+			s++
+			p = ep
+		}
+	}
+
+	return s // end of pattern
+}
+
+// Push the ith capture of the match between s and e,
+// the whole match counts as the first one if there is no capture.
+func (ms *matchState) pushOneCapture(i, s, e int) {
+	if i >= ms.level {
+		if i != 0 {
+			ms.ls.Errorf("invalid capture index %%%d", i+1)
+		}
+		ms.ls.PushString(ms.src[s:e]) // add whole match
+		return
+	}
+
+	c := ms.capture[i]
+	switch c.len {
+	case CAP_UNFINISHED:
+		ms.ls.Errorf("unfinished capture")
+	case CAP_POSITION:
+		ms.ls.PushInteger(int64(c.init) + 1)
+	default:
+		ms.ls.PushString(ms.src[c.init : c.init+c.len])
+	}
+}
+
+// Push all captures and return their number,
+// the whole match is pushed if there is no capture and wholeIfNone.
+func (ms *matchState) pushCaptures(s, e int, wholeIfNone bool) int {
+	n := ms.level
+	if n == 0 && wholeIfNone {
+		n = 1
+	}
+
+	ms.ls.CheckStack(n)
+	for i := 0; i < n; i++ {
+		ms.pushOneCapture(i, s, e)
+	}
+
+	return n
+}
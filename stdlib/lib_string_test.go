@@ -0,0 +1,128 @@
+package stdlib_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/gonearewe/lua-compiler/api"
+	"github.com/gonearewe/lua-compiler/state"
+	"github.com/gonearewe/lua-compiler/stdlib"
+)
+
+// Evaluate the lua expressions, return their values converted by tostring()
+// and separated by tabs like print() does, or the message of the error
+// without the position.
+func eval(t *testing.T, exps string) string {
+	t.Helper()
+	ls := state.New()
+	stdlib.OpenLibs(ls)
+	return evalIn(t, ls, exps)
+}
+
+// eval() in the given lua state, whose stack is empty.
+func evalIn(t *testing.T, ls LuaState, exps string) string {
+	t.Helper()
+	defer ls.SetTop(0)
+	if ls.Load([]byte("return "+exps), "=test", "t") != LUA_OK {
+		t.Fatalf("%s: %s", exps, ls.ToString(-1))
+	}
+	if ls.PCall(0, LUA_MULTRET, 0) != LUA_OK {
+		return "error: " + strings.TrimPrefix(ls.ToString(-1), "test:1: ")
+	}
+
+	vals := make([]string, ls.GetTop())
+	for i := range vals {
+		vals[i] = ls.ToStringMeta(i + 1)
+		ls.Pop(1)
+	}
+	return strings.Join(vals, "\t")
+}
+
+type evalTest struct {
+	exps string
+	want string
+}
+
+func runEvalTests(t *testing.T, tests []evalTest) {
+	t.Helper()
+	for _, test := range tests {
+		if got := eval(t, test.exps); got != test.want {
+			t.Errorf("%s = %q, want %q", test.exps, got, test.want)
+		}
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		// find
+		{`string.find("hello world", "o w")`, "5\t7"},
+		{`string.find("hello", "l+")`, "3\t4"},
+		{`string.find("a+b", "+", 1, true)`, "2\t2"},
+		{`string.find("a.b", "%.")`, "2\t2"},
+		{`string.find("aaa", "a-", 2)`, "2\t1"},
+		{`string.find("abc", "[^a-b]")`, "3\t3"},
+		{`string.find("x]y", "[]]")`, "2\t2"},
+		{`string.find("abc", "b", -1)`, "nil"},
+		{`string.find("abc", "", 10)`, "nil"},
+		// match and captures
+		{`string.match("key = value", "(%w+)%s*=%s*(%w+)")`, "key\tvalue"},
+		{`string.match("  trim  ", "^%s*(.-)%s*$")`, "trim"},
+		{`string.match("  x", "^x")`, "nil"},
+		{`string.match("hello", "()ll()")`, "3\t5"},
+		{`string.match("2024-01-02", "(%d+)-(%d+)-(%d+)")`, "2024\t01\t02"},
+		{`string.match("THE (quick) fox", "%((%a+)%)")`, "quick"},
+		{`string.match("f(a(b)c)d", "%b()")`, "(a(b)c)"},
+		{`string.match("abcabc", "(a)(b)c%1%2")`, "a\tb"},
+		{`string.match("[[x]]", "%[(=*)%[(.-)%]%1%]")`, "\tx"},
+		// gsub
+		{`string.gsub("hello world", "(%w+)", "<%1>")`, "<hello> <world>\t2"},
+		{`string.gsub("abc", "", "-")`, "-a-b-c-\t4"},
+		{`string.gsub("abc", "%w", "%0%0", 2)`, "aabbc\t2"},
+		{`string.gsub("hello", "l", {l = "L"})`, "heLLo\t2"},
+		{`string.gsub("$x $y", "%$(%w+)", function(k) if k == "x" then return 1 end end)`, "1 $y\t2"},
+		{`string.gsub("THE (quick) fox", "%f[%a]%a+", "W")`, "W (W) W\t3"},
+		{`string.gsub("a,b", ",", "%%")`, "a%b\t1"},
+		// gmatch
+		{`(function()
+			local t = {}
+			for k, v in string.gmatch("a=1, b=2", "(%w+)=(%w+)") do t[#t + 1] = k .. v end
+			return table.concat(t, ",")
+		end)()`, "a1,b2"},
+		// errors
+		{`string.find("a", "%")`, "error: malformed pattern (ends with '%')"},
+		{`string.find("a", "[a")`, "error: malformed pattern (missing ']')"},
+		{`string.match("a", "(a")`, "error: unfinished capture"},
+		{`string.match("a", "%1")`, "error: invalid capture index %1"},
+		{`string.match("a", "a)")`, "error: invalid pattern capture"},
+		{`string.match("a", "%b")`, "error: malformed pattern (missing arguments to '%b')"},
+		{`string.match("a", "%fa")`, "error: missing '[' after '%f' in pattern"},
+		{`string.gsub("a", "a", "%2")`, "error: invalid capture index %2"},
+	})
+}
+
+func TestPack(t *testing.T) {
+	runEvalTests(t, []evalTest{
+		{`string.pack("<i4", 100):byte(1, -1)`, "100\t0\t0\t0"},
+		{`string.pack(">i2", 0x1234):byte(1, -1)`, "18\t52"},
+		{`string.pack("<I2 x I2", 1, 2):byte(1, -1)`, "1\t0\t0\t2\t0"},
+		{`string.unpack("<i2", "\x34\x12")`, "4660\t3"},
+		{`string.unpack("B", "\xff")`, "255\t2"},
+		{`string.unpack("b", "\xff")`, "-1\t2"},
+		{`string.unpack("i3", string.pack("i3", -2))`, "-2\t4"},
+		{`string.unpack("<i16", string.pack("<i16", -3))`, "-3\t17"},
+		{`string.unpack("j", string.pack("j", math.mininteger))`, "-9223372036854775808\t9"},
+		{`string.unpack("d", string.pack("d", 1.5))`, "1.5\t9"},
+		{`string.unpack("z", "hello\0rest")`, "hello\t7"},
+		{`string.unpack("s1", string.pack("s1", "abc"))`, "abc\t5"},
+		{`string.unpack("c3", "abcdef", 2)`, "bcd\t5"},
+		{`string.unpack("!4 i1 i4", string.pack("!4 i1 i4", 1, 2))`, "1\t2\t9"},
+		{`string.packsize("i4i8")`, "12"},
+		{`string.packsize("!8i4i8")`, "16"},
+		// errors
+		{`string.pack("i17", 1)`, "error: integral size (17) out of limits [1,16]"},
+		{`string.pack("y", 1)`, "error: invalid format option 'y'"},
+		{`string.pack("c", "")`, "error: missing size for format option 'c'"},
+		{`string.unpack("i4", "abc")`, "error: bad argument #2 to 'unpack' (data string too short)"},
+		{`string.unpack("<i9", "\0\0\0\0\0\0\0\0\1")`, "error: 9-byte integer does not fit into Lua Integer"},
+	})
+}
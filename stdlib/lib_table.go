@@ -0,0 +1,423 @@
+/*
+The table library, functions work on sequences and
+honor metamethods "__index", "__newindex" and "__len".
+*/
+package stdlib
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	. "github.com/gonearewe/lua-compiler/api"
+)
+
+var tabLib = FuncReg{
+	"concat": tabConcat,
+	"insert": tabInsert,
+	"move":   tabMove,
+	"pack":   tabPack,
+	"remove": tabRemove,
+	"sort":   tabSort,
+	"unpack": tabUnpack,
+}
+
+// operations needed from a table argument
+const (
+	TAB_R  = 1             // read
+	TAB_W  = 2             // write
+	TAB_L  = 4             // length
+	TAB_RW = TAB_R | TAB_W // read/write
+)
+
+func OpenTable(ls LuaState) int {
+	ls.NewLib(tabLib)
+
+	return 1
+}
+
+// Access to the elements of the table at some index; plain tables without
+// metatables are accessed raw, which goes straight to the array part.
+type tabAccessor struct {
+	ls  LuaState
+	idx int
+	raw bool
+}
+
+func newTabAccessor(ls LuaState, idx int) tabAccessor {
+	raw := false
+	if ls.IsTable(idx) {
+		if ls.GetMetatable(idx) {
+			ls.Pop(1) // pop the metatable
+		} else {
+			raw = true
+		}
+	}
+
+	return tabAccessor{ls, ls.AbsIndex(idx), raw}
+}
+
+// Push t[i].
+func (t tabAccessor) get(i int64) LuaType {
+	if t.raw {
+		return t.ls.RawGetI(t.idx, i)
+	}
+	return t.ls.GetI(t.idx, i)
+}
+
+// Pop a value and set it as t[i].
+func (t tabAccessor) set(i int64) {
+	if t.raw {
+		t.ls.RawSetI(t.idx, i)
+	} else {
+		t.ls.SetI(t.idx, i)
+	}
+}
+
+func (t tabAccessor) len() int64 {
+	if t.raw {
+		return int64(t.ls.RawLen(t.idx))
+	}
+	return t.ls.LenInt(t.idx)
+}
+
+// Check that the argument is a table or behaves like one
+// for the required operations through its metamethods.
+func checkTab(ls LuaState, arg, what int) {
+	if ls.Type(arg) == LUA_TTABLE {
+		return
+	}
+
+	n := 1                     // number of elements to pop
+	if ls.GetMetatable(arg) && // must have metatable
+		(what&TAB_R == 0 || checkField(ls, "__index", &n)) &&
+		(what&TAB_W == 0 || checkField(ls, "__newindex", &n)) &&
+		(what&TAB_L == 0 || checkField(ls, "__len", &n)) {
+		ls.Pop(n) // pop metatable and tested metamethods
+	} else {
+		ls.CheckType(arg, LUA_TTABLE) // force an error
+	}
+}
+
+func checkField(ls LuaState, key string, n *int) bool {
+	*n++
+	ls.PushString(key)
+	return ls.RawGet(-*n) != LUA_TNIL
+}
+
+// Check the table argument and return an accessor and its length.
+func auxGetN(ls LuaState, arg, what int) (tabAccessor, int64) {
+	checkTab(ls, arg, what|TAB_L)
+	t := newTabAccessor(ls, arg)
+	return t, t.len()
+}
+
+// table.insert (list, [pos,] value)
+func tabInsert(ls LuaState) int {
+	t, n := auxGetN(ls, 1, TAB_RW)
+	e := n + 1    // first empty element
+	var pos int64 // where to insert new element
+	switch ls.GetTop() {
+	case 2: // called with only 2 arguments
+		pos = e // insert new element at the end
+	case 3:
+		pos = ls.CheckInteger(2) // 2nd argument is the position
+		ls.ArgCheck(1 <= pos && pos <= e, 2, "position out of bounds")
+		for i := e; i > pos; i-- { // move up elements
+			t.get(i - 1)
+			t.set(i) // t[i] = t[i - 1]
+		}
+	default:
+		return ls.Errorf("wrong number of arguments to 'insert'")
+	}
+
+	t.set(pos) // t[pos] = v
+	return 0
+}
+
+// table.remove (list [, pos])
+func tabRemove(ls LuaState) int {
+	t, size := auxGetN(ls, 1, TAB_RW)
+	pos := ls.OptInteger(2, size)
+	if pos != size { // validate 'pos' if given
+		ls.ArgCheck(1 <= pos && pos <= size+1, 1, "position out of bounds")
+	}
+
+	t.get(pos) // result = t[pos]
+	for ; pos < size; pos++ {
+		t.get(pos + 1)
+		t.set(pos) // t[pos] = t[pos + 1]
+	}
+	ls.PushNil()
+	t.set(pos) // t[pos] = nil
+
+	return 1
+}
+
+// table.move (a1, f, e, t [,a2])
+// Move elements a1[f], ···, a1[e] into a2[t], ···, the destination
+// table a2 is a1 by default, return a2.
+func tabMove(ls LuaState) int {
+	f := ls.CheckInteger(2)
+	e := ls.CheckInteger(3)
+	t := ls.CheckInteger(4)
+	tt := 1 // destination table
+	if !ls.IsNoneOrNil(5) {
+		tt = 5
+	}
+	checkTab(ls, 1, TAB_R)
+	checkTab(ls, tt, TAB_W)
+
+	if e >= f { // otherwise, nothing to move
+		ls.ArgCheck(f > 0 || e < math.MaxInt64+f, 3, "too many elements to move")
+		n := e - f + 1 // number of elements to move
+		ls.ArgCheck(t <= math.MaxInt64-n+1, 4, "destination wrap around")
+
+		src, dst := newTabAccessor(ls, 1), newTabAccessor(ls, tt)
+		if t > e || t <= f || (tt != 1 && !ls.Compare(1, tt, LUA_OPEQ)) {
+			for i := int64(0); i < n; i++ {
+				src.get(f + i)
+				dst.set(t + i)
+			}
+		} else { // overlapping, move from the end
+			for i := n - 1; i >= 0; i-- {
+				src.get(f + i)
+				dst.set(t + i)
+			}
+		}
+	}
+
+	ls.PushValue(tt) // return destination table
+	return 1
+}
+
+// table.concat (list [, sep [, i [, j]]])
+func tabConcat(ls LuaState) int {
+	t, last := auxGetN(ls, 1, TAB_R)
+	sep := ls.OptString(2, "")
+	i := ls.OptInteger(3, 1)
+	last = ls.OptInteger(4, last)
+
+	var b strings.Builder
+	for ; i <= last; i++ {
+		t.get(i)
+		if !ls.IsString(-1) {
+			ls.Errorf("invalid value (at index %d) in table for 'concat'", i)
+		}
+		b.WriteString(ls.ToString(-1))
+		ls.Pop(1)
+		if i != last {
+			b.WriteString(sep)
+		}
+		if i == math.MaxInt64 { // avoid overflows
+			break
+		}
+	}
+
+	ls.PushString(b.String())
+	return 1
+}
+
+// table.pack (···)
+// Return a new table with all arguments and field "n" for their number.
+func tabPack(ls LuaState) int {
+	n := ls.GetTop()          // number of elements to pack
+	ls.CreateTable(n, 1)      // create result table
+	ls.Insert(1)              // put it at index 1
+	for i := n; i >= 1; i-- { // assign elements
+		ls.RawSetI(1, int64(i))
+	}
+	ls.PushInteger(int64(n))
+	ls.SetField(1, "n") // t.n = number of elements
+
+	return 1 // return table
+}
+
+// table.unpack (list [, i [, j]])
+func tabUnpack(ls LuaState) int {
+	t := newTabAccessor(ls, 1)
+	i := ls.OptInteger(2, 1)
+	var e int64
+	if ls.IsNoneOrNil(3) {
+		e = ls.LenInt(1)
+	} else {
+		e = ls.CheckInteger(3)
+	}
+	if i > e {
+		return 0 // empty range
+	}
+
+	n := uint64(e) - uint64(i) // number of elements minus 1 (avoid overflows)
+	if n >= math.MaxInt32 || !ls.CheckStack(int(n+1)) {
+		return ls.Errorf("too many results to unpack")
+	}
+	for ; i < e; i++ { // push arg[i..e - 1] (to avoid overflows)
+		t.get(i)
+	}
+	t.get(e) // push last element
+
+	return int(n + 1)
+}
+
+/**************************
+sort, a quicksort port of the reference implementation
+**************************/
+
+// size of intervals above which pivots are chosen randomly when necessary
+const RANLIMIT = 100
+
+// table.sort (list [, comp])
+func tabSort(ls LuaState) int {
+	t, n := auxGetN(ls, 1, TAB_RW)
+	if n > 1 { // non-trivial interval?
+		ls.ArgCheck(n < math.MaxInt32, 1, "array too big")
+		if !ls.IsNoneOrNil(2) { // is there a 2nd argument?
+			ls.CheckType(2, LUA_TFUNCTION) // must be a function
+		}
+		ls.SetTop(2) // make sure there are two arguments
+		s := sorter{ls, t}
+		s.auxSort(1, n, 0)
+	}
+
+	return 0
+}
+
+type sorter struct {
+	ls LuaState
+	t  tabAccessor
+}
+
+// Pop two values and set them as t[i] and t[j].
+func (s sorter) set2(i, j int64) {
+	s.t.set(i)
+	s.t.set(j)
+}
+
+// Whether the value at index a is less than the one at index b,
+// by the comparator or by '<' if there is no comparator.
+func (s sorter) sortComp(a, b int) bool {
+	ls := s.ls
+	if ls.IsNil(2) { // no function?
+		return ls.Compare(a, b, LUA_OPLT) // a < b
+	}
+
+	ls.PushValue(2)     // push function
+	ls.PushValue(a - 1) // -1 to compensate function
+	ls.PushValue(b - 2) // -2 to compensate function and 'a'
+	ls.Call(2, 1)       // call function
+	res := ls.ToBoolean(-1)
+	ls.Pop(1) // pop result
+	return res
+}
+
+// Partition the interval with the pivot P on the top of the stack,
+// return the final position of the pivot.
+func (s sorter) partition(lo, up int64) int64 {
+	ls := s.ls
+	i := lo     // will be incremented before first use
+	j := up - 1 // will be decremented before first use
+	// loop invariant: a[lo .. i] <= P <= a[j .. up], a[up - 1] == P
+	for {
+		// next loop: repeat ++i while a[i] < P
+		for {
+			i++
+			s.t.get(i)
+			if !s.sortComp(-1, -2) {
+				break
+			}
+			if i == up-1 { // a[i] < P  but a[up - 1] == P  ??
+				ls.Errorf("invalid order function for sorting")
+			}
+			ls.Pop(1) // remove a[i]
+		}
+		// after the loop, a[i] >= P and a[lo .. i - 1] < P
+		// next loop: repeat --j while P < a[j]
+		for {
+			j--
+			s.t.get(j)
+			if !s.sortComp(-3, -1) {
+				break
+			}
+			if j < i { // j < i  but  a[j] > P ??
+				ls.Errorf("invalid order function for sorting")
+			}
+			ls.Pop(1) // remove a[j]
+		}
+		// after the loop, a[j] <= P and a[j + 1 .. up] >= P
+		if j < i { // no elements to be exchanged?
+			ls.Pop(1) // pop a[j]
+			// swap pivot (a[up - 1]) with a[i] to satisfy pred.: a[up - 1] == P
+			s.set2(up-1, i)
+			return i
+		}
+		// otherwise, swap a[i] - a[j] to restore invariant and repeat
+		s.set2(i, j)
+	}
+}
+
+// Choose an element in the middle half of the interval.
+func choosePivot(lo, up int64, rnd uint64) int64 {
+	r4 := (up - lo) / 4 // range/4
+	return int64(rnd%uint64(r4*2)) + lo + r4
+}
+
+func (s sorter) auxSort(lo, up int64, rnd uint64) {
+	ls := s.ls
+	for lo < up { // loop for tail recursion
+		// sort elements 'lo', 'p', and 'up'
+		s.t.get(lo)
+		s.t.get(up)
+		if s.sortComp(-1, -2) { // a[up] < a[lo]?
+			s.set2(lo, up) // swap a[lo] - a[up]
+		} else {
+			ls.Pop(2) // remove both values
+		}
+		if up-lo == 1 { // only 2 elements?
+			break // already sorted
+		}
+
+		var p int64                       // Pivot index
+		if up-lo < RANLIMIT || rnd == 0 { // small interval or no randomize?
+			p = (lo + up) / 2 // middle element is a good pivot
+		} else { // for larger intervals, it is expensive to compute something
+			p = choosePivot(lo, up, rnd)
+		}
+		s.t.get(p)
+		s.t.get(lo)
+		if s.sortComp(-2, -1) { // a[p] < a[lo]?
+			s.set2(p, lo) // swap a[p] - a[lo]
+		} else {
+			ls.Pop(1) // remove second element
+			s.t.get(up)
+			if s.sortComp(-1, -2) { // a[up] < a[p]?
+				s.set2(p, up) // swap up - p
+			} else {
+				ls.Pop(2) // clean stack
+			}
+		}
+		if up-lo == 2 { // only 3 elements?
+			break // already sorted
+		}
+
+		s.t.get(p)       // get median (Pivot)
+		ls.PushValue(-1) // push Pivot
+		s.t.get(up - 1)  // push a[up - 1]
+		s.set2(p, up-1)  // a[p] = a[up - 1]; a[up - 1] = a[p]
+		p = s.partition(lo, up)
+
+		var n int64 // size of smaller interval
+		// a[lo .. p - 1] <= a[p] == P <= a[p + 1 .. up]
+		if p-lo < up-p { // lower interval is shorter?
+			s.auxSort(lo, p-1, rnd) // call recursively for lower interval
+			n = p - lo
+			lo = p + 1 // tail call for [p + 1 .. up] (upper interval)
+		} else {
+			s.auxSort(p+1, up, rnd) // call recursively for upper interval
+			n = up - p
+			up = p - 1 // tail call for [lo .. p - 1]  (lower interval)
+		}
+		if (up-lo)/128 > n { // partition too imbalanced?
+			rnd = uint64(time.Now().UnixNano()) // try a new randomization
+		}
+	}
+}
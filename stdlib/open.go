@@ -0,0 +1,27 @@
+package stdlib
+
+import . "github.com/gonearewe/lua-compiler/api"
+
+// standard libraries in the order of opening, with their module names
+var libs = []struct {
+	name string
+	open GoFunction
+}{
+	{"_G", OpenBase},
+	{"coroutine", OpenCoroutine},
+	{"string", OpenString},
+	{"table", OpenTable},
+	{"math", OpenMath},
+	{"io", OpenIO},
+	{"os", OpenOS},
+	{"debug", OpenDebug},
+}
+
+// Open all standard libraries, each one is set as a global
+// variable and recorded in the loaded table of the registry.
+func OpenLibs(ls LuaState) {
+	for _, lib := range libs {
+		ls.RequireF(lib.name, lib.open, true)
+		ls.Pop(1)
+	}
+}
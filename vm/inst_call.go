@@ -15,7 +15,17 @@ func call(i Instruction, vm LuaVM) {
 	a += 1
 
 	nArgs := _pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
+	if vm.PreCall(nArgs, c-1) { // lua function, postCall() is done when it returns
+		return
+	}
+	_popResults(a, c, vm)
+}
+
+// Move the results of the lua function called by CALL to the registers.
+func postCall(i Instruction, vm LuaVM) {
+	a, _, c := i.ABC()
+	a += 1
+
 	_popResults(a, c, vm)
 }
 
@@ -35,8 +45,9 @@ func tailCall(i Instruction, vm LuaVM) {
 	c := 0
 
 	nArgs := _pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	_popResults(a, c, vm)
+	if !vm.TailCall(nArgs) { // go function, return its results
+		_popResults(a, c, vm)
+	}
 }
 
 func tForCall(i Instruction, vm LuaVM) {
@@ -7,6 +7,15 @@ func forPrep(i Instruction, vm LuaVM) {
 	a, sBx := i.AsBx()
 	a += 1
 
+	if !vm.IsNumber(a + 1) {
+		vm.RunError("'for' limit must be a number")
+	}
+	if !vm.IsNumber(a + 2) {
+		vm.RunError("'for' step must be a number")
+	}
+	if !vm.IsNumber(a) {
+		vm.RunError("'for' initial value must be a number")
+	}
 	if vm.Type(a) == LUA_TSTRING {
 		vm.PushNumber(vm.ToNumber(a))
 		vm.Replace(a)
@@ -45,11 +45,10 @@ func setList(i Instruction, vm api.LuaVM) {
 		vm.Pop(1)
 	}
 
-	if c > 0 {
-		c = c - 1
-	} else {
+	if c == 0 { // too big for c, it's in the next EXTRAARG instruction
 		c = Instruction(vm.Fetch()).Ax()
 	}
+	c--
 
 	idx := int64(c * LFIELDS_PER_FLUSH)
 	for j := 1; j <= b; j++ {
@@ -52,6 +52,11 @@ func (self Instruction) OpMode() byte {
 	return opcodes[self.Opcode()].opMode
 }
 
+// whether the instruction sets register A
+func (self Instruction) TestAMode() bool {
+	return opcodes[self.Opcode()].setAFlag == 1
+}
+
 func (self Instruction) BMode() byte {
 	return opcodes[self.Opcode()].argBMode
 }
@@ -68,3 +73,9 @@ func (self Instruction) Execute(vm api.LuaVM) {
 		panic(self.OpName())
 	}
 }
+
+// Finish the CALL instruction after the lua function it calls returns
+// with the results on the top of the stack.
+func (self Instruction) FinishCall(vm api.LuaVM) {
+	postCall(self, vm)
+}